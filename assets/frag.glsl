@@ -1,13 +1,42 @@
-// switch to higher version when needed, try to keep it low for compatibility
-#version 330 core
-
 layout(location = 0) out vec4 color;
 
 in vec2 v_TexCoord;
+flat in int v_Layer;
 
 uniform sampler2D u_Texture;
 
+// every instance samples its own layer of the array instead of u_Texture when this is set
+uniform bool u_UseTextureArray;
+uniform sampler2DArray u_TextureArray;
+
+// 0 unless the material uses alpha testing, texels with less alpha than this get thrown away
+uniform float u_AlphaCutoff;
+// the material's opacity, which fades the texture's alpha
+uniform float u_Opacity;
+// the texture's color is already multiplied by alpha, so fading it has to scale the color too
+uniform bool u_PremultipliedAlpha;
+
+// colors coming out of srgb textures are linear, all the math in here should stay that way
+#include "srgb.glsl"
+
 void main() {
-  vec4 texColor = texture(u_Texture, v_TexCoord);
-  color = texColor;
+  vec4 texColor;
+  if (u_UseTextureArray) {
+    texColor = texture(u_TextureArray, vec3(v_TexCoord, v_Layer));
+  } else {
+    texColor = texture(u_Texture, v_TexCoord);
+  }
+  if (texColor.a < u_AlphaCutoff) {
+    discard;
+  }
+
+  if (u_PremultipliedAlpha) {
+    color = texColor * u_Opacity;
+  } else {
+    color = vec4(texColor.rgb, texColor.a * u_Opacity);
+  }
+
+  if (u_EncodeSRGB) {
+    color.rgb = linearToSRGB(clamp(color.rgb, 0.0, 1.0));
+  }
 }
\ No newline at end of file
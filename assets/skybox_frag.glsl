@@ -0,0 +1,15 @@
+layout(location = 0) out vec4 color;
+
+in vec3 v_Direction;
+
+uniform samplerCube u_Skybox;
+
+#include "srgb.glsl"
+
+void main() {
+  color = vec4(texture(u_Skybox, v_Direction).rgb, 1.0);
+
+  if (u_EncodeSRGB) {
+    color.rgb = linearToSRGB(clamp(color.rgb, 0.0, 1.0));
+  }
+}
@@ -0,0 +1,15 @@
+// the camera's projection and rotation (no translation, the sky is infinitely far away), inverted
+uniform mat4 u_InverseViewProjection;
+
+out vec3 v_Direction;
+
+void main() {
+  // one triangle that covers the whole screen, no vertex buffer needed
+  vec2 position = vec2((gl_VertexID << 1) & 2, gl_VertexID & 2) * 2.0 - 1.0;
+
+  // z = w puts it at max depth, so it only shows up where nothing else got drawn
+  gl_Position = vec4(position, 1.0, 1.0);
+
+  vec4 direction = u_InverseViewProjection * vec4(position, 1.0, 1.0);
+  v_Direction = direction.xyz / direction.w;
+}
@@ -0,0 +1,11 @@
+// shared by every fragment shader, shaders that draw to the screen have to encode when the framebuffer can't
+#pragma once
+
+// set when the framebuffer can't encode to sRGB by itself
+uniform bool u_EncodeSRGB;
+
+vec3 linearToSRGB(vec3 value) {
+  vec3 low = value * 12.92;
+  vec3 high = 1.055 * pow(value, vec3(1.0 / 2.4)) - 0.055;
+  return mix(high, low, vec3(lessThanEqual(value, vec3(0.0031308))));
+}
@@ -1,20 +1,23 @@
-// switch to higher version when needed, try to keep it low for compatibility
-#version 330 core
-
 layout(location = 0) in vec4 position;
 layout(location = 1) in vec2 texCoord;
+// per instance, which layer of u_TextureArray the burger wears
+layout(location = 2) in int layer;
 
 uniform mat4 u_MVP;
 
 uniform int u_BurgerCount;
 uniform float u_Radius;
+// which burger the first instance is, burgers that get drawn one at a time need to know where they go
+uniform int u_InstanceOffset;
 
 out vec2 v_TexCoord;
+flat out int v_Layer;
 
 void main() {
-  float angle = (2 * 3.14159265 / u_BurgerCount) * gl_InstanceID;
+  float angle = (2 * 3.14159265 / u_BurgerCount) * (gl_InstanceID + u_InstanceOffset);
   vec4 offset = vec4(cos(angle) * u_Radius, 0, sin(angle) * u_Radius, 0);
 
   gl_Position = u_MVP * (position + offset);
   v_TexCoord = texCoord;
+  v_Layer = layer;
 }
\ No newline at end of file
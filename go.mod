@@ -8,5 +8,5 @@ require github.com/go-gl/gl v0.0.0-20211210172815-726fda9656d6
 
 require (
 	github.com/go-gl/mathgl v1.0.0
-	golang.org/x/image v0.0.0-20190321063152-3fc05d484e9f // indirect
+	golang.org/x/image v0.0.0-20190321063152-3fc05d484e9f
 )
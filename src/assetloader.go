@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+)
+
+type AssetState int
+
+const (
+	AssetLoading AssetState = iota
+	AssetReady
+	AssetFailed
+)
+
+func (state AssetState) String() string {
+	switch state {
+	case AssetLoading:
+		return "loading"
+	case AssetReady:
+		return "ready"
+	case AssetFailed:
+		return "failed"
+	}
+	return "unknown"
+}
+
+type assetKind int
+
+const (
+	textureAsset assetKind = iota
+	meshAsset
+)
+
+// Returned by the async loading functions, only gets updated from ProcessUploads so it's safe to
+// look at from the render thread without any locking
+type AssetHandle struct {
+	Name string
+
+	kind    assetKind
+	state   AssetState
+	err     error
+	refs    int // references taken while the asset was still loading
+	texture *Texture
+	mesh    *Mesh
+}
+
+func (handle *AssetHandle) State() AssetState { return handle.state }
+func (handle *AssetHandle) Err() error        { return handle.err }
+
+// nil until the handle is ready
+func (handle *AssetHandle) Texture() *Texture { return handle.texture }
+func (handle *AssetHandle) Mesh() *Mesh       { return handle.mesh }
+
+type loadRequest struct {
+	handle *AssetHandle
+}
+
+// Everything a worker produced, ready to be uploaded on the render thread
+type loadResult struct {
+	handle     *AssetHandle
+	texture    *textureData
+	descriptor TextureDescriptor
+	vertices   []float32
+	err        error
+}
+
+// Reads and decodes assets on worker goroutines. Finished work goes into a bounded queue so the workers
+// stall instead of piling up decoded data when the render thread can't keep up with uploading it
+type assetLoader struct {
+	requests chan loadRequest
+	finished chan loadResult
+	stop     chan struct{}
+}
+
+func newAssetLoader(workers int, queueSize int) *assetLoader {
+	loader := &assetLoader{
+		requests: make(chan loadRequest, 64),
+		finished: make(chan loadResult, queueSize),
+		stop:     make(chan struct{}),
+	}
+
+	for i := 0; i < workers; i++ {
+		go loader.work()
+	}
+
+	return loader
+}
+
+func (loader *assetLoader) work() {
+	for {
+		var request loadRequest
+
+		select {
+		case <-loader.stop:
+			return
+		case request = <-loader.requests:
+		}
+
+		result := loadResult{handle: request.handle}
+
+		if request.handle.kind == textureAsset {
+			result.texture, result.descriptor, result.err = readTexture(request.handle.Name)
+			if result.err != nil {
+				// a broken texture shouldn't take everything down with it
+				result.texture, result.descriptor = missingTextureFallback(request.handle.Name, result.descriptor, result.err)
+				result.err = nil
+			}
+		} else if data, err := loadAsset(request.handle.Name); err != nil {
+			result.err = err
+		} else {
+			result.vertices, result.err = decodeMesh(data)
+		}
+
+		select {
+		case <-loader.stop:
+			return
+		case loader.finished <- result:
+		}
+	}
+}
+
+func (loader *assetLoader) submit(request loadRequest) {
+	select {
+	case loader.requests <- request:
+	default:
+		// the request queue is full, hand it over in the background so the render thread never blocks on it
+		go func() {
+			select {
+			case loader.requests <- request:
+			case <-loader.stop:
+			}
+		}()
+	}
+}
+
+func (loader *assetLoader) close() {
+	close(loader.stop)
+}
+
+func defaultLoaderWorkers() int {
+	workers := runtime.NumCPU() - 1
+	if workers < 1 {
+		workers = 1
+	}
+	return workers
+}
+
+// Starts loading a texture in the background. Like Texture, every call needs a matching Release
+func (manager *AssetManager) TextureAsync(name string) *AssetHandle {
+	return manager.loadAsync(canonicalAssetName(name), textureAsset)
+}
+
+// Starts loading a mesh in the background. Like Mesh, every call needs a matching Release
+func (manager *AssetManager) MeshAsync(name string) *AssetHandle {
+	return manager.loadAsync(canonicalAssetName(name), meshAsset)
+}
+
+func (manager *AssetManager) loadAsync(name string, kind assetKind) *AssetHandle {
+	if handle, ok := manager.pending[name]; ok {
+		handle.refs++
+		return handle
+	}
+
+	// already resident, no need to go through the workers at all
+	if kind == textureAsset {
+		if entry, ok := manager.textures[name]; ok {
+			entry.refs++
+			return &AssetHandle{Name: name, kind: kind, state: AssetReady, texture: &entry.texture}
+		}
+	} else if entry, ok := manager.meshes[name]; ok {
+		entry.refs++
+		return &AssetHandle{Name: name, kind: kind, state: AssetReady, mesh: &entry.mesh}
+	}
+
+	handle := &AssetHandle{Name: name, kind: kind, state: AssetLoading, refs: 1}
+	if failed, ok := manager.failed[name]; ok {
+		// another try, the references of the one that failed go along with it
+		handle.refs += failed.refs
+		failed.refs = 0
+		delete(manager.failed, name)
+	}
+	manager.pending[name] = handle
+	manager.loader.submit(loadRequest{handle: handle})
+
+	return handle
+}
+
+// Uploads finished assets until the queue is empty or the budget is used up. Always uploads at least
+// one asset when there is one so loading can't stall completely. Has to run on the render thread
+func (manager *AssetManager) ProcessUploads(budget time.Duration) {
+	start := time.Now()
+
+	for {
+		select {
+		case result := <-manager.loader.finished:
+			manager.finishLoad(result)
+		default:
+			return
+		}
+
+		if time.Since(start) >= budget {
+			return
+		}
+	}
+}
+
+func (manager *AssetManager) finishLoad(result loadResult) {
+	handle := result.handle
+	delete(manager.pending, handle.Name)
+
+	if result.err != nil {
+		handle.state = AssetFailed
+		handle.err = fmt.Errorf("Failed to load %s: %v", handle.Name, result.err)
+		// the references still have to be released, loading it again later takes them over
+		if handle.refs > 0 {
+			manager.failed[handle.Name] = handle
+		}
+		return
+	}
+
+	// everyone released it while it was loading
+	if handle.refs <= 0 {
+		handle.state = AssetFailed
+		handle.err = fmt.Errorf("%s was released before it finished loading", handle.Name)
+		return
+	}
+
+	if handle.kind == textureAsset {
+		entry, ok := manager.textures[handle.Name]
+		if ok {
+			// somebody loaded it synchronously in the meantime
+			entry.refs += handle.refs
+		} else {
+			entry = manager.addTexture(handle.Name, result.texture, result.descriptor, handle.refs)
+		}
+		handle.texture = &entry.texture
+	} else {
+		entry, ok := manager.meshes[handle.Name]
+		if ok {
+			entry.refs += handle.refs
+		} else {
+			entry = manager.addMesh(handle.Name, result.vertices, handle.refs)
+		}
+		handle.mesh = &entry.mesh
+	}
+
+	handle.refs = 0
+	handle.state = AssetReady
+}
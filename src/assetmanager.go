@@ -0,0 +1,410 @@
+package main
+
+import (
+	"fmt"
+	"main/src/util"
+	"path"
+	"sort"
+	"strings"
+
+	gl "github.com/go-gl/gl/v4.6-core/gl"
+)
+
+type Texture struct {
+	Handle uint32
+	Target uint32 // TEXTURE_2D unless it came from a container with a cubemap or an array in it
+	Width  int32
+	Height int32
+	Layers int32 // 0 unless it's an array
+
+	// from the texture's descriptor, bind it to the same unit as the texture
+	Sampler    uint32
+	Descriptor TextureDescriptor
+}
+
+type Mesh struct {
+	VAO         uint32
+	VBO         uint32
+	VertexCount int32
+}
+
+type textureEntry struct {
+	data     *textureData
+	texture  Texture
+	refs     int
+	gpuBytes int // what the uploaded levels take up in video memory
+}
+
+type meshEntry struct {
+	vertices []float32
+	mesh     Mesh
+	refs     int
+}
+
+// Caches textures and meshes by asset path so everything that uses the same file shares the same
+// decoded data and gl objects. Every Texture/Mesh call has to be paired with a Release, the gl objects
+// get deleted once nothing references them anymore. Only use it from the render thread
+type AssetManager struct {
+	textures map[string]*textureEntry
+	meshes   map[string]*meshEntry
+
+	loader  *assetLoader
+	pending map[string]*AssetHandle
+	failed  map[string]*AssetHandle // async loads that failed, they keep their references until they're released
+
+	samplers *samplerCache
+
+	// srgb textures get uploaded as srgb, turning it off uploads everything as linear like it used to be
+	srgb bool
+}
+
+func NewAssetManager() *AssetManager {
+	return &AssetManager{
+		textures: make(map[string]*textureEntry),
+		meshes:   make(map[string]*meshEntry),
+		loader:   newAssetLoader(defaultLoaderWorkers(), 4),
+		pending:  make(map[string]*AssetHandle),
+		failed:   make(map[string]*AssetHandle),
+		samplers: newSamplerCache(),
+		srgb:     true,
+	}
+}
+
+func canonicalAssetName(name string) string {
+	return strings.Replace(name, "\\", "/", -1)
+}
+
+// Returns the texture for the given asset, loading and uploading it if it isn't resident yet. Textures
+// that can't be loaded get a placeholder instead (and a warning), which a reload can still replace.
+// The returned pointer stays valid (and gets updated on reloads) until the last reference is released
+func (manager *AssetManager) Texture(name string) (*Texture, error) {
+	name = canonicalAssetName(name)
+
+	if entry, ok := manager.textures[name]; ok {
+		entry.refs++
+		return &entry.texture, nil
+	}
+
+	data, descriptor, err := readTexture(name)
+	if err != nil {
+		data, descriptor = missingTextureFallback(name, descriptor, err)
+	}
+
+	return &manager.addTexture(name, data, descriptor, 1).texture, nil
+}
+
+// Returns the mesh for the given asset, same deal as Texture
+func (manager *AssetManager) Mesh(name string) (*Mesh, error) {
+	name = canonicalAssetName(name)
+
+	if entry, ok := manager.meshes[name]; ok {
+		entry.refs++
+		return &entry.mesh, nil
+	}
+
+	obj, err := loadAsset(name)
+	if err != nil {
+		return nil, err
+	}
+
+	vertices, err := decodeMesh(obj)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load mesh %s: %v", name, err)
+	}
+
+	return &manager.addMesh(name, vertices, 1).mesh, nil
+}
+
+// Loads a texture along with its descriptor, safe to call from any goroutine
+func readTexture(name string) (*textureData, TextureDescriptor, error) {
+	descriptor, err := loadTextureDescriptor(name)
+	if err != nil {
+		return nil, descriptor, err
+	}
+
+	imageBytes, err := loadAsset(name)
+	if err != nil {
+		return nil, descriptor, err
+	}
+
+	data, err := decodeTextureAsset(name, imageBytes, descriptor)
+	if err != nil {
+		return nil, descriptor, fmt.Errorf("Failed to decode texture %s: %v", name, err)
+	}
+
+	return data, descriptor, nil
+}
+
+// .cube and .array files only list the faces of a cubemap or the layers of an array, everything else is
+// the texture itself
+func decodeTextureAsset(name string, data []byte, descriptor TextureDescriptor) (*textureData, error) {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".cube":
+		return decodeCubeFile(name, data, descriptor)
+	case ".array":
+		return decodeArrayFile(name, data, descriptor)
+	}
+	return decodeTexture(data, descriptor)
+}
+
+func (manager *AssetManager) addTexture(name string, data *textureData, descriptor TextureDescriptor, refs int) *textureEntry {
+	entry := &textureEntry{data: data, refs: refs}
+	entry.texture.Descriptor = descriptor
+	gl.GenTextures(1, &entry.texture.Handle)
+	manager.uploadOrFallback(name, entry)
+
+	manager.textures[name] = entry
+
+	return entry
+}
+
+func (manager *AssetManager) addMesh(name string, vertices []float32, refs int) *meshEntry {
+	entry := &meshEntry{vertices: vertices, refs: refs}
+
+	gl.GenBuffers(1, &entry.mesh.VBO)
+	manager.uploadMesh(entry)
+
+	gl.GenVertexArrays(1, &entry.mesh.VAO)
+	gl.BindVertexArray(entry.mesh.VAO)
+
+	gl.EnableVertexAttribArray(0)
+	gl.VertexAttribPointerWithOffset(0, 3, gl.FLOAT, false, int32(5*util.Sizeoffloat32()), 0)
+
+	gl.EnableVertexAttribArray(1)
+	gl.VertexAttribPointerWithOffset(1, 2, gl.FLOAT, false, int32(5*util.Sizeoffloat32()), uintptr(3*util.Sizeoffloat32()))
+
+	manager.meshes[name] = entry
+
+	return entry
+}
+
+// Uploads the texture, or the placeholder for missing textures when it can't be uploaded
+func (manager *AssetManager) uploadOrFallback(name string, entry *textureEntry) {
+	if err := manager.uploadTexture(entry); err != nil {
+		entry.data, entry.texture.Descriptor = missingTextureFallback(name, entry.texture.Descriptor, err)
+		// the placeholder is plain RGBA, that always works
+		manager.uploadTexture(entry)
+	}
+}
+
+func (manager *AssetManager) uploadTexture(entry *textureEntry) error {
+	// a texture object can't change its target, which can happen when a reload turns a texture into a cubemap
+	if target := entry.data.target(); entry.texture.Target != target {
+		if entry.texture.Target != 0 {
+			gl.DeleteTextures(1, &entry.texture.Handle)
+			gl.GenTextures(1, &entry.texture.Handle)
+		}
+		entry.texture.Target = target
+	}
+
+	gpuBytes, err := uploadTexture(entry.texture.Handle, entry.data, manager.srgb)
+	if err != nil {
+		return err
+	}
+	entry.gpuBytes = gpuBytes
+	entry.texture.Width = entry.data.width
+	entry.texture.Height = entry.data.height
+	entry.texture.Layers = int32(entry.data.layers)
+	entry.texture.Sampler = manager.samplers.get(entry.texture.Descriptor)
+	return nil
+}
+
+// Sampler object for a descriptor, for when something (like an mtl file) wants to sample a texture
+// differently than its own descriptor says. Stays alive until the manager is closed
+func (manager *AssetManager) Sampler(descriptor TextureDescriptor) uint32 {
+	return manager.samplers.get(descriptor)
+}
+
+func (manager *AssetManager) uploadMesh(entry *meshEntry) {
+	uploadMesh(entry.mesh.VBO, entry.vertices)
+	// 5 floats per vertex, position + uv
+	entry.mesh.VertexCount = int32(len(entry.vertices) / 5)
+}
+
+// Switches between uploading srgb textures as srgb or as plain linear data (which is wrong, but it's
+// how it used to be), every resident texture gets uploaded again
+func (manager *AssetManager) SetSRGB(enabled bool) {
+	if manager.srgb == enabled {
+		return
+	}
+
+	manager.srgb = enabled
+	for name, entry := range manager.textures {
+		manager.uploadOrFallback(name, entry)
+	}
+}
+
+// Drops a reference to a texture or mesh, frees it when it was the last one
+func (manager *AssetManager) Release(name string) {
+	name = canonicalAssetName(name)
+
+	if entry, ok := manager.textures[name]; ok {
+		entry.refs--
+		if entry.refs <= 0 {
+			gl.DeleteTextures(1, &entry.texture.Handle)
+			delete(manager.textures, name)
+		}
+		return
+	}
+
+	if entry, ok := manager.meshes[name]; ok {
+		entry.refs--
+		if entry.refs <= 0 {
+			gl.DeleteVertexArrays(1, &entry.mesh.VAO)
+			gl.DeleteBuffers(1, &entry.mesh.VBO)
+			delete(manager.meshes, name)
+		}
+		return
+	}
+
+	if handle, ok := manager.pending[name]; ok {
+		// whatever is left gets thrown away once it finishes loading
+		handle.refs--
+		return
+	}
+
+	if handle, ok := manager.failed[name]; ok {
+		handle.refs--
+		if handle.refs <= 0 {
+			delete(manager.failed, name)
+		}
+		return
+	}
+
+	util.ThrowWarning("Released asset that isn't loaded: " + name)
+}
+
+// Replaces the data of a resident asset in place, the gl handles stay the same (except for textures that
+// change between 2D, cubemap and array)
+func (manager *AssetManager) Reload(name string, data []byte) error {
+	name = canonicalAssetName(name)
+
+	// a texture's descriptor changed, the texture has to be rebuilt since the mipmaps depend on it
+	if textureName := strings.TrimSuffix(name, ".json"); textureName != name {
+		if _, ok := manager.textures[textureName]; ok {
+			textureData, err := loadAsset(textureName)
+			if err != nil {
+				return err
+			}
+			name, data = textureName, textureData
+		}
+	}
+
+	if entry, ok := manager.textures[name]; ok {
+		descriptor, err := loadTextureDescriptor(name)
+		if err != nil {
+			return err
+		}
+		decoded, err := decodeTextureAsset(name, data, descriptor)
+		if err != nil {
+			return err
+		}
+		// a texture that can't be uploaded keeps showing what it showed before, like one that can't be decoded
+		previous, previousDescriptor := entry.data, entry.texture.Descriptor
+		entry.data = decoded
+		entry.texture.Descriptor = descriptor
+		if err := manager.uploadTexture(entry); err != nil {
+			entry.data, entry.texture.Descriptor = previous, previousDescriptor
+			manager.uploadTexture(entry)
+			return err
+		}
+		return nil
+	}
+
+	if entry, ok := manager.meshes[name]; ok {
+		vertices, err := decodeMesh(data)
+		if err != nil {
+			return err
+		}
+		entry.vertices = vertices
+		manager.uploadMesh(entry)
+		return nil
+	}
+
+	return fmt.Errorf("%s isn't loaded", name)
+}
+
+// Names of every resident asset, sorted
+func (manager *AssetManager) Names() []string {
+	names := make([]string, 0, len(manager.textures)+len(manager.meshes))
+	for name := range manager.textures {
+		names = append(names, name)
+	}
+	for name := range manager.meshes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type ResidentAsset struct {
+	Name       string
+	Kind       string
+	References int
+	CPUBytes   int
+	GPUBytes   int
+}
+
+func (manager *AssetManager) Resident() []ResidentAsset {
+	var resident []ResidentAsset
+
+	for name, entry := range manager.textures {
+		resident = append(resident, ResidentAsset{
+			Name:       name,
+			Kind:       "texture",
+			References: entry.refs,
+			CPUBytes:   entry.data.byteSize(),
+			GPUBytes:   entry.gpuBytes,
+		})
+	}
+
+	for name, entry := range manager.meshes {
+		size := len(entry.vertices) * util.Sizeoffloat32()
+		resident = append(resident, ResidentAsset{
+			Name:       name,
+			Kind:       "mesh",
+			References: entry.refs,
+			CPUBytes:   size,
+			GPUBytes:   size,
+		})
+	}
+
+	sort.Slice(resident, func(i, j int) bool {
+		return resident[i].Name < resident[j].Name
+	})
+
+	return resident
+}
+
+func printResidentAssets(manager *AssetManager) {
+	var cpuTotal, gpuTotal int
+
+	fmt.Println("\x1b[1mResident assets\x1b[0m")
+	for _, asset := range manager.Resident() {
+		fmt.Printf("  %-24s %-8s refs: %-3d cpu: %-10d gpu: %d\n", asset.Name, asset.Kind, asset.References, asset.CPUBytes, asset.GPUBytes)
+		cpuTotal += asset.CPUBytes
+		gpuTotal += asset.GPUBytes
+	}
+	fmt.Printf("  total cpu: %d bytes, gpu: %d bytes\n", cpuTotal, gpuTotal)
+}
+
+// Frees everything that is still resident, anything still referenced at this point is a leak
+func (manager *AssetManager) Close() {
+	manager.loader.close()
+	defer manager.samplers.delete()
+
+	for _, name := range manager.Names() {
+		util.ThrowWarning(fmt.Sprintf("Asset %s is still referenced on shutdown", name))
+	}
+
+	for _, entry := range manager.textures {
+		gl.DeleteTextures(1, &entry.texture.Handle)
+	}
+	for _, entry := range manager.meshes {
+		gl.DeleteVertexArrays(1, &entry.mesh.VAO)
+		gl.DeleteBuffers(1, &entry.mesh.VBO)
+	}
+
+	manager.textures = make(map[string]*textureEntry)
+	manager.meshes = make(map[string]*meshEntry)
+}
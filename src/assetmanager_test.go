@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	gl "github.com/go-gl/gl/v4.6-core/gl"
+)
+
+func TestFailedLoadKeepsReferences(t *testing.T) {
+	manager := &AssetManager{pending: map[string]*AssetHandle{}, failed: map[string]*AssetHandle{}}
+
+	handle := &AssetHandle{Name: "broken.obj", kind: meshAsset, state: AssetLoading, refs: 2}
+	manager.pending[handle.Name] = handle
+	manager.finishLoad(loadResult{handle: handle, err: errors.New("broken")})
+
+	if handle.State() != AssetFailed {
+		t.Fatalf("handle is %v after a failed load", handle.State())
+	}
+	manager.Release(handle.Name)
+	if manager.failed[handle.Name] == nil {
+		t.Fatal("the failed load was forgotten while it still had a reference")
+	}
+	manager.Release(handle.Name)
+	if _, ok := manager.failed[handle.Name]; ok {
+		t.Error("the failed load is still around after every reference was released")
+	}
+}
+
+func TestTextureGPUSize(t *testing.T) {
+	tests := []struct {
+		data           *textureData
+		internalFormat int32
+		want           int
+	}{
+		{&textureData{width: 4, height: 2, format: textureGray, pixels: make([]uint8, 8)}, gl.R8, 8},
+		{&textureData{width: 4, height: 2, format: textureGray, pixels: make([]uint8, 8)}, gl.SRGB8, 32},
+		{&textureData{width: 3, height: 3, format: textureRGB, pixels: make([]uint8, 27)}, gl.RGB8, 36},
+		{&textureData{width: 2, height: 2, format: textureRGB16F, pixels: make([]uint8, 24)}, gl.RGB16F, 32},
+		{&textureData{width: 2, height: 2, format: textureRGBA, pixels: make([]uint8, 16), cubemap: true, layers: 2}, gl.RGBA8, 16 * 12},
+		// compressed levels are uploaded as they are, even the ones smaller than a block
+		{&textureData{width: 2, height: 1, format: textureBC1, pixels: make([]uint8, 8)}, gl.COMPRESSED_RGBA_S3TC_DXT1_EXT, 8},
+		{&textureData{width: 8, height: 8, format: textureBC7, pixels: make([]uint8, 64)}, gl.COMPRESSED_RGBA_BPTC_UNORM, 64},
+	}
+
+	for _, test := range tests {
+		if got := test.data.gpuSize(test.internalFormat); got != test.want {
+			t.Errorf("%v %dx%d: %d bytes, want %d", test.data.format, test.data.width, test.data.height, got, test.want)
+		}
+	}
+}
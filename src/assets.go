@@ -0,0 +1,129 @@
+package main
+
+import (
+	"main/src/util"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// where every asset gets loaded from, main adds the override directories and archives before the embedded assets
+var assetFS = NewVFS()
+
+func loadAsset(name string) ([]byte, error) {
+	return assetFS.ReadFile(name)
+}
+
+// only textures (and their descriptors) and meshes can be swapped out while the app is running
+func isHotReloadable(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".png", ".jpg", ".jpeg", ".bmp", ".tga", ".tif", ".tiff", ".webp", ".ktx2", ".dds", ".hdr", ".cube", ".array", ".obj", ".json":
+		return true
+	}
+	return false
+}
+
+// Polls the modification times of a set of assets and reports the ones that changed.
+// Polling is dumb but it doesn't need any extra dependencies and it's plenty fast for a handful of files
+type assetWatcher struct {
+	Changes chan string
+
+	names    []string
+	modTimes map[string]time.Time
+	interval time.Duration
+	stop     chan struct{}
+}
+
+func newAssetWatcher(names []string, interval time.Duration) *assetWatcher {
+	var watched []string
+	for _, name := range names {
+		if isHotReloadable(name) {
+			watched = append(watched, name)
+		}
+	}
+
+	watcher := &assetWatcher{
+		Changes:  make(chan string, len(watched)),
+		names:    watched,
+		modTimes: make(map[string]time.Time),
+		interval: interval,
+		stop:     make(chan struct{}),
+	}
+
+	for _, name := range watched {
+		watcher.modTimes[name] = watcher.modTime(name)
+	}
+
+	go watcher.run()
+
+	return watcher
+}
+
+func (watcher *assetWatcher) modTime(name string) time.Time {
+	info, err := assetFS.Stat(name)
+	if err != nil {
+		// the file is probably in the middle of being re-exported, just try again next time
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+func (watcher *assetWatcher) run() {
+	ticker := time.NewTicker(watcher.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-watcher.stop:
+			return
+		case <-ticker.C:
+		}
+
+		for _, name := range watcher.names {
+			modTime := watcher.modTime(name)
+			if modTime.IsZero() || modTime.Equal(watcher.modTimes[name]) {
+				continue
+			}
+			watcher.modTimes[name] = modTime
+
+			// don't block if the render thread hasn't picked up the previous change yet,
+			// it'll read the newest version of the file anyway
+			select {
+			case watcher.Changes <- name:
+			default:
+			}
+		}
+	}
+}
+
+func (watcher *assetWatcher) Close() {
+	close(watcher.stop)
+}
+
+// Reloads every asset that changed since the last call. Has to run on the render thread
+func (watcher *assetWatcher) Poll(reload func(name string, data []byte) error) {
+	for {
+		select {
+		case name := <-watcher.Changes:
+			if name == manifestName {
+				// nothing to reload, assets just get verified against the new one from now on
+				assetFS.ReloadManifests()
+				util.ThrowNotification("Reloaded " + name)
+				continue
+			}
+
+			data, err := loadAsset(name)
+			if err == nil {
+				err = reload(name, data)
+			}
+
+			if err != nil {
+				util.ThrowWarning("Could not reload " + name + ": " + err.Error())
+			} else {
+				util.ThrowNotification("Reloaded " + name)
+			}
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Packs a bunch of textures into one so everything using them can be drawn without rebinding textures
+// in between. Regions are placed with MaxRects (best short side fit), every region gets padding around
+// it that is filled with its own edge texels so filtering and mipmapping don't pull in the neighbours
+
+type atlasRect struct {
+	x, y          int
+	width, height int
+}
+
+func (rect atlasRect) contains(other atlasRect) bool {
+	return other.x >= rect.x && other.y >= rect.y &&
+		other.x+other.width <= rect.x+rect.width && other.y+other.height <= rect.y+rect.height
+}
+
+func (rect atlasRect) intersects(other atlasRect) bool {
+	return other.x < rect.x+rect.width && other.x+other.width > rect.x &&
+		other.y < rect.y+rect.height && other.y+other.height > rect.y
+}
+
+type maxRectsPacker struct {
+	width, height int
+	free          []atlasRect
+}
+
+func newMaxRectsPacker(width int, height int) *maxRectsPacker {
+	return &maxRectsPacker{width: width, height: height, free: []atlasRect{{0, 0, width, height}}}
+}
+
+// Finds a spot for a width x height rectangle, false when it doesn't fit anywhere
+func (packer *maxRectsPacker) insert(width int, height int) (atlasRect, bool) {
+	best := atlasRect{}
+	bestShortSide, bestLongSide := math.MaxInt, math.MaxInt
+	found := false
+
+	for _, free := range packer.free {
+		if width > free.width || height > free.height {
+			continue
+		}
+
+		leftoverX, leftoverY := free.width-width, free.height-height
+		shortSide, longSide := leftoverX, leftoverY
+		if shortSide > longSide {
+			shortSide, longSide = longSide, shortSide
+		}
+
+		if shortSide < bestShortSide || (shortSide == bestShortSide && longSide < bestLongSide) {
+			best = atlasRect{free.x, free.y, width, height}
+			bestShortSide, bestLongSide = shortSide, longSide
+			found = true
+		}
+	}
+
+	if !found {
+		return best, false
+	}
+
+	packer.place(best)
+	return best, true
+}
+
+// Splits every free rectangle the new one overlaps into the (up to 4) maximal rectangles around it,
+// then drops the ones that are completely inside another one
+func (packer *maxRectsPacker) place(used atlasRect) {
+	var free []atlasRect
+
+	for _, rect := range packer.free {
+		if !rect.intersects(used) {
+			free = append(free, rect)
+			continue
+		}
+
+		if used.x > rect.x {
+			free = append(free, atlasRect{rect.x, rect.y, used.x - rect.x, rect.height})
+		}
+		if used.x+used.width < rect.x+rect.width {
+			free = append(free, atlasRect{used.x + used.width, rect.y, rect.x + rect.width - used.x - used.width, rect.height})
+		}
+		if used.y > rect.y {
+			free = append(free, atlasRect{rect.x, rect.y, rect.width, used.y - rect.y})
+		}
+		if used.y+used.height < rect.y+rect.height {
+			free = append(free, atlasRect{rect.x, used.y + used.height, rect.width, rect.y + rect.height - used.y - used.height})
+		}
+	}
+
+	pruned := free[:0]
+	for i, rect := range free {
+		redundant := false
+		for j, other := range free {
+			// identical rectangles only keep the first one
+			if i != j && other.contains(rect) && (other != rect || j < i) {
+				redundant = true
+				break
+			}
+		}
+		if !redundant {
+			pruned = append(pruned, rect)
+		}
+	}
+
+	packer.free = pruned
+}
+
+// Where a texture ended up. x and y count from the bottom left like the texture data and uvs do
+type atlasRegion struct {
+	Name string
+	atlasRect
+}
+
+type textureAtlas struct {
+	data    *textureData // always RGBA
+	padding int
+	regions map[string]atlasRegion
+}
+
+type atlasSource struct {
+	name string
+	data *textureData
+}
+
+// Packs the sources into the smallest power of two atlas they fit into, up to maxSize on either side.
+// The padding should be at least as big as the mip levels that are going to be used, 2^levels texels
+func buildAtlas(sources []atlasSource, padding int, maxSize int) (*textureAtlas, error) {
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("Nothing to put in the atlas")
+	}
+
+	// biggest first packs a lot tighter
+	sorted := append([]atlasSource(nil), sources...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i].data, sorted[j].data
+		if a.height != b.height {
+			return a.height > b.height
+		}
+		return a.width > b.width
+	})
+
+	area := 0
+	for _, source := range sorted {
+		area += (int(source.data.width) + 2*padding) * (int(source.data.height) + 2*padding)
+	}
+
+	width, height := 1, 1
+	for width*height < area {
+		if width <= height {
+			width *= 2
+		} else {
+			height *= 2
+		}
+	}
+
+	for width <= maxSize && height <= maxSize {
+		if regions, ok := packAtlas(sorted, padding, width, height); ok {
+			atlas := &textureAtlas{padding: padding, regions: regions}
+			atlas.data = &textureData{width: int32(width), height: int32(height), format: textureRGBA, pixels: make([]uint8, width*height*4)}
+			for _, source := range sorted {
+				blitPadded(atlas.data, toRGBA(source.data), regions[source.name].atlasRect, padding)
+			}
+			return atlas, nil
+		}
+
+		if width <= height {
+			width *= 2
+		} else {
+			height *= 2
+		}
+	}
+
+	return nil, fmt.Errorf("Textures don't fit into a %dx%d atlas", maxSize, maxSize)
+}
+
+func packAtlas(sources []atlasSource, padding int, width int, height int) (map[string]atlasRegion, bool) {
+	packer := newMaxRectsPacker(width, height)
+	regions := make(map[string]atlasRegion, len(sources))
+
+	for _, source := range sources {
+		rect, ok := packer.insert(int(source.data.width)+2*padding, int(source.data.height)+2*padding)
+		if !ok {
+			return nil, false
+		}
+		regions[source.name] = atlasRegion{
+			Name:      source.name,
+			atlasRect: atlasRect{rect.x + padding, rect.y + padding, int(source.data.width), int(source.data.height)},
+		}
+	}
+
+	return regions, true
+}
+
+// Copies source into the atlas at rect, the padding around it gets the nearest edge texel
+func blitPadded(atlas *textureData, source *textureData, rect atlasRect, padding int) {
+	atlasRow := atlas.rowBytes()
+	sourceRow := source.rowBytes()
+
+	for y := -padding; y < rect.height+padding; y++ {
+		sourceY := clampIndex(y, rect.height)
+		destination := atlas.pixels[(rect.y+y)*atlasRow:]
+
+		// the image itself is one copy, only the padding on the sides goes texel by texel
+		copy(destination[rect.x*4:(rect.x+rect.width)*4], source.pixels[sourceY*sourceRow:(sourceY+1)*sourceRow])
+		for x := 1; x <= padding; x++ {
+			copy(destination[(rect.x-x)*4:(rect.x-x)*4+4], source.pixels[sourceY*sourceRow:])
+			copy(destination[(rect.x+rect.width-1+x)*4:(rect.x+rect.width+x)*4], source.pixels[sourceY*sourceRow+(rect.width-1)*4:])
+		}
+	}
+}
+
+func toRGBA(data *textureData) *textureData {
+	if data.format == textureRGBA {
+		return data
+	}
+
+	channels := data.format.channels()
+	converted := &textureData{width: data.width, height: data.height, format: textureRGBA, pixels: make([]uint8, int(data.width)*int(data.height)*4)}
+	for i, j := 0, 0; i < len(data.pixels); i, j = i+channels, j+4 {
+		if channels == 1 {
+			converted.pixels[j+0], converted.pixels[j+1], converted.pixels[j+2] = data.pixels[i], data.pixels[i], data.pixels[i]
+		} else {
+			copy(converted.pixels[j:j+3], data.pixels[i:i+3])
+		}
+		converted.pixels[j+3] = 0xff
+	}
+	return converted
+}
+
+// uv of a point inside the region, uv being the point's coordinates within the original texture
+func (atlas *textureAtlas) remapUV(region atlasRegion, u float32, v float32) (float32, float32) {
+	return (float32(region.x) + u*float32(region.width)) / float32(atlas.data.width),
+		(float32(region.y) + v*float32(region.height)) / float32(atlas.data.height)
+}
+
+// Moves the uvs of a mesh (5 floats per vertex, position + uv) into the region of its texture, in place.
+// Uvs outside of 0-1 would need the texture to repeat which can't work in an atlas
+func (atlas *textureAtlas) remapMesh(vertices []float32, texture string) error {
+	region, ok := atlas.regions[texture]
+	if !ok {
+		return fmt.Errorf("%s isn't in the atlas", texture)
+	}
+
+	const epsilon = 1e-4
+	for i := 0; i+4 < len(vertices); i += 5 {
+		u, v := vertices[i+3], vertices[i+4]
+		if u < -epsilon || u > 1+epsilon || v < -epsilon || v > 1+epsilon {
+			return fmt.Errorf("Vertex %d has uv (%v, %v), repeating textures can't go into an atlas", i/5, u, v)
+		}
+		vertices[i+3], vertices[i+4] = atlas.remapUV(region, u, v)
+	}
+
+	return nil
+}
+
+type atlasRegionMetadata struct {
+	// pixels from the top left of the image, the way image editors show it
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	// u0, v0, u1, v1 the way opengl sees it
+	UV [4]float32 `json:"uv"`
+}
+
+type atlasMetadata struct {
+	Image   string                         `json:"image"`
+	Width   int                            `json:"width"`
+	Height  int                            `json:"height"`
+	Padding int                            `json:"padding"`
+	Regions map[string]atlasRegionMetadata `json:"regions"`
+	Meshes  map[string]string              `json:"meshes,omitempty"` // remapped mesh -> texture it was using
+}
+
+func (atlas *textureAtlas) metadata(imageName string, meshes map[string]string) atlasMetadata {
+	metadata := atlasMetadata{
+		Image:   imageName,
+		Width:   int(atlas.data.width),
+		Height:  int(atlas.data.height),
+		Padding: atlas.padding,
+		Regions: make(map[string]atlasRegionMetadata, len(atlas.regions)),
+		Meshes:  meshes,
+	}
+
+	for name, region := range atlas.regions {
+		u0, v0 := atlas.remapUV(region, 0, 0)
+		u1, v1 := atlas.remapUV(region, 1, 1)
+		metadata.Regions[name] = atlasRegionMetadata{
+			X:      region.x,
+			Y:      int(atlas.data.height) - region.y - region.height,
+			Width:  region.width,
+			Height: region.height,
+			UV:     [4]float32{u0, v0, u1, v1},
+		}
+	}
+
+	return metadata
+}
+
+// Turns texture data back into an image, top row first
+func textureToImage(data *textureData) *image.NRGBA {
+	rgba := toRGBA(data)
+	result := image.NewNRGBA(image.Rect(0, 0, int(rgba.width), int(rgba.height)))
+	copy(result.Pix, copyRowsFlipped(rgba.pixels, rgba.rowBytes(), rgba.rowBytes(), int(rgba.height), 0))
+	return result
+}
+
+// Writes vertices (5 floats per vertex, position + uv) as an obj that parseObj can read back
+func writeObj(vertices []float32) []byte {
+	var buffer bytes.Buffer
+	format := func(value float32) string {
+		return strconv.FormatFloat(float64(value), 'f', -1, 32)
+	}
+
+	for i := 0; i+4 < len(vertices); i += 5 {
+		fmt.Fprintf(&buffer, "v %s %s %s\n", format(vertices[i]), format(vertices[i+1]), format(vertices[i+2]))
+	}
+	for i := 0; i+4 < len(vertices); i += 5 {
+		fmt.Fprintf(&buffer, "vt %s %s\n", format(vertices[i+3]), format(vertices[i+4]))
+	}
+	for i := 1; i+2 <= len(vertices)/5; i += 3 {
+		fmt.Fprintf(&buffer, "f %d/%d %d/%d %d/%d\n", i, i, i+1, i+1, i+2, i+2)
+	}
+
+	return buffer.Bytes()
+}
+
+// atlas [-padding n] [-max-size n] [-assets dir] <output.png> <texture | mesh.obj=texture>...
+// Writes the atlas, <output>.json with where everything ended up and <output>.<mesh>.obj for every mesh
+func runAtlasCommand(args []string) int {
+	usage := "usage: atlas [-padding n] [-max-size n] [-assets dir] <output.png> <texture | mesh.obj=texture>..."
+
+	flags := flag.NewFlagSet("atlas", flag.ContinueOnError)
+	padding := flags.Int("padding", 8, "texels around every texture filled with its edge, enough for 3 mip levels by default")
+	maxSize := flags.Int("max-size", 4096, "biggest the atlas can get on either side")
+	flags.Func("assets", "directory to read the textures and meshes from before the embedded assets", func(directory string) error {
+		return assetFS.AddDirectory(directory)
+	})
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() < 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+	assetFS.AddEmbedded()
+	defer assetFS.Close()
+
+	output := flags.Arg(0)
+	meshes := make(map[string]string)
+	var sources []atlasSource
+	seen := make(map[string]bool)
+
+	for _, argument := range flags.Args()[1:] {
+		texture := argument
+		if mesh, meshTexture, ok := strings.Cut(argument, "="); ok {
+			meshes[mesh], texture = meshTexture, meshTexture
+		}
+		if seen[texture] {
+			continue
+		}
+		seen[texture] = true
+
+		// the atlas gets its own mipmaps once it's loaded, the textures don't need any
+		descriptor := defaultTextureDescriptor()
+		descriptor.MipFilter = "none"
+
+		imageBytes, err := loadAsset(texture)
+		var data *textureData
+		if err == nil {
+			data, err = decodeTexture(imageBytes, descriptor)
+		}
+		if err == nil && data.faceCount() > 1 {
+			err = fmt.Errorf("%s is a cubemap or an array, those can't go into an atlas", texture)
+		}
+		if err == nil {
+			data, err = decompressTexture(data)
+		}
+		if err == nil && data.format.float() {
+			err = fmt.Errorf("%s is a float texture, the atlas only holds 8 bit ones", texture)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		sources = append(sources, atlasSource{name: texture, data: data})
+	}
+
+	atlas, err := buildAtlas(sources, *padding, *maxSize)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	base := strings.TrimSuffix(output, filepath.Ext(output))
+	for mesh, texture := range meshes {
+		obj, err := loadAsset(mesh)
+		if err == nil {
+			var vertices []float32
+			vertices, err = decodeMesh(obj)
+			if err == nil {
+				err = atlas.remapMesh(vertices, texture)
+			}
+			if err == nil {
+				meshOutput := base + "." + strings.TrimSuffix(filepath.Base(mesh), filepath.Ext(mesh)) + ".obj"
+				err = os.WriteFile(meshOutput, writeObj(vertices), 0644)
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", mesh, err)
+			return 1
+		}
+	}
+
+	var encoded bytes.Buffer
+	err = png.Encode(&encoded, textureToImage(atlas.data))
+	if err == nil {
+		err = os.WriteFile(output, encoded.Bytes(), 0644)
+	}
+	if err == nil {
+		var metadata []byte
+		metadata, err = json.MarshalIndent(atlas.metadata(filepath.Base(output), meshes), "", "  ")
+		if err == nil {
+			err = os.WriteFile(base+".json", append(metadata, '\n'), 0644)
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Printf("Packed %d textures into a %dx%d atlas, %d meshes remapped\n", len(sources), atlas.data.width, atlas.data.height, len(meshes))
+	return 0
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMaxRectsNoOverlap(t *testing.T) {
+	packer := newMaxRectsPacker(64, 64)
+	sizes := [][2]int{{20, 12}, {7, 30}, {16, 16}, {33, 5}, {9, 9}, {12, 20}, {5, 33}, {3, 3}, {18, 7}, {11, 14}, {1, 40}, {25, 2}}
+
+	var placed []atlasRect
+	bounds := atlasRect{0, 0, 64, 64}
+	for _, size := range sizes {
+		rect, ok := packer.insert(size[0], size[1])
+		if !ok {
+			continue
+		}
+		if rect.width != size[0] || rect.height != size[1] {
+			t.Errorf("asked for %dx%d, got %v", size[0], size[1], rect)
+		}
+		if !bounds.contains(rect) {
+			t.Errorf("%v is outside of the 64x64 atlas", rect)
+		}
+		for _, other := range placed {
+			if rect.intersects(other) {
+				t.Errorf("%v overlaps %v", rect, other)
+			}
+		}
+		placed = append(placed, rect)
+	}
+
+	if len(placed) < len(sizes)-1 {
+		t.Errorf("only %d of %d rectangles fit", len(placed), len(sizes))
+	}
+}
+
+func TestMaxRectsExactFit(t *testing.T) {
+	packer := newMaxRectsPacker(32, 32)
+	for i := 0; i < 4; i++ {
+		if _, ok := packer.insert(16, 16); !ok {
+			t.Fatalf("quarter %d doesn't fit", i)
+		}
+	}
+	if len(packer.free) != 0 {
+		t.Errorf("a full atlas still has free space %v", packer.free)
+	}
+	if rect, ok := packer.insert(1, 1); ok {
+		t.Errorf("a full atlas took a 1x1 rectangle at %v", rect)
+	}
+}
+
+func TestMaxRectsTooBig(t *testing.T) {
+	packer := newMaxRectsPacker(16, 16)
+	if _, ok := packer.insert(17, 1); ok {
+		t.Error("a 17x1 rectangle fit into 16x16")
+	}
+	if _, ok := packer.insert(1, 17); ok {
+		t.Error("a 1x17 rectangle fit into 16x16")
+	}
+
+	source := atlasSource{name: "big", data: &textureData{width: 30, height: 30, format: textureRGBA, pixels: make([]uint8, 30*30*4)}}
+	if _, err := buildAtlas([]atlasSource{source}, 2, 32); err == nil {
+		t.Error("a 30x30 texture with 2 texels of padding fit into a 32x32 atlas")
+	}
+	if _, err := buildAtlas([]atlasSource{source}, 1, 32); err != nil {
+		t.Errorf("a 30x30 texture with 1 texel of padding doesn't fit into a 32x32 atlas: %v", err)
+	}
+}
+
+func TestAtlasPacking(t *testing.T) {
+	var sources []atlasSource
+	for i, size := range [][2]int32{{8, 8}, {4, 12}, {10, 3}, {5, 5}, {1, 1}} {
+		pixels := bytes.Repeat([]uint8{uint8(i * 40), 0, 0, 255}, int(size[0]*size[1]))
+		sources = append(sources, atlasSource{name: string(rune('a' + i)), data: &textureData{width: size[0], height: size[1], format: textureRGBA, pixels: pixels}})
+	}
+
+	atlas, err := buildAtlas(sources, 2, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bounds := atlasRect{0, 0, int(atlas.data.width), int(atlas.data.height)}
+	for _, source := range sources {
+		region := atlas.regions[source.name]
+		if region.width != int(source.data.width) || region.height != int(source.data.height) {
+			t.Errorf("%s is %dx%d in the atlas, should be %dx%d", source.name, region.width, region.height, source.data.width, source.data.height)
+		}
+
+		padded := atlasRect{region.x - 2, region.y - 2, region.width + 4, region.height + 4}
+		if !bounds.contains(padded) {
+			t.Errorf("%s with its padding (%v) is outside of the %dx%d atlas", source.name, padded, bounds.width, bounds.height)
+		}
+		for _, other := range sources {
+			otherRegion := atlas.regions[other.name]
+			if other.name != source.name && padded.intersects(atlasRect{otherRegion.x - 2, otherRegion.y - 2, otherRegion.width + 4, otherRegion.height + 4}) {
+				t.Errorf("%s and %s overlap", source.name, other.name)
+			}
+		}
+	}
+}
+
+func TestAtlasEdgeBleeding(t *testing.T) {
+	// 2x2 with a different color in every texel
+	source := &textureData{width: 2, height: 2, format: textureRGBA, pixels: []uint8{
+		10, 0, 0, 255, 20, 0, 0, 255,
+		30, 0, 0, 255, 40, 0, 0, 255,
+	}}
+	const padding = 2
+
+	atlas, err := buildAtlas([]atlasSource{{name: "tile", data: source}}, padding, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	region := atlas.regions["tile"]
+	for y := -padding; y < 2+padding; y++ {
+		for x := -padding; x < 2+padding; x++ {
+			want := source.pixels[(clampIndex(y, 2)*2+clampIndex(x, 2))*4]
+			got := atlas.data.pixels[((region.y+y)*int(atlas.data.width)+region.x+x)*4]
+			if got != want {
+				t.Errorf("texel (%d, %d) relative to the region is %d, want %d", x, y, got, want)
+			}
+		}
+	}
+}
+
+func TestAtlasRemapMesh(t *testing.T) {
+	atlas := &textureAtlas{
+		data:    &textureData{width: 8, height: 8, format: textureRGBA},
+		regions: map[string]atlasRegion{"tile": {Name: "tile", atlasRect: atlasRect{2, 4, 4, 2}}},
+	}
+
+	// a quad, position + uv
+	vertices := []float32{
+		0, 0, 0, 0, 0,
+		1, 0, 0, 1, 0,
+		1, 1, 0, 1, 1,
+		0, 1, 0, 0, 1,
+	}
+	if err := atlas.remapMesh(vertices, "tile"); err != nil {
+		t.Fatal(err)
+	}
+
+	positions := [][2]float32{{0, 0}, {1, 0}, {1, 1}, {0, 1}}
+	want := [][2]float32{{0.25, 0.5}, {0.75, 0.5}, {0.75, 0.75}, {0.25, 0.75}}
+	for i, uv := range want {
+		if vertices[i*5+3] != uv[0] || vertices[i*5+4] != uv[1] {
+			t.Errorf("vertex %d has uv (%v, %v), want (%v, %v)", i, vertices[i*5+3], vertices[i*5+4], uv[0], uv[1])
+		}
+		if vertices[i*5] != positions[i][0] || vertices[i*5+1] != positions[i][1] || vertices[i*5+2] != 0 {
+			t.Errorf("vertex %d moved to (%v, %v, %v)", i, vertices[i*5], vertices[i*5+1], vertices[i*5+2])
+		}
+	}
+
+	if err := atlas.remapMesh([]float32{0, 0, 0, 1.5, 0}, "tile"); err == nil {
+		t.Error("a repeating uv got remapped")
+	}
+	if err := atlas.remapMesh(vertices, "missing"); err == nil {
+		t.Error("a texture that isn't in the atlas got remapped")
+	}
+}
@@ -0,0 +1,738 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// CPU decoders for the BC1-BC7 block compressed formats. Used when the driver can't sample a format
+// directly, and by anything that needs the actual texels (like the atlas packer). Every format works on
+// 4x4 texel blocks, texels inside a block go row by row
+
+// Decodes a whole image worth of blocks into tightly packed pixels in format.decodedFormat()
+func decodeBlocks(format textureFormat, blocks []uint8, width int, height int) ([]uint8, error) {
+	blocksX, blocksY := (width+3)/4, (height+3)/4
+	blockBytes := format.blockBytes()
+	if len(blocks) < blocksX*blocksY*blockBytes {
+		return nil, fmt.Errorf("Expected %d bytes of blocks for %dx%d, got %d", blocksX*blocksY*blockBytes, width, height, len(blocks))
+	}
+
+	pixelBytes := format.pixelBytes()
+	pixels := make([]uint8, width*height*pixelBytes)
+
+	var texels [16][4]uint8
+	var hdrTexels [16][3]float32
+	var halfTexels [16][6]uint8 // bc6h decodes to half floats
+
+	for blockY := 0; blockY < blocksY; blockY++ {
+		for blockX := 0; blockX < blocksX; blockX++ {
+			block := blocks[(blockY*blocksX+blockX)*blockBytes:]
+
+			switch format {
+			case textureBC1:
+				decodeBC1Block(block, &texels, true)
+			case textureBC2:
+				decodeBC1Block(block[8:], &texels, false)
+				decodeBC2Alpha(block, &texels, 3)
+			case textureBC3:
+				decodeBC1Block(block[8:], &texels, false)
+				decodeBC4Block(block, &texels, 3, false)
+			case textureBC4, textureBC4Signed:
+				decodeBC4Block(block, &texels, 0, format == textureBC4Signed)
+			case textureBC5, textureBC5Signed:
+				decodeBC4Block(block, &texels, 0, format == textureBC5Signed)
+				decodeBC4Block(block[8:], &texels, 1, format == textureBC5Signed)
+			case textureBC6H, textureBC6HSigned:
+				decodeBC6HBlock(block, &hdrTexels, format == textureBC6HSigned)
+				for i, texel := range hdrTexels {
+					copy(halfTexels[i][:], writeFloats(textureRGB16F, texel[:]))
+				}
+			case textureBC7:
+				decodeBC7Block(block, &texels)
+			default:
+				return nil, fmt.Errorf("%v isn't a block compressed format", format)
+			}
+
+			for y := 0; y < 4 && blockY*4+y < height; y++ {
+				for x := 0; x < 4 && blockX*4+x < width; x++ {
+					offset := ((blockY*4+y)*width + blockX*4 + x) * pixelBytes
+					if format == textureBC6H || format == textureBC6HSigned {
+						copy(pixels[offset:offset+pixelBytes], halfTexels[y*4+x][:])
+					} else {
+						copy(pixels[offset:offset+pixelBytes], texels[y*4+x][:pixelBytes])
+					}
+				}
+			}
+		}
+	}
+
+	return pixels, nil
+}
+
+func expand565(color uint16) [4]uint8 {
+	r, g, b := uint8(color>>11&31), uint8(color>>5&63), uint8(color&31)
+	return [4]uint8{r<<3 | r>>2, g<<2 | g>>4, b<<3 | b>>2, 0xff}
+}
+
+// The color part of BC1-BC3. BC1 on its own has a 3 color + transparent mode, in BC2 and BC3 the
+// color block always has 4 colors
+func decodeBC1Block(block []uint8, texels *[16][4]uint8, allowTransparent bool) {
+	color0 := binary.LittleEndian.Uint16(block[0:])
+	color1 := binary.LittleEndian.Uint16(block[2:])
+	indices := binary.LittleEndian.Uint32(block[4:])
+
+	var palette [4][4]uint8
+	palette[0], palette[1] = expand565(color0), expand565(color1)
+
+	for c := 0; c < 3; c++ {
+		a, b := uint32(palette[0][c]), uint32(palette[1][c])
+		if color0 > color1 || !allowTransparent {
+			palette[2][c] = uint8((2*a + b) / 3)
+			palette[3][c] = uint8((a + 2*b) / 3)
+		} else {
+			palette[2][c] = uint8((a + b) / 2)
+			palette[3][c] = 0
+		}
+	}
+	palette[2][3] = 0xff
+	palette[3][3] = 0xff
+	if color0 <= color1 && allowTransparent {
+		palette[3][3] = 0
+	}
+
+	for i := range texels {
+		texels[i] = palette[indices>>(2*i)&3]
+	}
+}
+
+// BC2 stores alpha as plain 4 bits per texel
+func decodeBC2Alpha(block []uint8, texels *[16][4]uint8, channel int) {
+	alpha := binary.LittleEndian.Uint64(block)
+	for i := range texels {
+		texels[i][channel] = uint8(alpha>>(4*i)&15) * 17
+	}
+}
+
+// Two endpoints and 3 bit indices, used for BC3 alpha and the channels of BC4 and BC5. Signed values
+// get stored the way 8 bit normal maps usually are, -1 is 0 and 1 is 255
+func decodeBC4Block(block []uint8, texels *[16][4]uint8, channel int, signed bool) {
+	var palette [8]float64
+	var endpoint0, endpoint1 float64
+	if signed {
+		endpoint0 = math.Max(float64(int8(block[0])), -127) / 127
+		endpoint1 = math.Max(float64(int8(block[1])), -127) / 127
+	} else {
+		endpoint0 = float64(block[0]) / 255
+		endpoint1 = float64(block[1]) / 255
+	}
+
+	palette[0], palette[1] = endpoint0, endpoint1
+	if endpoint0 > endpoint1 {
+		for i := 1; i < 7; i++ {
+			palette[i+1] = (float64(7-i)*endpoint0 + float64(i)*endpoint1) / 7
+		}
+	} else {
+		for i := 1; i < 5; i++ {
+			palette[i+1] = (float64(5-i)*endpoint0 + float64(i)*endpoint1) / 5
+		}
+		palette[6] = 0
+		palette[7] = 1
+		if signed {
+			palette[6] = -1
+		}
+	}
+
+	// 48 bits of indices after the endpoints
+	indices := uint64(block[2]) | uint64(block[3])<<8 | uint64(block[4])<<16 | uint64(block[5])<<24 | uint64(block[6])<<32 | uint64(block[7])<<40
+	for i := range texels {
+		value := palette[indices>>(3*i)&7]
+		if signed {
+			value = value*0.5 + 0.5
+		}
+		texels[i][channel] = quantize(value)
+	}
+}
+
+// Reads a 128 bit block lowest bit first
+type blockBits struct {
+	low, high uint64
+	position  uint
+}
+
+func newBlockBits(block []uint8) *blockBits {
+	return &blockBits{low: binary.LittleEndian.Uint64(block), high: binary.LittleEndian.Uint64(block[8:])}
+}
+
+func (bits *blockBits) read(count uint) uint32 {
+	if count == 0 {
+		return 0
+	}
+
+	var value uint64
+	switch {
+	case bits.position >= 64:
+		value = bits.high >> (bits.position - 64)
+	case bits.position+count <= 64:
+		value = bits.low >> bits.position
+	default:
+		value = bits.low>>bits.position | bits.high<<(64-bits.position)
+	}
+
+	bits.position += count
+	return uint32(value & (1<<count - 1))
+}
+
+// Which subset every texel belongs to, 1 bit per texel for 2 subsets and 2 bits for 3. The 2 subset ones
+// are shared by BC6H (only the first 32) and BC7
+var bc7Partitions2 = [64]uint16{
+	0xcccc, 0x8888, 0xeeee, 0xecc8, 0xc880, 0xfeec, 0xfec8, 0xec80, 0xc800, 0xffec, 0xfe80, 0xe800, 0xffe8, 0xff00, 0xfff0, 0xf000,
+	0xf710, 0x008e, 0x7100, 0x08ce, 0x008c, 0x7310, 0x3100, 0x8cce, 0x088c, 0x3110, 0x6666, 0x366c, 0x17e8, 0x0ff0, 0x718e, 0x399c,
+	0xaaaa, 0xf0f0, 0x5a5a, 0x33cc, 0x3c3c, 0x55aa, 0x9696, 0xa55a, 0x73ce, 0x13c8, 0x324c, 0x3bdc, 0x6996, 0xc33c, 0x9966, 0x0660,
+	0x0272, 0x04e4, 0x4e40, 0x2720, 0xc936, 0x936c, 0x39c6, 0x639c, 0x9336, 0x9cc9, 0x817e, 0xe718, 0xccf0, 0x0fcc, 0x7744, 0xee22,
+}
+
+var bc7Partitions3 = [64]uint32{
+	0xaa685050, 0x6a5a5040, 0x5a5a4200, 0x5450a0a8, 0xa5a50000, 0xa0a05050, 0x5555a0a0, 0x5a5a5050,
+	0xaa550000, 0xaa555500, 0xaaaa5500, 0x90909090, 0x94949494, 0xa4a4a4a4, 0xa9a59450, 0x2a0a4250,
+	0xa5945040, 0x0a425054, 0xa5a5a500, 0x55a0a0a0, 0xa8a85454, 0x6a6a4040, 0xa4a45000, 0x1a1a0500,
+	0x0050a4a4, 0xaaa59090, 0x14696914, 0x69691400, 0xa08585a0, 0xaa821414, 0x50a4a450, 0x6a5a0200,
+	0xa9a58000, 0x5090a0a8, 0xa8a09050, 0x24242424, 0x00aa5500, 0x24924924, 0x24499224, 0x50a50a50,
+	0x500aa550, 0xaaaa4444, 0x66660000, 0xa5a0a5a0, 0x50a050a0, 0x69286928, 0x44aaaa44, 0x66666600,
+	0xaa444444, 0x54a854a8, 0x95809580, 0x96969600, 0xa85454a8, 0x80959580, 0xaa141414, 0x96960000,
+	0xaaaa1414, 0xa05050a0, 0xa0a5a5a0, 0x96000000, 0x40804080, 0xa9a8a9a8, 0xaaaaaa44, 0x2a4a5254,
+}
+
+// One texel of every subset (the anchor) has one index bit less, for the first subset that's always
+// texel 0, these are the anchors of the others
+var (
+	bc7Anchors2 = [64]uint8{
+		15, 15, 15, 15, 15, 15, 15, 15, 15, 15, 15, 15, 15, 15, 15, 15,
+		15, 2, 8, 2, 2, 8, 8, 15, 2, 8, 2, 2, 8, 8, 2, 2,
+		15, 15, 6, 8, 2, 8, 15, 15, 2, 8, 2, 2, 2, 15, 15, 6,
+		6, 2, 6, 8, 15, 15, 2, 2, 15, 15, 15, 15, 15, 2, 2, 15,
+	}
+	bc7Anchors3Second = [64]uint8{
+		3, 3, 15, 15, 8, 3, 15, 15, 8, 8, 6, 6, 6, 5, 3, 3,
+		3, 3, 8, 15, 3, 3, 6, 10, 5, 8, 8, 6, 8, 5, 15, 15,
+		8, 15, 3, 5, 6, 10, 8, 15, 15, 3, 15, 5, 15, 15, 15, 15,
+		3, 15, 5, 5, 5, 8, 5, 10, 5, 10, 8, 13, 15, 12, 3, 3,
+	}
+	bc7Anchors3Third = [64]uint8{
+		15, 8, 8, 3, 15, 15, 3, 8, 15, 15, 15, 15, 15, 15, 15, 8,
+		15, 8, 15, 3, 15, 8, 15, 8, 3, 15, 6, 10, 15, 15, 10, 8,
+		15, 3, 15, 10, 10, 8, 9, 10, 6, 15, 8, 15, 3, 6, 6, 8,
+		15, 3, 15, 15, 15, 15, 15, 15, 15, 15, 15, 15, 3, 15, 15, 8,
+	}
+)
+
+func partitionSubset(subsets int, partition int, texel int) int {
+	switch subsets {
+	case 2:
+		return int(bc7Partitions2[partition] >> texel & 1)
+	case 3:
+		return int(bc7Partitions3[partition] >> (2 * texel) & 3)
+	}
+	return 0
+}
+
+func isAnchor(subsets int, partition int, texel int) bool {
+	switch {
+	case texel == 0:
+		return true
+	case subsets == 2:
+		return texel == int(bc7Anchors2[partition])
+	case subsets == 3:
+		return texel == int(bc7Anchors3Second[partition]) || texel == int(bc7Anchors3Third[partition])
+	}
+	return false
+}
+
+var (
+	bcWeights2 = []uint32{0, 21, 43, 64}
+	bcWeights3 = []uint32{0, 9, 18, 27, 37, 46, 55, 64}
+	bcWeights4 = []uint32{0, 4, 9, 13, 17, 21, 26, 30, 34, 38, 43, 47, 51, 55, 60, 64}
+)
+
+func bcWeights(indexBits uint) []uint32 {
+	switch indexBits {
+	case 2:
+		return bcWeights2
+	case 3:
+		return bcWeights3
+	}
+	return bcWeights4
+}
+
+type bc7Mode struct {
+	subsets        int
+	partitionBits  uint
+	rotationBits   uint
+	selectionBits  uint // index selection, only mode 4
+	colorBits      uint
+	alphaBits      uint
+	endpointPBits  bool // one p bit per endpoint
+	sharedPBits    bool // one p bit per subset
+	indexBits      uint
+	secondaryIndex uint // separate indices for alpha (or color, depending on the selection bit)
+}
+
+var bc7Modes = [8]bc7Mode{
+	{subsets: 3, partitionBits: 4, colorBits: 4, endpointPBits: true, indexBits: 3},
+	{subsets: 2, partitionBits: 6, colorBits: 6, sharedPBits: true, indexBits: 3},
+	{subsets: 3, partitionBits: 6, colorBits: 5, indexBits: 2},
+	{subsets: 2, partitionBits: 6, colorBits: 7, endpointPBits: true, indexBits: 2},
+	{subsets: 1, rotationBits: 2, selectionBits: 1, colorBits: 5, alphaBits: 6, indexBits: 2, secondaryIndex: 3},
+	{subsets: 1, rotationBits: 2, colorBits: 7, alphaBits: 8, indexBits: 2, secondaryIndex: 2},
+	{subsets: 1, colorBits: 7, alphaBits: 7, endpointPBits: true, indexBits: 4},
+	{subsets: 2, partitionBits: 6, colorBits: 5, alphaBits: 5, endpointPBits: true, indexBits: 2},
+}
+
+func decodeBC7Block(block []uint8, texels *[16][4]uint8) {
+	// the mode is the number of zero bits before the first one
+	modeIndex := 0
+	for modeIndex < 8 && block[0]>>modeIndex&1 == 0 {
+		modeIndex++
+	}
+	if modeIndex == 8 {
+		// reserved, decodes to transparent black
+		*texels = [16][4]uint8{}
+		return
+	}
+
+	mode := bc7Modes[modeIndex]
+	reader := newBlockBits(block)
+	reader.read(uint(modeIndex + 1))
+
+	partition := int(reader.read(mode.partitionBits))
+	rotation := reader.read(mode.rotationBits)
+	selection := reader.read(mode.selectionBits)
+
+	// endpoints go channel by channel, r for every endpoint first, then g and so on
+	var endpoints [6][4]uint32
+	endpointCount := mode.subsets * 2
+	for c := 0; c < 3; c++ {
+		for e := 0; e < endpointCount; e++ {
+			endpoints[e][c] = reader.read(mode.colorBits)
+		}
+	}
+	if mode.alphaBits > 0 {
+		for e := 0; e < endpointCount; e++ {
+			endpoints[e][3] = reader.read(mode.alphaBits)
+		}
+	}
+
+	colorBits, alphaBits := mode.colorBits, mode.alphaBits
+	if mode.endpointPBits || mode.sharedPBits {
+		var pBits [6]uint32
+		if mode.endpointPBits {
+			for e := 0; e < endpointCount; e++ {
+				pBits[e] = reader.read(1)
+			}
+		} else {
+			for s := 0; s < mode.subsets; s++ {
+				pBits[s*2] = reader.read(1)
+				pBits[s*2+1] = pBits[s*2]
+			}
+		}
+		for e := 0; e < endpointCount; e++ {
+			for c := 0; c < 4; c++ {
+				endpoints[e][c] = endpoints[e][c]<<1 | pBits[e]
+			}
+		}
+		colorBits++
+		if alphaBits > 0 {
+			alphaBits++
+		}
+	}
+
+	// scale everything up to 8 bits by repeating the top bits
+	for e := 0; e < endpointCount; e++ {
+		for c := 0; c < 3; c++ {
+			endpoints[e][c] = endpoints[e][c]<<(8-colorBits) | endpoints[e][c]>>(2*colorBits-8)
+		}
+		if alphaBits > 0 {
+			endpoints[e][3] = endpoints[e][3]<<(8-alphaBits) | endpoints[e][3]>>(2*alphaBits-8)
+		} else {
+			endpoints[e][3] = 0xff
+		}
+	}
+
+	var indices, secondaryIndices [16]uint32
+	for i := range indices {
+		count := mode.indexBits
+		if isAnchor(mode.subsets, partition, i) {
+			count--
+		}
+		indices[i] = reader.read(count)
+	}
+	if mode.secondaryIndex > 0 {
+		for i := range secondaryIndices {
+			count := mode.secondaryIndex
+			if i == 0 {
+				count--
+			}
+			secondaryIndices[i] = reader.read(count)
+		}
+	}
+
+	colorWeights, alphaWeights := bcWeights(mode.indexBits), bcWeights(mode.indexBits)
+	colorIndices, alphaIndices := &indices, &indices
+	if mode.secondaryIndex > 0 {
+		alphaWeights, alphaIndices = bcWeights(mode.secondaryIndex), &secondaryIndices
+		if selection == 1 {
+			colorWeights, alphaWeights = alphaWeights, colorWeights
+			colorIndices, alphaIndices = alphaIndices, colorIndices
+		}
+	}
+
+	for i := range texels {
+		subset := partitionSubset(mode.subsets, partition, i)
+		endpoint0, endpoint1 := endpoints[subset*2], endpoints[subset*2+1]
+
+		colorWeight := colorWeights[colorIndices[i]]
+		alphaWeight := alphaWeights[alphaIndices[i]]
+		for c := 0; c < 3; c++ {
+			texels[i][c] = uint8(((64-colorWeight)*endpoint0[c] + colorWeight*endpoint1[c] + 32) >> 6)
+		}
+		texels[i][3] = uint8(((64-alphaWeight)*endpoint0[3] + alphaWeight*endpoint1[3] + 32) >> 6)
+
+		// rotation swaps alpha with one of the color channels
+		if rotation > 0 {
+			texels[i][rotation-1], texels[i][3] = texels[i][3], texels[i][rotation-1]
+		}
+	}
+}
+
+// One run of endpoint bits in a BC6H header: endpoint (w, x, y, z) and channel (r, g, b), which bits of it,
+// and whether they're stored highest bit first
+type bc6hField struct {
+	endpoint, channel uint8
+	shift, count      uint8
+	reversed          bool
+}
+
+type bc6hMode struct {
+	transformed  bool
+	endpointBits uint
+	deltaBits    [3]uint
+	subsets      int
+	fields       []bc6hField
+}
+
+// shorthands for writing down the bit layouts below, rw[6:0] is bits(w, r, 0, 7)
+const (
+	bcW, bcX, bcY, bcZ = 0, 1, 2, 3
+	bcR, bcG, bcB      = 0, 1, 2
+)
+
+func bits(endpoint uint8, channel uint8, shift uint8, count uint8) bc6hField {
+	return bc6hField{endpoint: endpoint, channel: channel, shift: shift, count: count}
+}
+
+func bit(endpoint uint8, channel uint8, shift uint8) bc6hField {
+	return bits(endpoint, channel, shift, 1)
+}
+
+func reversedBits(endpoint uint8, channel uint8, shift uint8, count uint8) bc6hField {
+	return bc6hField{endpoint: endpoint, channel: channel, shift: shift, count: count, reversed: true}
+}
+
+// keyed by the mode bits, the 2 bit modes are 0 and 1. The layouts are straight from the format spec
+var bc6hModes = map[uint32]bc6hMode{
+	0x00: {true, 10, [3]uint{5, 5, 5}, 2, []bc6hField{
+		bit(bcY, bcG, 4), bit(bcY, bcB, 4), bit(bcZ, bcB, 4), bits(bcW, bcR, 0, 10), bits(bcW, bcG, 0, 10), bits(bcW, bcB, 0, 10),
+		bits(bcX, bcR, 0, 5), bit(bcZ, bcG, 4), bits(bcY, bcG, 0, 4), bits(bcX, bcG, 0, 5), bit(bcZ, bcB, 0), bits(bcZ, bcG, 0, 4),
+		bits(bcX, bcB, 0, 5), bit(bcZ, bcB, 1), bits(bcY, bcB, 0, 4), bits(bcY, bcR, 0, 5), bit(bcZ, bcB, 2), bits(bcZ, bcR, 0, 5), bit(bcZ, bcB, 3),
+	}},
+	0x01: {true, 7, [3]uint{6, 6, 6}, 2, []bc6hField{
+		bit(bcY, bcG, 5), bit(bcZ, bcG, 4), bit(bcZ, bcG, 5), bits(bcW, bcR, 0, 7), bit(bcZ, bcB, 0), bit(bcZ, bcB, 1), bit(bcY, bcB, 4),
+		bits(bcW, bcG, 0, 7), bit(bcY, bcB, 5), bit(bcZ, bcB, 2), bit(bcY, bcG, 4), bits(bcW, bcB, 0, 7), bit(bcZ, bcB, 3), bit(bcZ, bcB, 5),
+		bit(bcZ, bcB, 4), bits(bcX, bcR, 0, 6), bits(bcY, bcG, 0, 4), bits(bcX, bcG, 0, 6), bits(bcZ, bcG, 0, 4), bits(bcX, bcB, 0, 6),
+		bits(bcY, bcB, 0, 4), bits(bcY, bcR, 0, 6), bits(bcZ, bcR, 0, 6),
+	}},
+	0x02: {true, 11, [3]uint{5, 4, 4}, 2, []bc6hField{
+		bits(bcW, bcR, 0, 10), bits(bcW, bcG, 0, 10), bits(bcW, bcB, 0, 10), bits(bcX, bcR, 0, 5), bit(bcW, bcR, 10), bits(bcY, bcG, 0, 4),
+		bits(bcX, bcG, 0, 4), bit(bcW, bcG, 10), bit(bcZ, bcB, 0), bits(bcZ, bcG, 0, 4), bits(bcX, bcB, 0, 4), bit(bcW, bcB, 10),
+		bit(bcZ, bcB, 1), bits(bcY, bcB, 0, 4), bits(bcY, bcR, 0, 5), bit(bcZ, bcB, 2), bits(bcZ, bcR, 0, 5), bit(bcZ, bcB, 3),
+	}},
+	0x06: {true, 11, [3]uint{4, 5, 4}, 2, []bc6hField{
+		bits(bcW, bcR, 0, 10), bits(bcW, bcG, 0, 10), bits(bcW, bcB, 0, 10), bits(bcX, bcR, 0, 4), bit(bcW, bcR, 10), bit(bcZ, bcG, 4),
+		bits(bcY, bcG, 0, 4), bits(bcX, bcG, 0, 5), bit(bcW, bcG, 10), bits(bcZ, bcG, 0, 4), bits(bcX, bcB, 0, 4), bit(bcW, bcB, 10),
+		bit(bcZ, bcB, 1), bits(bcY, bcB, 0, 4), bits(bcY, bcR, 0, 4), bit(bcZ, bcB, 0), bit(bcZ, bcB, 2), bits(bcZ, bcR, 0, 4),
+		bit(bcY, bcG, 4), bit(bcZ, bcB, 3),
+	}},
+	0x0a: {true, 11, [3]uint{4, 4, 5}, 2, []bc6hField{
+		bits(bcW, bcR, 0, 10), bits(bcW, bcG, 0, 10), bits(bcW, bcB, 0, 10), bits(bcX, bcR, 0, 4), bit(bcW, bcR, 10), bit(bcY, bcB, 4),
+		bits(bcY, bcG, 0, 4), bits(bcX, bcG, 0, 4), bit(bcW, bcG, 10), bit(bcZ, bcB, 0), bits(bcZ, bcG, 0, 4), bits(bcX, bcB, 0, 5),
+		bit(bcW, bcB, 10), bits(bcY, bcB, 0, 4), bits(bcY, bcR, 0, 4), bit(bcZ, bcB, 1), bit(bcZ, bcB, 2), bits(bcZ, bcR, 0, 4),
+		bit(bcZ, bcB, 4), bit(bcZ, bcB, 3),
+	}},
+	0x0e: {true, 9, [3]uint{5, 5, 5}, 2, []bc6hField{
+		bits(bcW, bcR, 0, 9), bit(bcY, bcB, 4), bits(bcW, bcG, 0, 9), bit(bcY, bcG, 4), bits(bcW, bcB, 0, 9), bit(bcZ, bcB, 4),
+		bits(bcX, bcR, 0, 5), bit(bcZ, bcG, 4), bits(bcY, bcG, 0, 4), bits(bcX, bcG, 0, 5), bit(bcZ, bcB, 0), bits(bcZ, bcG, 0, 4),
+		bits(bcX, bcB, 0, 5), bit(bcZ, bcB, 1), bits(bcY, bcB, 0, 4), bits(bcY, bcR, 0, 5), bit(bcZ, bcB, 2), bits(bcZ, bcR, 0, 5), bit(bcZ, bcB, 3),
+	}},
+	0x12: {true, 8, [3]uint{6, 5, 5}, 2, []bc6hField{
+		bits(bcW, bcR, 0, 8), bit(bcZ, bcG, 4), bit(bcY, bcB, 4), bits(bcW, bcG, 0, 8), bit(bcZ, bcB, 2), bit(bcY, bcG, 4),
+		bits(bcW, bcB, 0, 8), bit(bcZ, bcB, 3), bit(bcZ, bcB, 4), bits(bcX, bcR, 0, 6), bits(bcY, bcG, 0, 4), bits(bcX, bcG, 0, 5),
+		bit(bcZ, bcB, 0), bits(bcZ, bcG, 0, 4), bits(bcX, bcB, 0, 5), bit(bcZ, bcB, 1), bits(bcY, bcB, 0, 4), bits(bcY, bcR, 0, 6),
+		bits(bcZ, bcR, 0, 6),
+	}},
+	0x16: {true, 8, [3]uint{5, 6, 5}, 2, []bc6hField{
+		bits(bcW, bcR, 0, 8), bit(bcZ, bcB, 0), bit(bcY, bcB, 4), bits(bcW, bcG, 0, 8), bit(bcY, bcG, 5), bit(bcY, bcG, 4),
+		bits(bcW, bcB, 0, 8), bit(bcZ, bcG, 5), bit(bcZ, bcB, 4), bits(bcX, bcR, 0, 5), bit(bcZ, bcG, 4), bits(bcY, bcG, 0, 4),
+		bits(bcX, bcG, 0, 6), bits(bcZ, bcG, 0, 4), bits(bcX, bcB, 0, 5), bit(bcZ, bcB, 1), bits(bcY, bcB, 0, 4), bits(bcY, bcR, 0, 5),
+		bit(bcZ, bcB, 2), bits(bcZ, bcR, 0, 5), bit(bcZ, bcB, 3),
+	}},
+	0x1a: {true, 8, [3]uint{5, 5, 6}, 2, []bc6hField{
+		bits(bcW, bcR, 0, 8), bit(bcZ, bcB, 1), bit(bcY, bcB, 4), bits(bcW, bcG, 0, 8), bit(bcY, bcB, 5), bit(bcY, bcG, 4),
+		bits(bcW, bcB, 0, 8), bit(bcZ, bcB, 5), bit(bcZ, bcB, 4), bits(bcX, bcR, 0, 5), bit(bcZ, bcG, 4), bits(bcY, bcG, 0, 4),
+		bits(bcX, bcG, 0, 5), bit(bcZ, bcB, 0), bits(bcZ, bcG, 0, 4), bits(bcX, bcB, 0, 6), bits(bcY, bcB, 0, 4), bits(bcY, bcR, 0, 5),
+		bit(bcZ, bcB, 2), bits(bcZ, bcR, 0, 5), bit(bcZ, bcB, 3),
+	}},
+	0x1e: {false, 6, [3]uint{6, 6, 6}, 2, []bc6hField{
+		bits(bcW, bcR, 0, 6), bit(bcZ, bcG, 4), bit(bcZ, bcB, 0), bit(bcZ, bcB, 1), bit(bcY, bcB, 4), bits(bcW, bcG, 0, 6),
+		bit(bcY, bcG, 5), bit(bcY, bcB, 5), bit(bcZ, bcB, 2), bit(bcY, bcG, 4), bits(bcW, bcB, 0, 6), bit(bcZ, bcG, 5),
+		bit(bcZ, bcB, 3), bit(bcZ, bcB, 5), bit(bcZ, bcB, 4), bits(bcX, bcR, 0, 6), bits(bcY, bcG, 0, 4), bits(bcX, bcG, 0, 6),
+		bits(bcZ, bcG, 0, 4), bits(bcX, bcB, 0, 6), bits(bcY, bcB, 0, 4), bits(bcY, bcR, 0, 6), bits(bcZ, bcR, 0, 6),
+	}},
+	0x03: {false, 10, [3]uint{10, 10, 10}, 1, []bc6hField{
+		bits(bcW, bcR, 0, 10), bits(bcW, bcG, 0, 10), bits(bcW, bcB, 0, 10), bits(bcX, bcR, 0, 10), bits(bcX, bcG, 0, 10), bits(bcX, bcB, 0, 10),
+	}},
+	0x07: {true, 11, [3]uint{9, 9, 9}, 1, []bc6hField{
+		bits(bcW, bcR, 0, 10), bits(bcW, bcG, 0, 10), bits(bcW, bcB, 0, 10), bits(bcX, bcR, 0, 9), bit(bcW, bcR, 10),
+		bits(bcX, bcG, 0, 9), bit(bcW, bcG, 10), bits(bcX, bcB, 0, 9), bit(bcW, bcB, 10),
+	}},
+	0x0b: {true, 12, [3]uint{8, 8, 8}, 1, []bc6hField{
+		bits(bcW, bcR, 0, 10), bits(bcW, bcG, 0, 10), bits(bcW, bcB, 0, 10), bits(bcX, bcR, 0, 8), reversedBits(bcW, bcR, 10, 2),
+		bits(bcX, bcG, 0, 8), reversedBits(bcW, bcG, 10, 2), bits(bcX, bcB, 0, 8), reversedBits(bcW, bcB, 10, 2),
+	}},
+	0x0f: {true, 16, [3]uint{4, 4, 4}, 1, []bc6hField{
+		bits(bcW, bcR, 0, 10), bits(bcW, bcG, 0, 10), bits(bcW, bcB, 0, 10), bits(bcX, bcR, 0, 4), reversedBits(bcW, bcR, 10, 6),
+		bits(bcX, bcG, 0, 4), reversedBits(bcW, bcG, 10, 6), bits(bcX, bcB, 0, 4), reversedBits(bcW, bcB, 10, 6),
+	}},
+}
+
+func signExtend(value int32, bits uint) int32 {
+	shift := 32 - bits
+	return value << shift >> shift
+}
+
+func bc6hUnquantize(value int32, bits uint, signed bool) int32 {
+	if !signed {
+		switch {
+		case bits >= 15:
+			return value
+		case value == 0:
+			return 0
+		case value == 1<<bits-1:
+			return 0xffff
+		}
+		return (value<<16 + 0x8000) >> bits
+	}
+
+	if bits >= 16 {
+		return value
+	}
+	negative := value < 0
+	if negative {
+		value = -value
+	}
+	var result int32
+	switch {
+	case value == 0:
+		result = 0
+	case value >= 1<<(bits-1)-1:
+		result = 0x7fff
+	default:
+		result = (value<<15 + 0x4000) >> (bits - 1)
+	}
+	if negative {
+		result = -result
+	}
+	return result
+}
+
+// the interpolated values are scaled so they end up as valid half float bit patterns
+func bc6hToHalf(value int32, signed bool) uint16 {
+	if !signed {
+		return uint16(value * 31 >> 6)
+	}
+	if value < 0 {
+		return 0x8000 | uint16((-value)*31>>5)
+	}
+	return uint16(value * 31 >> 5)
+}
+
+func halfToFloat(half uint16) float32 {
+	sign := uint32(half>>15) << 31
+	exponent := uint32(half >> 10 & 0x1f)
+	mantissa := uint32(half & 0x3ff)
+
+	switch {
+	case exponent == 0 && mantissa == 0:
+		return math.Float32frombits(sign)
+	case exponent == 0:
+		// denormal, which is just a very small normal float
+		value := float32(mantissa) / (1 << 24)
+		if sign != 0 {
+			value = -value
+		}
+		return value
+	case exponent == 0x1f:
+		return math.Float32frombits(sign | 0xff<<23 | mantissa<<13)
+	}
+	return math.Float32frombits(sign | (exponent+112)<<23 | mantissa<<13)
+}
+
+func decodeBC6HBlock(block []uint8, texels *[16][3]float32, signed bool) {
+	reader := newBlockBits(block)
+	modeBits := reader.read(2)
+	if modeBits > 1 {
+		modeBits |= reader.read(3) << 2
+	}
+
+	mode, ok := bc6hModes[modeBits]
+	if !ok {
+		// reserved modes decode to black
+		*texels = [16][3]float32{}
+		return
+	}
+
+	var endpoints [4][3]int32
+	for _, field := range mode.fields {
+		value := reader.read(uint(field.count))
+		if field.reversed {
+			reversed := uint32(0)
+			for i := uint8(0); i < field.count; i++ {
+				reversed = reversed<<1 | value>>i&1
+			}
+			value = reversed
+		}
+		endpoints[field.endpoint][field.channel] |= int32(value << field.shift)
+	}
+
+	partition := 0
+	if mode.subsets == 2 {
+		partition = int(reader.read(5))
+	}
+
+	endpointCount := mode.subsets * 2
+	for c := 0; c < 3; c++ {
+		if signed {
+			endpoints[0][c] = signExtend(endpoints[0][c], mode.endpointBits)
+		}
+		for e := 1; e < endpointCount; e++ {
+			if mode.transformed || signed {
+				endpoints[e][c] = signExtend(endpoints[e][c], mode.deltaBits[c])
+			}
+			if mode.transformed {
+				// the other endpoints are stored as deltas from the first one
+				endpoints[e][c] = (endpoints[0][c] + endpoints[e][c]) & (1<<mode.endpointBits - 1)
+				if signed {
+					endpoints[e][c] = signExtend(endpoints[e][c], mode.endpointBits)
+				}
+			}
+		}
+		for e := 0; e < endpointCount; e++ {
+			endpoints[e][c] = bc6hUnquantize(endpoints[e][c], mode.endpointBits, signed)
+		}
+	}
+
+	indexBits := uint(3)
+	if mode.subsets == 1 {
+		indexBits = 4
+	}
+	weights := bcWeights(indexBits)
+
+	for i := range texels {
+		count := indexBits
+		if isAnchor(mode.subsets, partition, i) {
+			count--
+		}
+		weight := int32(weights[reader.read(count)])
+
+		subset := partitionSubset(mode.subsets, partition, i)
+		endpoint0, endpoint1 := endpoints[subset*2], endpoints[subset*2+1]
+		for c := 0; c < 3; c++ {
+			value := ((64-weight)*endpoint0[c] + weight*endpoint1[c] + 32) >> 6
+			texels[i][c] = halfToFloat(bc6hToHalf(value, signed))
+		}
+	}
+}
+
+// Flips BC1-BC5 blocks upside down without decoding them, the block rows get reversed and so do the
+// texel rows inside every block. rows is how many texel rows of a block are actually used, which is only
+// less than 4 for images shorter than a block. BC6H and BC7 can't be flipped like this
+func flipBlocks(format textureFormat, blocks []uint8, width int, height int) bool {
+	blocksX, blocksY := (width+3)/4, (height+3)/4
+	blockBytes := format.blockBytes()
+	rows := 4
+	if height < 4 {
+		rows = height
+	} else if height%4 != 0 {
+		// the used rows would end up in a different block than the unused ones
+		return false
+	}
+
+	flipColor := func(block []uint8) {
+		// 2 bit indices, one byte per row
+		reverseRows(block[4:8], 1, rows)
+	}
+	flipBC4 := func(block []uint8) {
+		// 3 bit indices, 12 bits per row
+		indices := uint64(block[2]) | uint64(block[3])<<8 | uint64(block[4])<<16 | uint64(block[5])<<24 | uint64(block[6])<<32 | uint64(block[7])<<40
+		flipped := indices
+		for y := 0; y < rows; y++ {
+			row := indices >> (12 * y) & 0xfff
+			target := rows - 1 - y
+			flipped = flipped&^(0xfff<<(12*target)) | row<<(12*target)
+		}
+		for i := 0; i < 6; i++ {
+			block[2+i] = uint8(flipped >> (8 * i))
+		}
+	}
+
+	var flipBlock func(block []uint8)
+	switch format {
+	case textureBC1:
+		flipBlock = flipColor
+	case textureBC2:
+		flipBlock = func(block []uint8) {
+			// 4 bit alpha, two bytes per row
+			reverseRows(block[0:8], 2, rows)
+			flipColor(block[8:])
+		}
+	case textureBC3:
+		flipBlock = func(block []uint8) {
+			flipBC4(block)
+			flipColor(block[8:])
+		}
+	case textureBC4, textureBC4Signed:
+		flipBlock = flipBC4
+	case textureBC5, textureBC5Signed:
+		flipBlock = func(block []uint8) {
+			flipBC4(block)
+			flipBC4(block[8:])
+		}
+	default:
+		return false
+	}
+
+	for i := 0; i < blocksX*blocksY; i++ {
+		flipBlock(blocks[i*blockBytes : (i+1)*blockBytes])
+	}
+	flipRows(blocks[:blocksX*blocksY*blockBytes], blocksX*blockBytes)
+
+	return true
+}
+
+// reverses the first rows rows of size bytes each
+func reverseRows(data []uint8, size int, rows int) {
+	for top, bottom := 0, rows-1; top < bottom; top, bottom = top+1, bottom-1 {
+		for i := 0; i < size; i++ {
+			data[top*size+i], data[bottom*size+i] = data[bottom*size+i], data[top*size+i]
+		}
+	}
+}
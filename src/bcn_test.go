@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"testing"
+)
+
+// Builds a 128 bit block lowest bit first, the way BC6H and BC7 blocks are laid out
+type blockWriter struct {
+	low, high uint64
+	position  uint
+}
+
+func (writer *blockWriter) write(value uint32, count uint) {
+	for i := uint(0); i < count; i++ {
+		bit := uint64(value >> i & 1)
+		if writer.position < 64 {
+			writer.low |= bit << writer.position
+		} else {
+			writer.high |= bit << (writer.position - 64)
+		}
+		writer.position++
+	}
+}
+
+func (writer *blockWriter) block(t *testing.T) []uint8 {
+	if writer.position != 128 {
+		t.Fatalf("block has %d bits instead of 128", writer.position)
+	}
+	block := make([]uint8, 16)
+	binary.LittleEndian.PutUint64(block, writer.low)
+	binary.LittleEndian.PutUint64(block[8:], writer.high)
+	return block
+}
+
+// packs 3 bit indices behind two endpoint bytes, for BC4 blocks
+func bc4Block(endpoint0 uint8, endpoint1 uint8, indices [16]uint8) []uint8 {
+	var packed uint64
+	for i, index := range indices {
+		packed |= uint64(index) << (3 * i)
+	}
+	block := []uint8{endpoint0, endpoint1, 0, 0, 0, 0, 0, 0}
+	for i := 0; i < 6; i++ {
+		block[2+i] = uint8(packed >> (8 * i))
+	}
+	return block
+}
+
+func checkTexels(t *testing.T, name string, got *[16][4]uint8, want map[int][4]uint8) {
+	t.Helper()
+	for texel, color := range want {
+		if got[texel] != color {
+			t.Errorf("%s: texel %d is %v, want %v", name, texel, got[texel], color)
+		}
+	}
+}
+
+func TestBC1Block(t *testing.T) {
+	var texels [16][4]uint8
+
+	// red > blue, so 4 colors. Every row has indices 0 1 2 3
+	block := []uint8{0x00, 0xf8, 0x1f, 0x00, 0xe4, 0xe4, 0xe4, 0xe4}
+	decodeBC1Block(block, &texels, true)
+	checkTexels(t, "4 colors", &texels, map[int][4]uint8{
+		0: {255, 0, 0, 255}, 1: {0, 0, 255, 255}, 2: {170, 0, 85, 255}, 3: {85, 0, 170, 255}, 15: {85, 0, 170, 255},
+	})
+
+	// blue <= red, 3 colors and transparent black
+	block = []uint8{0x1f, 0x00, 0x00, 0xf8, 0xe4, 0xe4, 0xe4, 0xe4}
+	decodeBC1Block(block, &texels, true)
+	checkTexels(t, "3 colors", &texels, map[int][4]uint8{
+		0: {0, 0, 255, 255}, 1: {255, 0, 0, 255}, 2: {127, 0, 127, 255}, 3: {0, 0, 0, 0},
+	})
+
+	// inside BC2 and BC3 the same block always has 4 colors
+	decodeBC1Block(block, &texels, false)
+	checkTexels(t, "3 colors without transparency", &texels, map[int][4]uint8{
+		2: {85, 0, 170, 255}, 3: {170, 0, 85, 255},
+	})
+}
+
+func TestBC4SignedBlock(t *testing.T) {
+	var texels [16][4]uint8
+
+	// 1 and -1, 8 values
+	decodeBC4Block(bc4Block(0x7f, 0x81, [16]uint8{0, 1, 2, 7}), &texels, 0, true)
+	checkTexels(t, "8 values", &texels, map[int][4]uint8{
+		0: {255}, 1: {0}, 2: {219}, 3: {36},
+	})
+
+	// -128 is the same as -127
+	decodeBC4Block(bc4Block(0x80, 0x7f, [16]uint8{0, 1}), &texels, 0, true)
+	checkTexels(t, "-128", &texels, map[int][4]uint8{0: {0}, 1: {255}})
+
+	// -1 and 1, 6 values and the two extremes
+	decodeBC4Block(bc4Block(0x81, 0x7f, [16]uint8{0, 1, 2, 6, 7}), &texels, 0, true)
+	checkTexels(t, "6 values", &texels, map[int][4]uint8{
+		0: {0}, 1: {255}, 2: {51}, 3: {0}, 4: {255},
+	})
+}
+
+func TestBC5SignedBlocks(t *testing.T) {
+	// red goes from -1 to 1, green is 0 (stored as 0 which is half way)
+	block := append(bc4Block(0x81, 0x7f, [16]uint8{0, 1}), bc4Block(0, 0, [16]uint8{})...)
+	pixels, err := decodeBlocks(textureBC5Signed, block, 2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []uint8{0, 128, 0, 255, 128, 0}; !bytes.Equal(pixels, want) {
+		t.Errorf("got %v, want %v", pixels, want)
+	}
+}
+
+func TestBC7Mode5Rotation(t *testing.T) {
+	writer := &blockWriter{}
+	writer.write(1<<5, 6) // mode 5
+	writer.write(1, 2)    // rotation 1 swaps red and alpha
+	for _, endpoint := range []uint32{0x7f, 0, 0, 0x7f, 0x40, 0x40} {
+		writer.write(endpoint, 7)
+	}
+	writer.write(0x10, 8)
+	writer.write(0xf0, 8)
+
+	// color indices, the first one has a bit less
+	writer.write(0, 1)
+	writer.write(3, 2)
+	writer.write(1, 2)
+	for i := 3; i < 16; i++ {
+		writer.write(0, 2)
+	}
+	// alpha indices
+	writer.write(0, 1)
+	writer.write(3, 2)
+	writer.write(2, 2)
+	for i := 3; i < 16; i++ {
+		writer.write(0, 2)
+	}
+
+	var texels [16][4]uint8
+	decodeBC7Block(writer.block(t), &texels)
+	checkTexels(t, "mode 5", &texels, map[int][4]uint8{
+		0: {16, 0, 129, 255}, 1: {240, 255, 129, 0}, 2: {167, 84, 129, 171}, 15: {16, 0, 129, 255},
+	})
+}
+
+func TestBC7Mode2ThreeSubsets(t *testing.T) {
+	writer := &blockWriter{}
+	writer.write(1<<2, 3) // mode 2
+	writer.write(0, 6)    // partition 0: 0 0 1 1 / 0 0 1 1 / 0 2 2 1 / 2 2 2 2
+
+	// every subset goes from one full channel to black, 5 bits per channel
+	endpoints := [6][3]uint32{{31, 0, 0}, {0, 0, 0}, {0, 31, 0}, {0, 0, 0}, {0, 0, 31}, {0, 0, 0}}
+	for c := 0; c < 3; c++ {
+		for _, endpoint := range endpoints {
+			writer.write(endpoint[c], 5)
+		}
+	}
+
+	// texels 0, 3 and 15 are the anchors of the three subsets and have a bit less
+	indices := [16]uint32{5: 3, 2: 3, 14: 2, 15: 1}
+	for i, index := range indices {
+		count := uint(2)
+		if i == 0 || i == 3 || i == 15 {
+			count = 1
+		}
+		writer.write(index, count)
+	}
+
+	var texels [16][4]uint8
+	decodeBC7Block(writer.block(t), &texels)
+	checkTexels(t, "mode 2", &texels, map[int][4]uint8{
+		0: {255, 0, 0, 255}, 5: {0, 0, 0, 255}, 8: {255, 0, 0, 255},
+		2: {0, 0, 0, 255}, 3: {0, 255, 0, 255}, 11: {0, 255, 0, 255},
+		9: {0, 0, 255, 255}, 14: {0, 0, 84, 255}, 15: {0, 0, 171, 255},
+	})
+}
+
+func TestBC6HMode11(t *testing.T) {
+	writer := &blockWriter{}
+	writer.write(0x03, 5)
+	// red is 1.0 everywhere (495 unquantizes to 0x3c00), green goes from 0 to the biggest half, blue is 0
+	for _, endpoint := range []uint32{495, 0, 0, 495, 1023, 0} {
+		writer.write(endpoint, 10)
+	}
+	writer.write(0, 3)
+	writer.write(15, 4)
+	writer.write(7, 4)
+	for i := 3; i < 16; i++ {
+		writer.write(0, 4)
+	}
+
+	var texels [16][3]float32
+	decodeBC6HBlock(writer.block(t), &texels, false)
+	want := map[int][3]float32{0: {1, 0, 0}, 1: {1, 65504, 0}, 2: {1, 0.765625, 0}, 15: {1, 0, 0}}
+	for texel, color := range want {
+		if texels[texel] != color {
+			t.Errorf("texel %d is %v, want %v", texel, texels[texel], color)
+		}
+	}
+}
+
+func TestFlipBlocks(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+
+	for _, format := range []textureFormat{textureBC1, textureBC2, textureBC3, textureBC4, textureBC5Signed} {
+		for _, size := range [][2]int{{8, 8}, {12, 4}, {8, 2}, {4, 1}} {
+			width, height := size[0], size[1]
+			blocks := make([]uint8, format.imageSize(width, height))
+			random.Read(blocks)
+
+			flipped := append([]uint8(nil), blocks...)
+			if !flipBlocks(format, flipped, width, height) {
+				t.Fatalf("%v %dx%d: can't be flipped", format, width, height)
+			}
+
+			// flipping the blocks has to be the same as flipping the decoded texels
+			original, _ := decodeBlocks(format, blocks, width, height)
+			decoded, _ := decodeBlocks(format, flipped, width, height)
+			rowBytes := width * format.pixelBytes()
+			for y := 0; y < height; y++ {
+				if !bytes.Equal(decoded[y*rowBytes:(y+1)*rowBytes], original[(height-1-y)*rowBytes:(height-y)*rowBytes]) {
+					t.Errorf("%v %dx%d: row %d isn't row %d of the original", format, width, height, y, height-1-y)
+				}
+			}
+
+			flipBlocks(format, flipped, width, height)
+			if !bytes.Equal(flipped, blocks) {
+				t.Errorf("%v %dx%d: flipping twice doesn't give back the original blocks", format, width, height)
+			}
+		}
+	}
+
+	if flipBlocks(textureBC7, make([]uint8, 16), 4, 4) {
+		t.Error("BC7 blocks got flipped")
+	}
+	if flipBlocks(textureBC1, make([]uint8, 16), 4, 6) {
+		t.Error("a 4x6 image got flipped, its used rows are spread over two blocks")
+	}
+}
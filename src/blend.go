@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	gl "github.com/go-gl/gl/v4.6-core/gl"
+
+	glm "github.com/go-gl/mathgl/mgl32"
+)
+
+// How a material's color gets combined with what's already in the framebuffer
+type blendMode int
+
+const (
+	blendOpaque        blendMode = iota
+	blendAlphaTest               // opaque, but texels with alpha under the material's cutoff get thrown away
+	blendAlpha                   // straight alpha
+	blendPremultiplied           // alpha that's already multiplied into the color, the texture has to be premultiplied
+	blendAdditive                // adds the color on top, weighted by alpha. glows, fire and the like
+)
+
+var blendModeNames = []string{"opaque", "cutoff", "alpha", "premultiplied", "additive"}
+
+func parseBlendMode(name string) (blendMode, error) {
+	for mode, modeName := range blendModeNames {
+		if name == modeName {
+			return blendMode(mode), nil
+		}
+	}
+	return blendOpaque, fmt.Errorf("unknown blend mode %q", name)
+}
+
+func (mode blendMode) String() string {
+	if int(mode) < len(blendModeNames) {
+		return blendModeNames[mode]
+	}
+	return fmt.Sprintf("blend mode %d", int(mode))
+}
+
+// transparent draws blend with what's behind them, so they need everything behind them drawn first
+func (mode blendMode) transparent() bool {
+	return mode >= blendAlpha
+}
+
+// Sets the blend state. Transparent draws still get depth tested but don't write depth, so they can't
+// hide each other when the sorting isn't perfect
+func (mode blendMode) apply() {
+	switch mode {
+	case blendAlpha:
+		gl.Enable(gl.BLEND)
+		gl.BlendFuncSeparate(gl.SRC_ALPHA, gl.ONE_MINUS_SRC_ALPHA, gl.ONE, gl.ONE_MINUS_SRC_ALPHA)
+	case blendPremultiplied:
+		gl.Enable(gl.BLEND)
+		gl.BlendFunc(gl.ONE, gl.ONE_MINUS_SRC_ALPHA)
+	case blendAdditive:
+		gl.Enable(gl.BLEND)
+		gl.BlendFuncSeparate(gl.SRC_ALPHA, gl.ONE, gl.ZERO, gl.ONE)
+	default:
+		gl.Disable(gl.BLEND)
+	}
+	gl.DepthMask(!mode.transparent())
+}
+
+// Sets the uniforms the fragment shader uses for materials
+func setMaterialUniforms(program *Program, material *mtlMaterial) error {
+	cutoff := float32(0)
+	if material.blend == blendAlphaTest {
+		cutoff = material.alphaCutoff
+	}
+
+	if err := program.SetFloat("u_AlphaCutoff", cutoff); err != nil {
+		return err
+	}
+	if err := program.SetFloat("u_Opacity", material.opacity); err != nil {
+		return err
+	}
+	return program.SetBool("u_PremultipliedAlpha", material.blend == blendPremultiplied)
+}
+
+// One thing to draw, the queue only needs to know how it blends and where it is
+type drawCall struct {
+	blend    blendMode
+	position glm.Vec3 // in world space, draws get sorted by their distance to the camera
+	draw     func()
+}
+
+// Collects a frame's draws so they can go out in the right order. Everything opaque goes first, front to
+// back so hidden pixels get rejected by the depth test early. The transparent draws go last, back to
+// front, so every one of them blends over whatever is behind it
+type renderQueue struct {
+	opaque      []drawCall
+	transparent []drawCall
+}
+
+func (queue *renderQueue) add(call drawCall) {
+	if call.blend.transparent() {
+		queue.transparent = append(queue.transparent, call)
+	} else {
+		queue.opaque = append(queue.opaque, call)
+	}
+}
+
+func (queue *renderQueue) drawOpaque(camera glm.Vec3) {
+	sort.SliceStable(queue.opaque, func(i, j int) bool {
+		return queue.opaque[i].position.Sub(camera).Len() < queue.opaque[j].position.Sub(camera).Len()
+	})
+
+	blendOpaque.apply()
+	for _, call := range queue.opaque {
+		call.draw()
+	}
+	queue.opaque = queue.opaque[:0]
+}
+
+// Leaves the blend state the way opaque draws want it
+func (queue *renderQueue) drawTransparent(camera glm.Vec3) {
+	sort.SliceStable(queue.transparent, func(i, j int) bool {
+		return queue.transparent[i].position.Sub(camera).Len() > queue.transparent[j].position.Sub(camera).Len()
+	})
+
+	for _, call := range queue.transparent {
+		call.blend.apply()
+		call.draw()
+	}
+	queue.transparent = queue.transparent[:0]
+
+	blendOpaque.apply()
+}
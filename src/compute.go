@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+
+	gl "github.com/go-gl/gl/v4.6-core/gl"
+)
+
+// The local size of a compute program, the size of a work group that comes from the shader's
+// layout(local_size_x = ...). A dispatch says how many groups to run
+func (program *Program) WorkGroupSize() [3]int32 {
+	var size [3]int32
+	if program.compute {
+		gl.GetProgramiv(program.Handle, gl.COMPUTE_WORK_GROUP_SIZE, &size[0])
+	}
+	return size
+}
+
+// Runs the program on groupsX * groupsY * groupsZ work groups, then puts up the barriers. Uses the program,
+// so it's still the current one afterwards. Writes from a compute shader (to images or storage buffers)
+// aren't visible to whatever reads them next until there's a memory barrier for that kind of read,
+// barriers has the bits for it:
+// gl.SHADER_IMAGE_ACCESS_BARRIER_BIT when another shader reads the image with imageLoad,
+// gl.TEXTURE_FETCH_BARRIER_BIT when it gets sampled, gl.SHADER_STORAGE_BARRIER_BIT for storage buffers,
+// gl.VERTEX_ATTRIB_ARRAY_BARRIER_BIT when the buffer gets drawn from, and so on. 0 is no barrier
+func (program *Program) Dispatch(groupsX uint32, groupsY uint32, groupsZ uint32, barriers uint32) error {
+	if !program.compute {
+		return fmt.Errorf("Program %d isn't a compute program", program.Handle)
+	}
+
+	groups := [3]uint32{groupsX, groupsY, groupsZ}
+	for i, count := range groups {
+		var max int32
+		gl.GetIntegeri_v(gl.MAX_COMPUTE_WORK_GROUP_COUNT, uint32(i), &max)
+		if count == 0 || count > uint32(max) {
+			return fmt.Errorf("Can't dispatch %dx%dx%d work groups, the driver allows 1 to %d in %c", groupsX, groupsY, groupsZ, max, "xyz"[i])
+		}
+	}
+
+	program.Use()
+	gl.DispatchCompute(groupsX, groupsY, groupsZ)
+	if barriers != 0 {
+		gl.MemoryBarrier(barriers)
+	}
+	return nil
+}
+
+// Dispatches enough work groups for one invocation per element of a width * height * depth grid, like
+// one per pixel of an image. The last groups can go past the edges, the shader has to check for that
+func (program *Program) DispatchGrid(width int, height int, depth int, barriers uint32) error {
+	size := program.WorkGroupSize()
+	groups := [3]uint32{}
+	for i, count := range [3]int{width, height, depth} {
+		if count <= 0 {
+			return fmt.Errorf("Can't dispatch a %dx%dx%d grid", width, height, depth)
+		}
+		local := maxInt(int(size[i]), 1)
+		groups[i] = uint32((count + local - 1) / local)
+	}
+	return program.Dispatch(groups[0], groups[1], groups[2], barriers)
+}
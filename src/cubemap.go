@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"path"
+)
+
+// Cubemaps from regular images. Either six separate faces listed in a .cube file, one image with the
+// faces laid out in a cross, or an equirectangular panorama that gets projected onto the faces.
+// Faces go +x -x +y -y +z -z, and unlike every other texture they're stored top row first, that's
+// what opengl expects for cubemaps
+
+// a .cube file is json, the face images are relative to it
+type cubeFile struct {
+	Faces []string `json:"faces"` // right, left, top, bottom, front, back
+}
+
+// Decodes a .cube file and the six faces it points to
+func decodeCubeFile(name string, data []byte, descriptor TextureDescriptor) (*textureData, error) {
+	var cube cubeFile
+	if err := json.Unmarshal(data, &cube); err != nil {
+		return nil, err
+	}
+	if len(cube.Faces) != 6 {
+		return nil, fmt.Errorf("A cubemap needs 6 faces, %s has %d", name, len(cube.Faces))
+	}
+
+	// the faces get their mipmaps once they're put together
+	faceDescriptor := descriptor
+	faceDescriptor.MipFilter = "none"
+	faceDescriptor.Cubemap = ""
+
+	faces := make([]*textureData, 6)
+	for i, faceName := range cube.Faces {
+		imageBytes, err := loadAsset(path.Join(path.Dir(name), faceName))
+		if err != nil {
+			return nil, err
+		}
+		face, err := decodeTexture(imageBytes, faceDescriptor)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to decode face %s: %v", faceName, err)
+		}
+		if face.format.compressed() || face.faceCount() > 1 || len(face.pixels) == 0 {
+			return nil, fmt.Errorf("Face %s has to be a plain image", faceName)
+		}
+
+		flipped := *face
+		flipped.pixels = append([]uint8(nil), face.pixels...)
+		flipRows(flipped.pixels, flipped.rowBytes())
+		faces[i] = &flipped
+	}
+
+	return assembleCubemap(faces, descriptor)
+}
+
+// Turns a decoded image into a cubemap the way the descriptor says
+func imageToCubemap(data *textureData, descriptor TextureDescriptor) (*textureData, error) {
+	var faces []*textureData
+	var err error
+
+	switch descriptor.Cubemap {
+	case "cross":
+		faces, err = crossToFaces(data)
+	case "equirectangular":
+		size := descriptor.CubemapSize
+		if size == 0 {
+			size = int(data.width) / 4
+		}
+		if size == 0 {
+			return nil, fmt.Errorf("A %dx%d panorama is too small for a cubemap, it needs cubemapSize", data.width, data.height)
+		}
+		options := descriptor.mipmapOptions()
+		options.srgb = data.srgb
+		faces = equirectangularToFaces(data, size, options)
+	default:
+		err = fmt.Errorf("Unknown cubemap layout %q", descriptor.Cubemap)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return assembleCubemap(faces, descriptor)
+}
+
+// Puts six top row first faces together into one cubemap, with mipmaps if the descriptor wants them
+func assembleCubemap(faces []*textureData, descriptor TextureDescriptor) (*textureData, error) {
+	first := faces[0]
+	if first.width != first.height {
+		return nil, fmt.Errorf("Cubemap faces have to be square, got %dx%d", first.width, first.height)
+	}
+	for _, face := range faces[1:] {
+		if face.width != first.width || face.height != first.height || face.format != first.format {
+			return nil, fmt.Errorf("Cubemap faces have to be the same size and format, got %dx%d %v and %dx%d %v",
+				first.width, first.height, first.format, face.width, face.height, face.format)
+		}
+	}
+
+	cubemap := stackFaces(faces, descriptor)
+	for _, level := range append([]*textureData{cubemap}, cubemap.mipmaps...) {
+		level.cubemap = true
+	}
+	return cubemap, nil
+}
+
+// Where every face is in a cross, in face sized cells from the top left. The horizontal cross is the
+// unfolded cube with +z in the middle, the vertical one has -z hanging below -y, upside down
+var (
+	horizontalCross = [6][2]int{{2, 1}, {0, 1}, {1, 0}, {1, 2}, {1, 1}, {3, 1}}
+	verticalCross   = [6][2]int{{2, 1}, {0, 1}, {1, 0}, {1, 2}, {1, 1}, {1, 3}}
+)
+
+func crossToFaces(data *textureData) ([]*textureData, error) {
+	if data.format.compressed() || data.faceCount() > 1 {
+		return nil, fmt.Errorf("A cross has to be a plain image")
+	}
+
+	width, height := int(data.width), int(data.height)
+	var layout [6][2]int
+	var size int
+	switch {
+	case width*3 == height*4:
+		layout, size = horizontalCross, width/4
+	case width*4 == height*3:
+		layout, size = verticalCross, width/3
+	default:
+		return nil, fmt.Errorf("A cross has to be 4:3 or 3:4, got %dx%d", width, height)
+	}
+
+	pixelBytes := data.format.pixelBytes()
+	rowBytes := data.rowBytes()
+	faceRowBytes := size * pixelBytes
+
+	faces := make([]*textureData, 6)
+	for i, cell := range layout {
+		face := &textureData{width: int32(size), height: int32(size), format: data.format, srgb: data.srgb}
+		face.pixels = make([]uint8, size*faceRowBytes)
+
+		// rows of data are bottom row first, the face wants them top row first
+		for y := 0; y < size; y++ {
+			sourceRow := height - 1 - (cell[1]*size + y)
+			source := data.pixels[sourceRow*rowBytes+cell[0]*faceRowBytes:][:faceRowBytes]
+			copy(face.pixels[y*faceRowBytes:(y+1)*faceRowBytes], source)
+		}
+
+		if layout == verticalCross && i == 5 {
+			rotateHalfTurn(face.pixels, pixelBytes)
+		}
+		faces[i] = face
+	}
+
+	return faces, nil
+}
+
+// Rotates an image by 180 degrees in place, which is just reversing the order of the pixels
+func rotateHalfTurn(pixels []uint8, pixelBytes int) {
+	pixel := make([]uint8, pixelBytes)
+	for first, last := 0, len(pixels)-pixelBytes; first < last; first, last = first+pixelBytes, last-pixelBytes {
+		copy(pixel, pixels[first:first+pixelBytes])
+		copy(pixels[first:first+pixelBytes], pixels[last:last+pixelBytes])
+		copy(pixels[last:last+pixelBytes], pixel)
+	}
+}
+
+// Direction through a point on a face. s and t go from -1 to 1, t goes down the face like its rows do
+func cubeFaceDirection(face int, s float64, t float64) (float64, float64, float64) {
+	switch face {
+	case 0:
+		return 1, -t, -s
+	case 1:
+		return -1, -t, s
+	case 2:
+		return s, 1, t
+	case 3:
+		return s, -1, -t
+	case 4:
+		return s, -t, 1
+	}
+	return -s, -t, -1
+}
+
+// Projects a panorama onto the faces. The middle of the panorama ends up on -z, the top on +y
+func equirectangularToFaces(data *textureData, size int, options mipmapOptions) []*textureData {
+	panorama := textureToFloat(data, options)
+	channels := panorama.channels
+
+	faces := make([]*textureData, 6)
+	for i := range faces {
+		face := &floatImage{width: size, height: size, channels: channels, pixels: make([]float32, size*size*channels)}
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				s := 2*(float64(x)+0.5)/float64(size) - 1
+				t := 2*(float64(y)+0.5)/float64(size) - 1
+				dx, dy, dz := cubeFaceDirection(i, s, t)
+				length := math.Sqrt(dx*dx + dy*dy + dz*dz)
+
+				u := 0.5 + math.Atan2(dx, -dz)/(2*math.Pi)
+				v := math.Acos(dy/length) / math.Pi // 0 at the top
+
+				offset := (y*size + x) * channels
+				samplePanorama(panorama, u, v, face.pixels[offset:offset+channels])
+			}
+		}
+
+		faces[i] = floatToTexture(face, data.format, options)
+		faces[i].srgb = data.srgb
+	}
+
+	return faces
+}
+
+// Bilinear sample, u wraps around and v is clamped. The float image is bottom row first like the texture
+func samplePanorama(panorama *floatImage, u float64, v float64, result []float32) {
+	x := u*float64(panorama.width) - 0.5
+	y := (1-v)*float64(panorama.height) - 0.5
+	x0, y0 := math.Floor(x), math.Floor(y)
+	fx, fy := float32(x-x0), float32(y-y0)
+
+	wrap := func(x int) int {
+		x %= panorama.width
+		if x < 0 {
+			x += panorama.width
+		}
+		return x
+	}
+	left, right := wrap(int(x0)), wrap(int(x0)+1)
+	bottom, top := clampIndex(int(y0), panorama.height), clampIndex(int(y0)+1, panorama.height)
+
+	channels := panorama.channels
+	texel := func(x int, y int, c int) float32 {
+		return panorama.pixels[(y*panorama.width+x)*channels+c]
+	}
+	for c := range result {
+		lower := texel(left, bottom, c)*(1-fx) + texel(right, bottom, c)*fx
+		upper := texel(left, top, c)*(1-fx) + texel(right, top, c)*fx
+		result[c] = lower*(1-fy) + upper*fy
+	}
+}
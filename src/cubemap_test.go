@@ -0,0 +1,141 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+// which way each face faces, and where the top and the right side of the face are, as opengl has them
+var cubeFaceAxes = [6]struct{ center, up, right [3]float64 }{
+	{[3]float64{1, 0, 0}, [3]float64{0, 1, 0}, [3]float64{0, 0, -1}},
+	{[3]float64{-1, 0, 0}, [3]float64{0, 1, 0}, [3]float64{0, 0, 1}},
+	{[3]float64{0, 1, 0}, [3]float64{0, 0, -1}, [3]float64{1, 0, 0}},
+	{[3]float64{0, -1, 0}, [3]float64{0, 0, 1}, [3]float64{1, 0, 0}},
+	{[3]float64{0, 0, 1}, [3]float64{0, 1, 0}, [3]float64{1, 0, 0}},
+	{[3]float64{0, 0, -1}, [3]float64{0, 1, 0}, [3]float64{-1, 0, 0}},
+}
+
+func dot(a [3]float64, b [3]float64) float64 {
+	return a[0]*b[0] + a[1]*b[1] + a[2]*b[2]
+}
+
+func TestCrossToFaces(t *testing.T) {
+	for _, test := range []struct {
+		name          string
+		columns, rows int
+		layout        [6][2]int
+	}{{"horizontal", 4, 3, horizontalCross}, {"vertical", 3, 4, verticalCross}} {
+		// every cell is 40 * the face it should turn into, 1 more along the side that's the top of the
+		// face. That's the top of the cell, except for -z in the vertical cross which is upside down
+		const size = 3
+		width, height := test.columns*size, test.rows*size
+		data := &textureData{width: int32(width), height: int32(height), format: textureGray, pixels: make([]uint8, width*height)}
+		for face, cell := range test.layout {
+			topRow := 0
+			if test.name == "vertical" && face == 5 {
+				topRow = size - 1
+			}
+			for y := 0; y < size; y++ {
+				for x := 0; x < size; x++ {
+					value := uint8(40 * face)
+					if y == topRow {
+						value++
+					}
+					// bottom row first
+					row := height - 1 - (cell[1]*size + y)
+					data.pixels[row*width+cell[0]*size+x] = value
+				}
+			}
+		}
+
+		faces, err := crossToFaces(data)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		for i, face := range faces {
+			if face.width != size || face.height != size {
+				t.Errorf("%s: face %d is %dx%d", test.name, i, face.width, face.height)
+				continue
+			}
+			// faces are top row first
+			for y := 0; y < size; y++ {
+				want := uint8(40 * i)
+				if y == 0 {
+					want++
+				}
+				for x := 0; x < size; x++ {
+					if got := face.pixels[y*size+x]; got != want {
+						t.Errorf("%s: face %d texel %d, %d is %d, want %d", test.name, i, x, y, got, want)
+					}
+				}
+			}
+		}
+	}
+
+	if _, err := crossToFaces(&textureData{width: 4, height: 4, format: textureGray, pixels: make([]uint8, 16)}); err == nil {
+		t.Error("a square cross worked")
+	}
+}
+
+func TestEquirectangularToFaces(t *testing.T) {
+	// every texel of the panorama is the direction it's looking in, moved to 0-1 since the faces don't
+	// keep negative values. The middle is -z, u goes around towards +x and v down from +y
+	const width, height = 64, 32
+	values := make([]float32, 0, width*height*3)
+	for row := 0; row < height; row++ {
+		v := 1 - (float64(row)+0.5)/height // bottom row first
+		for x := 0; x < width; x++ {
+			u := (float64(x) + 0.5) / width
+			theta, phi := v*math.Pi, (u-0.5)*2*math.Pi
+			for _, value := range []float64{math.Sin(theta) * math.Sin(phi), math.Cos(theta), -math.Sin(theta) * math.Cos(phi)} {
+				values = append(values, float32(value/2+0.5))
+			}
+		}
+	}
+	panorama := &textureData{width: width, height: height, format: textureRGB32F, pixels: writeFloats(textureRGB32F, values)}
+
+	// an odd size has a texel right in the middle of the face
+	const size = 5
+	faces := equirectangularToFaces(panorama, size, mipmapOptions{})
+	for i, face := range faces {
+		if face.format != textureRGB32F || face.width != size || face.height != size {
+			t.Fatalf("face %d is a %dx%d %v", i, face.width, face.height, face.format)
+		}
+		pixels := readFloats(face)
+		direction := func(x int, y int) [3]float64 {
+			offset := (y*size + x) * 3
+			var direction [3]float64
+			for c := range direction {
+				direction[c] = float64(pixels[offset+c])*2 - 1
+			}
+			return direction
+		}
+
+		axes := cubeFaceAxes[i]
+		center := direction(size/2, size/2)
+		if dot(center, axes.center) < 0.99 {
+			t.Errorf("face %d: the center looks at %.2f, want %v", i, center, axes.center)
+		}
+		// faces are top row first, the first row is the top of the face and the last column its right side
+		if top := direction(size/2, 0); dot(top, axes.up) < 0.3 {
+			t.Errorf("face %d: the top looks at %.2f, up is %v", i, top, axes.up)
+		}
+		if right := direction(size-1, size/2); dot(right, axes.right) < 0.3 {
+			t.Errorf("face %d: the right side looks at %.2f, right is %v", i, right, axes.right)
+		}
+	}
+
+	// a quarter of the width is 0
+	descriptor := defaultTextureDescriptor()
+	descriptor.Cubemap = "equirectangular"
+	narrow := &textureData{width: 3, height: 2, format: textureRGB32F, pixels: make([]uint8, 3*2*12)}
+	if _, err := imageToCubemap(narrow, descriptor); err == nil || !strings.Contains(err.Error(), "too small") {
+		t.Errorf("a 3x2 panorama gave %v", err)
+	}
+	descriptor.CubemapSize = 2
+	descriptor.MipFilter = "none"
+	if cubemap, err := imageToCubemap(narrow, descriptor); err != nil || cubemap.width != 2 || !cubemap.cubemap {
+		t.Errorf("a 3x2 panorama with a cubemap size of 2 gave %v", err)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// DDS, both the legacy header (fourcc / bit masks) and the DX10 extension with dxgi formats. The legacy
+// header can't say whether a texture is srgb, those go by the descriptor
+
+var ddsMagic = []byte("DDS ")
+
+const (
+	ddsHeaderSize     = 124
+	ddsDX10HeaderSize = 20
+
+	ddsFlagMipmapCount = 0x20000
+
+	ddsPixelAlpha     = 0x1
+	ddsPixelFourCC    = 0x4
+	ddsPixelRGB       = 0x40
+	ddsPixelLuminance = 0x20000
+
+	ddsCapsCubemap = 0x200
+	ddsCapsVolume  = 0x200000
+
+	ddsMiscCubemap = 0x4
+)
+
+type dxgiFormatInfo struct {
+	format textureFormat
+	srgb   bool
+	bgr    bool
+}
+
+var dxgiFormats = map[uint32]dxgiFormatInfo{
+	6:  {textureRGB32F, false, false}, // R32G32B32_FLOAT
+	28: {textureRGBA, false, false},   // R8G8B8A8_UNORM
+	29: {textureRGBA, true, false},    // R8G8B8A8_UNORM_SRGB
+	61: {textureGray, false, false},   // R8_UNORM
+	71: {textureBC1, false, false},    // BC1_UNORM
+	72: {textureBC1, true, false},     // BC1_UNORM_SRGB
+	74: {textureBC2, false, false},    // BC2_UNORM
+	75: {textureBC2, true, false},     // BC2_UNORM_SRGB
+	77: {textureBC3, false, false},    // BC3_UNORM
+	78: {textureBC3, true, false},     // BC3_UNORM_SRGB
+	80: {textureBC4, false, false},    // BC4_UNORM
+	81: {textureBC4Signed, false, false},
+	83: {textureBC5, false, false}, // BC5_UNORM
+	84: {textureBC5Signed, false, false},
+	87: {textureRGBA, false, true},  // B8G8R8A8_UNORM
+	91: {textureRGBA, true, true},   // B8G8R8A8_UNORM_SRGB
+	95: {textureBC6H, false, false}, // BC6H_UF16
+	96: {textureBC6HSigned, false, false},
+	98: {textureBC7, false, false}, // BC7_UNORM
+	99: {textureBC7, true, false},  // BC7_UNORM_SRGB
+}
+
+var ddsFourCCs = map[string]textureFormat{
+	"DXT1": textureBC1,
+	"DXT2": textureBC2, // premultiplied, but the blocks are the same
+	"DXT3": textureBC2,
+	"DXT4": textureBC3,
+	"DXT5": textureBC3,
+	"ATI1": textureBC4,
+	"BC4U": textureBC4,
+	"BC4S": textureBC4Signed,
+	"ATI2": textureBC5,
+	"BC5U": textureBC5,
+	"BC5S": textureBC5Signed,
+}
+
+// DDS files are always top row first
+func decodeDDS(data []byte, fallbackSRGB bool) (*textureData, error) {
+	if len(data) < len(ddsMagic)+ddsHeaderSize {
+		return nil, fmt.Errorf("DDS file is too short")
+	}
+
+	header := data[len(ddsMagic):]
+	flags := binary.LittleEndian.Uint32(header[4:])
+	height := binary.LittleEndian.Uint32(header[8:])
+	width := binary.LittleEndian.Uint32(header[12:])
+	mipmapCount := binary.LittleEndian.Uint32(header[24:])
+	pixelFlags := binary.LittleEndian.Uint32(header[76:])
+	fourCC := string(header[80:84])
+	bitCount := binary.LittleEndian.Uint32(header[84:])
+	redMask := binary.LittleEndian.Uint32(header[88:])
+	alphaMask := binary.LittleEndian.Uint32(header[100:])
+	caps2 := binary.LittleEndian.Uint32(header[108:])
+
+	offset := len(ddsMagic) + ddsHeaderSize
+	info := dxgiFormatInfo{srgb: fallbackSRGB}
+	layers, cubemap := 0, caps2&ddsCapsCubemap != 0
+
+	switch {
+	case pixelFlags&ddsPixelFourCC != 0 && fourCC == "DX10":
+		if len(data) < offset+ddsDX10HeaderSize {
+			return nil, fmt.Errorf("DDS DX10 header is truncated")
+		}
+		extension := data[offset:]
+		offset += ddsDX10HeaderSize
+
+		dxgiFormat := binary.LittleEndian.Uint32(extension[0:])
+		dimension := binary.LittleEndian.Uint32(extension[4:])
+		miscFlags := binary.LittleEndian.Uint32(extension[8:])
+		arraySize := binary.LittleEndian.Uint32(extension[12:])
+
+		var ok bool
+		if info, ok = dxgiFormats[dxgiFormat]; !ok {
+			return nil, fmt.Errorf("DXGI format %d isn't supported", dxgiFormat)
+		}
+		// 3 is a 2D texture, 4 would be 3D
+		if dimension != 3 {
+			return nil, fmt.Errorf("Only 2D DDS textures are supported")
+		}
+		cubemap = miscFlags&ddsMiscCubemap != 0
+		if arraySize > 1 {
+			layers = int(arraySize)
+		}
+
+	case pixelFlags&ddsPixelFourCC != 0:
+		format, ok := ddsFourCCs[fourCC]
+		if !ok {
+			return nil, fmt.Errorf("DDS fourcc %q isn't supported", fourCC)
+		}
+		info.format = format
+		// only the color formats have srgb versions
+		info.srgb = fallbackSRGB && (format == textureBC1 || format == textureBC2 || format == textureBC3)
+
+	case pixelFlags&ddsPixelRGB != 0 && bitCount == 32:
+		info.format = textureRGBA
+		info.bgr = redMask == 0x00ff0000
+		if pixelFlags&ddsPixelAlpha == 0 || alphaMask == 0 {
+			return nil, fmt.Errorf("32 bit DDS files without alpha aren't supported")
+		}
+
+	case pixelFlags&ddsPixelRGB != 0 && bitCount == 24:
+		info.format = textureRGB
+		info.bgr = redMask == 0x00ff0000
+
+	case pixelFlags&ddsPixelLuminance != 0 && bitCount == 8:
+		info.format = textureGray
+
+	default:
+		return nil, fmt.Errorf("DDS pixel format isn't supported (flags %#x, %d bits)", pixelFlags, bitCount)
+	}
+
+	if caps2&ddsCapsVolume != 0 {
+		return nil, fmt.Errorf("3D textures aren't supported")
+	}
+	if width == 0 || height == 0 || width > maxTextureSize || height > maxTextureSize {
+		return nil, fmt.Errorf("DDS file is %dx%d", width, height)
+	}
+	if layers > maxTextureLayers {
+		return nil, fmt.Errorf("DDS file has %d layers, at most %d are supported", layers, maxTextureLayers)
+	}
+
+	levelCount := 1
+	if flags&ddsFlagMipmapCount != 0 && mipmapCount > 0 {
+		// the chain stops at 1x1, anything longer is a broken header and not worth allocating for
+		maxLevels := 1
+		for size := maxInt(int(width), int(height)); size > 1; size >>= 1 {
+			maxLevels++
+		}
+		if int(mipmapCount) > maxLevels {
+			return nil, fmt.Errorf("DDS file says it has %d mip levels, a %dx%d texture can only have %d", mipmapCount, width, height, maxLevels)
+		}
+		levelCount = int(mipmapCount)
+	}
+
+	faces := 1
+	if layers > 0 {
+		faces = layers
+	}
+	if cubemap {
+		faces *= 6
+	}
+
+	levels := make([]*textureData, levelCount)
+	for level := range levels {
+		levels[level] = &textureData{
+			width:   int32(maxInt(int(width)>>level, 1)),
+			height:  int32(maxInt(int(height)>>level, 1)),
+			format:  info.format,
+			srgb:    info.srgb,
+			layers:  layers,
+			cubemap: cubemap,
+		}
+	}
+
+	// dds stores every face with all of its levels, one face after the other. textureData wants every
+	// face of a level together instead
+	for face := 0; face < faces; face++ {
+		for _, level := range levels {
+			size := info.format.imageSize(int(level.width), int(level.height))
+			if offset+size > len(data) {
+				return nil, fmt.Errorf("DDS file is truncated")
+			}
+			level.pixels = append(level.pixels, data[offset:offset+size]...)
+			offset += size
+		}
+	}
+
+	if info.bgr {
+		for _, level := range levels {
+			swapRedBlue(level.pixels, info.format.channels())
+		}
+	}
+
+	levels[0].mipmaps = levels[1:]
+	return levels[0], nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+type ddsHeader struct {
+	width, height, mipmaps uint32
+	pixelFlags             uint32
+	fourCC                 string
+	bitCount, redMask      uint32
+	caps2                  uint32
+	dx10                   []uint32 // dxgi format, dimension, misc flags, array size
+}
+
+func (header ddsHeader) file(payload ...[]byte) []byte {
+	data := make([]byte, len(ddsMagic)+ddsHeaderSize)
+	copy(data, ddsMagic)
+	fields := data[len(ddsMagic):]
+	binary.LittleEndian.PutUint32(fields[0:], ddsHeaderSize)
+	if header.mipmaps > 0 {
+		binary.LittleEndian.PutUint32(fields[4:], ddsFlagMipmapCount)
+	}
+	binary.LittleEndian.PutUint32(fields[8:], header.height)
+	binary.LittleEndian.PutUint32(fields[12:], header.width)
+	binary.LittleEndian.PutUint32(fields[24:], header.mipmaps)
+	binary.LittleEndian.PutUint32(fields[72:], 32)
+	binary.LittleEndian.PutUint32(fields[76:], header.pixelFlags)
+	copy(fields[80:84], header.fourCC)
+	binary.LittleEndian.PutUint32(fields[84:], header.bitCount)
+	binary.LittleEndian.PutUint32(fields[88:], header.redMask)
+	binary.LittleEndian.PutUint32(fields[108:], header.caps2)
+
+	if header.dx10 != nil {
+		extension := make([]byte, ddsDX10HeaderSize)
+		for i, value := range header.dx10 {
+			binary.LittleEndian.PutUint32(extension[i*4:], value)
+		}
+		data = append(data, extension...)
+	}
+	for _, part := range payload {
+		data = append(data, part...)
+	}
+	return data
+}
+
+func checkLevels(t *testing.T, name string, texture *textureData, want [][]uint8) {
+	t.Helper()
+	levels := append([]*textureData{texture}, texture.mipmaps...)
+	if len(levels) != len(want) {
+		t.Fatalf("%s: got %d levels, want %d", name, len(levels), len(want))
+	}
+	for i, level := range levels {
+		if !bytes.Equal(level.pixels, want[i]) {
+			t.Errorf("%s: level %d (%dx%d) is %v, want %v", name, i, level.width, level.height, level.pixels, want[i])
+		}
+	}
+}
+
+func TestDecodeDDSLegacy(t *testing.T) {
+	// 8x8 DXT1 with its 4x4 mip, 4 blocks and then 1
+	level0 := bytes.Repeat([]byte{1, 2, 3, 4, 5, 6, 7, 8}, 4)
+	level1 := []byte{9, 10, 11, 12, 13, 14, 15, 16}
+	dxt1 := ddsHeader{width: 8, height: 8, mipmaps: 2, pixelFlags: ddsPixelFourCC, fourCC: "DXT1"}
+	texture, err := decodeDDS(dxt1.file(level0, level1), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if texture.format != textureBC1 || !texture.srgb || texture.width != 8 || texture.height != 8 || texture.cubemap || texture.layers != 0 {
+		t.Errorf("DXT1: got a %dx%d %v texture, srgb %v", texture.width, texture.height, texture.format, texture.srgb)
+	}
+	checkLevels(t, "DXT1", texture, [][]uint8{level0, level1})
+	if mip := texture.mipmaps[0]; mip.width != 4 || mip.height != 4 {
+		t.Errorf("DXT1: the mip is %dx%d", mip.width, mip.height)
+	}
+
+	// BC4 has no srgb version, the fallback doesn't apply
+	bc4 := ddsHeader{width: 4, height: 4, pixelFlags: ddsPixelFourCC, fourCC: "ATI1"}
+	if texture, err := decodeDDS(bc4.file(level1), true); err != nil || texture.format != textureBC4 || texture.srgb {
+		t.Errorf("ATI1: got %+v, %v", texture, err)
+	}
+
+	// 24 bit with red in the high byte is stored bgr
+	bgr := ddsHeader{width: 2, height: 1, pixelFlags: ddsPixelRGB, bitCount: 24, redMask: 0x00ff0000}
+	texture, err = decodeDDS(bgr.file([]byte{1, 2, 3, 4, 5, 6}), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if texture.format != textureRGB || texture.srgb {
+		t.Errorf("BGR: got a %v texture, srgb %v", texture.format, texture.srgb)
+	}
+	checkLevels(t, "BGR", texture, [][]uint8{{3, 2, 1, 6, 5, 4}})
+}
+
+func TestDecodeDDSDX10(t *testing.T) {
+	// an srgb rgba array with 2 layers of 1x1
+	array := ddsHeader{width: 1, height: 1, pixelFlags: ddsPixelFourCC, fourCC: "DX10", dx10: []uint32{29, 3, 0, 2}}
+	texture, err := decodeDDS(array.file([]byte{1, 2, 3, 4}, []byte{5, 6, 7, 8}), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if texture.format != textureRGBA || !texture.srgb || texture.layers != 2 || texture.cubemap {
+		t.Errorf("DX10 array: got a %v texture, srgb %v, %d layers", texture.format, texture.srgb, texture.layers)
+	}
+	checkLevels(t, "DX10 array", texture, [][]uint8{{1, 2, 3, 4, 5, 6, 7, 8}})
+
+	// bgra gets swapped and the dx10 header decides about srgb instead of the fallback
+	bgra := ddsHeader{width: 1, height: 1, pixelFlags: ddsPixelFourCC, fourCC: "DX10", dx10: []uint32{87, 3, 0, 1}}
+	texture, err = decodeDDS(bgra.file([]byte{1, 2, 3, 4}), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if texture.srgb || texture.layers != 0 {
+		t.Errorf("DX10 bgra: srgb %v, %d layers", texture.srgb, texture.layers)
+	}
+	checkLevels(t, "DX10 bgra", texture, [][]uint8{{3, 2, 1, 4}})
+}
+
+func TestDecodeDDSCubemapOrder(t *testing.T) {
+	// 2x2 gray with a 1x1 mip, each face stores both of its levels before the next face starts
+	var payload [][]byte
+	var level0, level1 []uint8
+	for face := uint8(0); face < 6; face++ {
+		payload = append(payload, bytes.Repeat([]byte{face * 10}, 4), []byte{face*10 + 1})
+		level0 = append(level0, bytes.Repeat([]byte{face * 10}, 4)...)
+		level1 = append(level1, face*10+1)
+	}
+
+	legacy := ddsHeader{width: 2, height: 2, mipmaps: 2, pixelFlags: ddsPixelLuminance, bitCount: 8, caps2: ddsCapsCubemap | 0xfc00}
+	dx10 := ddsHeader{width: 2, height: 2, mipmaps: 2, pixelFlags: ddsPixelFourCC, fourCC: "DX10", dx10: []uint32{61, 3, ddsMiscCubemap, 1}}
+	for name, header := range map[string]ddsHeader{"legacy": legacy, "DX10": dx10} {
+		texture, err := decodeDDS(header.file(payload...), false)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !texture.cubemap || texture.faceCount() != 6 {
+			t.Errorf("%s: cubemap %v with %d faces", name, texture.cubemap, texture.faceCount())
+		}
+		checkLevels(t, name, texture, [][]uint8{level0, level1})
+	}
+}
+
+func TestDecodeDDSErrors(t *testing.T) {
+	dxt1 := func(width, height, mipmaps uint32) ddsHeader {
+		return ddsHeader{width: width, height: height, mipmaps: mipmaps, pixelFlags: ddsPixelFourCC, fourCC: "DXT1"}
+	}
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"too short", []byte("DDS "), "too short"},
+		{"no size", dxt1(0, 4, 0).file(make([]byte, 8)), "0x4"},
+		// big enough to overflow imageSize with 32 bit ints
+		{"huge", dxt1(1<<31, 1<<31, 0).file(make([]byte, 8)), "2147483648x2147483648"},
+		{"too wide", dxt1(maxTextureSize*2, 4, 0).file(make([]byte, 8)), "65536x4"},
+		{"too many mips", dxt1(4, 4, 4).file(make([]byte, 24)), "mip levels"},
+		{"truncated", dxt1(8, 8, 0).file(make([]byte, 31)), "truncated"},
+		{"unknown fourcc", ddsHeader{width: 4, height: 4, pixelFlags: ddsPixelFourCC, fourCC: "ETC1"}.file(), "fourcc"},
+		{"truncated DX10 header", ddsHeader{width: 4, height: 4, pixelFlags: ddsPixelFourCC, fourCC: "DX10"}.file(), "DX10 header"},
+		{"3D DX10", ddsHeader{width: 1, height: 1, pixelFlags: ddsPixelFourCC, fourCC: "DX10", dx10: []uint32{28, 4, 0, 1}}.file(make([]byte, 4)), "2D"},
+		{"huge array", ddsHeader{width: 1, height: 1, pixelFlags: ddsPixelFourCC, fourCC: "DX10", dx10: []uint32{28, 3, 0, 1 << 30}}.file(make([]byte, 4)), "layers"},
+		{"32 bit without alpha", ddsHeader{width: 1, height: 1, pixelFlags: ddsPixelRGB, bitCount: 32}.file(make([]byte, 4)), "alpha"},
+	}
+
+	for _, test := range tests {
+		_, err := decodeDDS(test.data, false)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got %v, want an error about %q", test.name, err, test.want)
+		}
+	}
+}
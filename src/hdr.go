@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Radiance .hdr images (RGBE), the usual format for environment maps. Every pixel is an 8 bit mantissa per
+// channel with a shared exponent, they get decoded to linear float rgb. Only the standard orientations
+// (-Y h +X w and +Y h +X w) are supported, nothing writes the rotated ones anyway
+
+func isHDR(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("#?"))
+}
+
+// format is either textureRGB16F or textureRGB32F
+func decodeHDR(data []byte, format textureFormat) (*textureData, error) {
+	// the header is text lines up to an empty one, then comes the resolution line
+	var line string
+	for {
+		end := bytes.IndexByte(data, '\n')
+		if end < 0 {
+			return nil, fmt.Errorf("HDR header is truncated")
+		}
+		line, data = strings.TrimSpace(string(data[:end])), data[end+1:]
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(line, "FORMAT=") && line != "FORMAT=32-bit_rle_rgbe" {
+			return nil, fmt.Errorf("HDR format %s isn't supported", strings.TrimPrefix(line, "FORMAT="))
+		}
+	}
+
+	end := bytes.IndexByte(data, '\n')
+	if end < 0 {
+		return nil, fmt.Errorf("HDR resolution is missing")
+	}
+	line, data = string(data[:end]), data[end+1:]
+
+	var yDirection, xDirection string
+	var width, height int
+	if _, err := fmt.Sscanf(line, "%s %d %s %d", &yDirection, &height, &xDirection, &width); err != nil {
+		return nil, fmt.Errorf("Invalid HDR resolution %q", line)
+	}
+	if xDirection != "+X" || yDirection != "-Y" && yDirection != "+Y" {
+		return nil, fmt.Errorf("HDR orientation %q isn't supported", line)
+	}
+	if width <= 0 || height <= 0 || width > maxTextureSize || height > maxTextureSize {
+		return nil, fmt.Errorf("HDR image is %dx%d", width, height)
+	}
+	// every scanline takes at least 4 bytes, a pixel or the run length header
+	if height > len(data)/4 {
+		return nil, fmt.Errorf("HDR image is %dx%d, but there's only %d bytes of scanlines", width, height, len(data))
+	}
+
+	values := make([]float32, width*height*3)
+	scanline := make([]uint8, width*4)
+	for y := 0; y < height; y++ {
+		var err error
+		if data, err = readHDRScanline(data, scanline); err != nil {
+			return nil, fmt.Errorf("HDR scanline %d: %v", y, err)
+		}
+
+		// -Y is top row first, which is most of them
+		row := y
+		if yDirection == "-Y" {
+			row = height - 1 - y
+		}
+		rowValues := values[row*width*3 : (row+1)*width*3]
+		for x := 0; x < width; x++ {
+			rgbe := scanline[x*4 : x*4+4]
+			if rgbe[3] == 0 {
+				continue
+			}
+			scale := math.Ldexp(1, int(rgbe[3])-(128+8))
+			for c := 0; c < 3; c++ {
+				rowValues[x*3+c] = float32((float64(rgbe[c]) + 0.5) * scale)
+			}
+		}
+	}
+
+	return &textureData{
+		width:  int32(width),
+		height: int32(height),
+		format: format,
+		pixels: writeFloats(format, values),
+	}, nil
+}
+
+// Reads one scanline of rgbe pixels and returns what's left of the data. Newer files run length encode
+// every channel separately, older ones (and very narrow or wide images) have whole pixels with runs
+// marked by a 1, 1, 1 pixel
+func readHDRScanline(data []byte, scanline []uint8) ([]byte, error) {
+	width := len(scanline) / 4
+	if len(data) < 4 {
+		return nil, fmt.Errorf("truncated")
+	}
+
+	if width < 8 || width > 0x7fff || data[0] != 2 || data[1] != 2 || data[2]&0x80 != 0 {
+		return readOldHDRScanline(data, scanline)
+	}
+	if int(data[2])<<8|int(data[3]) != width {
+		return nil, fmt.Errorf("scanline width doesn't match the image width")
+	}
+	data = data[4:]
+
+	for c := 0; c < 4; c++ {
+		for x := 0; x < width; {
+			if len(data) < 2 {
+				return nil, fmt.Errorf("truncated")
+			}
+			count := int(data[0])
+			if count > 128 {
+				count -= 128
+				if x+count > width {
+					return nil, fmt.Errorf("run is longer than the scanline")
+				}
+				for ; count > 0; count-- {
+					scanline[x*4+c] = data[1]
+					x++
+				}
+				data = data[2:]
+				continue
+			}
+
+			if count == 0 || x+count > width || len(data) < 1+count {
+				return nil, fmt.Errorf("invalid literal run")
+			}
+			for i := 0; i < count; i++ {
+				scanline[x*4+c] = data[1+i]
+				x++
+			}
+			data = data[1+count:]
+		}
+	}
+
+	return data, nil
+}
+
+func readOldHDRScanline(data []byte, scanline []uint8) ([]byte, error) {
+	width := len(scanline) / 4
+	shift := 0
+	for x := 0; x < width; {
+		if len(data) < 4 {
+			return nil, fmt.Errorf("truncated")
+		}
+		pixel := data[:4]
+		data = data[4:]
+
+		if pixel[0] != 1 || pixel[1] != 1 || pixel[2] != 1 {
+			copy(scanline[x*4:x*4+4], pixel)
+			x++
+			shift = 0
+			continue
+		}
+
+		// repeats the previous pixel, consecutive runs make up higher bits of the count
+		if x == 0 {
+			return nil, fmt.Errorf("run without a pixel to repeat")
+		}
+		count := int(pixel[3]) << shift
+		if x+count > width {
+			return nil, fmt.Errorf("run is longer than the scanline")
+		}
+		for ; count > 0; count-- {
+			copy(scanline[x*4:x*4+4], scanline[(x-1)*4:x*4])
+			x++
+		}
+		shift += 8
+	}
+	return data, nil
+}
+
+// Float pixels to the bytes textureData keeps them as, little endian like every platform this runs on
+func writeFloats(format textureFormat, values []float32) []uint8 {
+	if format == textureRGB16F {
+		pixels := make([]uint8, len(values)*2)
+		for i, value := range values {
+			binary.LittleEndian.PutUint16(pixels[i*2:], floatToHalf(value))
+		}
+		return pixels
+	}
+
+	pixels := make([]uint8, len(values)*4)
+	for i, value := range values {
+		binary.LittleEndian.PutUint32(pixels[i*4:], math.Float32bits(value))
+	}
+	return pixels
+}
+
+func readFloats(data *textureData) []float32 {
+	if data.format == textureRGB16F {
+		values := make([]float32, len(data.pixels)/2)
+		for i := range values {
+			values[i] = halfToFloat(binary.LittleEndian.Uint16(data.pixels[i*2:]))
+		}
+		return values
+	}
+
+	values := make([]float32, len(data.pixels)/4)
+	for i := range values {
+		values[i] = math.Float32frombits(binary.LittleEndian.Uint32(data.pixels[i*4:]))
+	}
+	return values
+}
+
+// Rounds to the nearest half float. The sun in an environment map can easily be brighter than the
+// largest half float, finite values get clamped to that instead of turning into infinity
+func floatToHalf(value float32) uint16 {
+	bits := math.Float32bits(value)
+	sign := uint16(bits>>16) & 0x8000
+	exponent := int(bits>>23&0xff) - 127 + 15
+	mantissa := bits & 0x7fffff
+
+	switch {
+	case bits&0x7fffffff > 0x7f800000:
+		return sign | 0x7e00
+	case bits&0x7fffffff == 0x7f800000:
+		return sign | 0x7c00
+	case exponent >= 0x1f:
+		return sign | 0x7bff
+	case exponent <= 0:
+		// denormal, or too small for even that
+		if exponent < -10 {
+			return sign
+		}
+		mantissa |= 0x800000
+		shift := uint(14 - exponent)
+		half := mantissa >> shift
+		remainder, halfway := mantissa&(1<<shift-1), uint32(1)<<(shift-1)
+		if remainder > halfway || remainder == halfway && half&1 != 0 {
+			half++
+		}
+		return sign | uint16(half)
+	}
+
+	// rounding up can carry into the exponent, which is still the right result
+	half := uint32(exponent)<<10 | mantissa>>13
+	remainder := mantissa & 0x1fff
+	if remainder > 0x1000 || remainder == 0x1000 && half&1 != 0 {
+		half++
+	}
+	if half >= 0x7c00 {
+		half = 0x7bff
+	}
+	return sign | uint16(half)
+}
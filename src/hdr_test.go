@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func hdrFile(resolution string, scanlines ...[]byte) []byte {
+	data := []byte("#?RADIANCE\nFORMAT=32-bit_rle_rgbe\nEXPOSURE=1.0\n\n" + resolution + "\n")
+	for _, scanline := range scanlines {
+		data = append(data, scanline...)
+	}
+	return data
+}
+
+func decodeTestHDR(t *testing.T, name string, data []byte) []float32 {
+	t.Helper()
+	texture, err := decodeHDR(data, textureRGB32F)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	return readFloats(texture)
+}
+
+func equalFloats(a []float32, b []float32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// an exponent of 136 scales the mantissas by 1, so every channel decodes to its byte + 0.5
+func rgbe(r, g, b uint8) []byte {
+	return []byte{r, g, b, 136}
+}
+
+func TestDecodeHDRFlat(t *testing.T) {
+	top := append(rgbe(1, 2, 3), rgbe(4, 5, 6)...)
+	bottom := append(rgbe(7, 8, 9), 0, 0, 0, 0)
+	// bottom row first like textureData, a 0 exponent is black
+	want := []float32{7.5, 8.5, 9.5, 0, 0, 0, 1.5, 2.5, 3.5, 4.5, 5.5, 6.5}
+
+	if values := decodeTestHDR(t, "-Y", hdrFile("-Y 2 +X 2", top, bottom)); !equalFloats(values, want) {
+		t.Errorf("-Y: got %v, want %v", values, want)
+	}
+	// +Y is bottom row first already
+	if values := decodeTestHDR(t, "+Y", hdrFile("+Y 2 +X 2", bottom, top)); !equalFloats(values, want) {
+		t.Errorf("+Y: got %v, want %v", values, want)
+	}
+
+	// the old kind of run, 1, 1, 1 repeats the pixel before it
+	values := decodeTestHDR(t, "old run", hdrFile("-Y 1 +X 4", rgbe(1, 2, 3), []byte{1, 1, 1, 3}))
+	if want := []float32{1.5, 2.5, 3.5, 1.5, 2.5, 3.5, 1.5, 2.5, 3.5, 1.5, 2.5, 3.5}; !equalFloats(values, want) {
+		t.Errorf("old run: got %v, want %v", values, want)
+	}
+
+	// half floats get rounded, these are exact
+	texture, err := decodeHDR(hdrFile("-Y 1 +X 1", rgbe(1, 2, 3)), textureRGB16F)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values := readFloats(texture); texture.format != textureRGB16F || !equalFloats(values, []float32{1.5, 2.5, 3.5}) {
+		t.Errorf("half floats: got %v %v", texture.format, values)
+	}
+}
+
+func TestDecodeHDRRunLength(t *testing.T) {
+	// 10 pixels wide, every channel separately: red is a run of 10, green 4 literals and a run of 6,
+	// blue literals only and the exponent a run
+	scanline := []byte{2, 2, 0, 10}
+	scanline = append(scanline, 128+10, 20)
+	scanline = append(scanline, 4, 1, 2, 3, 4, 128+6, 9)
+	scanline = append(scanline, 10, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9)
+	scanline = append(scanline, 128+10, 136)
+
+	var want []float32
+	green := []uint8{1, 2, 3, 4, 9, 9, 9, 9, 9, 9}
+	for x := 0; x < 10; x++ {
+		want = append(want, 20.5, float32(green[x])+0.5, float32(x)+0.5)
+	}
+
+	values := decodeTestHDR(t, "run length", hdrFile("-Y 2 +X 10", scanline, scanline))
+	if !equalFloats(values, append(want, want...)) {
+		t.Errorf("run length: got %v, want %v twice", values, want)
+	}
+}
+
+func TestDecodeHDRErrors(t *testing.T) {
+	pixel := rgbe(1, 2, 3)
+	runLength := []byte{2, 2, 0, 8, 128 + 8, 1, 128 + 8, 2, 128 + 8, 3, 128 + 8, 136}
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"truncated header", []byte("#?RADIANCE\nFORMAT=32-bit_rle_rgbe\n"), "header is truncated"},
+		{"no resolution", []byte("#?RADIANCE\n\n"), "resolution is missing"},
+		{"xyz", []byte("#?RADIANCE\nFORMAT=32-bit_rle_xyze\n\n-Y 1 +X 1\n"), "32-bit_rle_xyze"},
+		{"rotated", hdrFile("+X 1 -Y 1", pixel), "orientation"},
+		{"empty", hdrFile("-Y 0 +X 1"), "1x0"},
+		{"huge", hdrFile("-Y 1000000 +X 1000000", pixel), "1000000x1000000"},
+		// a 1x1 file that says it has a lot of rows, each one needs at least 4 bytes
+		{"more rows than data", hdrFile(fmt.Sprintf("-Y %d +X %d", maxTextureSize, maxTextureSize), pixel), "only 4 bytes"},
+		{"truncated flat", hdrFile("-Y 2 +X 2", pixel, pixel, pixel, pixel[:3]), "scanline 1: truncated"},
+		{"truncated run length", hdrFile("-Y 1 +X 8", runLength[:9]), "scanline 0: truncated"},
+		{"empty literal run", hdrFile("-Y 1 +X 8", []byte{2, 2, 0, 8, 0, 1}, make([]byte, 16)), "invalid literal run"},
+		{"missing run length row", hdrFile("-Y 2 +X 8", runLength, runLength[:10]), "scanline 1: truncated"},
+		{"run past the scanline", hdrFile("-Y 1 +X 8", []byte{2, 2, 0, 8, 128 + 9, 1}, make([]byte, 16)), "longer than the scanline"},
+		{"wrong scanline width", hdrFile("-Y 1 +X 8", []byte{2, 2, 0, 9}, make([]byte, 32)), "doesn't match"},
+		{"old run first", hdrFile("-Y 1 +X 2", []byte{1, 1, 1, 2}, pixel), "without a pixel"},
+	}
+
+	for _, test := range tests {
+		_, err := decodeHDR(test.data, textureRGB32F)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got %v, want an error about %q", test.name, err, test.want)
+		}
+	}
+
+	if !isHDR(hdrFile("-Y 1 +X 1", pixel)) || isHDR(bytes.Repeat(pixel, 4)) {
+		t.Error("isHDR doesn't go by the #? magic")
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// Window icons. glfw takes a list of images and the OS picks whichever size fits best, so one big image
+// gets scaled down to the usual icon sizes here instead of leaving it to the OS (which usually does a bad
+// job of it). .ico files already have their own sizes and get used as they are
+
+var iconSizes = []int{16, 32, 48, 64, 128}
+
+func iconImages(imageBytes []byte) ([]image.Image, error) {
+	if isICO(imageBytes) {
+		return decodeICO(imageBytes)
+	}
+
+	decodedImage, _, err := image.Decode(bytes.NewReader(imageBytes))
+	if err != nil {
+		return nil, err
+	}
+	source := toRGBA(imageToTexture(decodedImage))
+	source.srgb = true
+
+	// lanczos keeps small icons sharp, filtering in linear space with alpha weighting keeps the edges
+	// from going dark
+	options := mipmapOptions{filter: mipFilterLanczos, srgb: true}
+
+	var images []image.Image
+	for _, size := range iconSizes {
+		// images that aren't square get fit into the square, centered
+		width, height := size, size
+		if source.width > source.height {
+			height = maxInt(1, int(int64(size)*int64(source.height)/int64(source.width)))
+		} else if source.height > source.width {
+			width = maxInt(1, int(int64(size)*int64(source.width)/int64(source.height)))
+		}
+
+		resized := textureToImage(resampleTexture(source, width, height, options.filter, options))
+		if width == size && height == size {
+			images = append(images, resized)
+			continue
+		}
+
+		icon := image.NewNRGBA(image.Rect(0, 0, size, size))
+		offset := image.Pt((size-width)/2, (size-height)/2)
+		for y := 0; y < height; y++ {
+			copy(icon.Pix[icon.PixOffset(offset.X, offset.Y+y):], resized.Pix[resized.PixOffset(0, y):resized.PixOffset(width, y)])
+		}
+		images = append(images, icon)
+	}
+
+	return images, nil
+}
+
+// reserved 0, then 1 for icons (2 would be cursors)
+func isICO(data []byte) bool {
+	return len(data) >= 6 && binary.LittleEndian.Uint16(data) == 0 && binary.LittleEndian.Uint16(data[2:]) == 1
+}
+
+// Every image in an .ico file. Newer ones store pngs, older ones a bmp without its file header, with the
+// height doubled because the color part is followed by a 1 bit transparency mask
+func decodeICO(data []byte) ([]image.Image, error) {
+	count := int(binary.LittleEndian.Uint16(data[4:]))
+	if count == 0 || len(data) < 6+count*16 {
+		return nil, fmt.Errorf("ICO directory is truncated")
+	}
+
+	var images []image.Image
+	for i := 0; i < count; i++ {
+		entry := data[6+i*16:]
+		size := binary.LittleEndian.Uint32(entry[8:])
+		offset := binary.LittleEndian.Uint32(entry[12:])
+		if uint64(offset)+uint64(size) > uint64(len(data)) {
+			return nil, fmt.Errorf("ICO image %d is truncated", i)
+		}
+		imageData := data[offset : offset+size]
+
+		var decoded image.Image
+		var err error
+		if bytes.HasPrefix(imageData, []byte("\x89PNG")) {
+			decoded, err = png.Decode(bytes.NewReader(imageData))
+		} else {
+			decoded, err = decodeICOBitmap(imageData)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ICO image %d: %v", i, err)
+		}
+		images = append(images, decoded)
+	}
+
+	return images, nil
+}
+
+func decodeICOBitmap(data []byte) (*image.NRGBA, error) {
+	if len(data) < 40 {
+		return nil, fmt.Errorf("bitmap header is truncated")
+	}
+	headerSize := int(binary.LittleEndian.Uint32(data))
+	width := int(int32(binary.LittleEndian.Uint32(data[4:])))
+	height := int(int32(binary.LittleEndian.Uint32(data[8:]))) / 2
+	bitCount := int(binary.LittleEndian.Uint16(data[14:]))
+	compression := binary.LittleEndian.Uint32(data[16:])
+	paletteSize := int(binary.LittleEndian.Uint32(data[32:]))
+
+	if compression != 0 {
+		return nil, fmt.Errorf("compressed bitmaps aren't supported")
+	}
+	if width <= 0 || height <= 0 || width > 1024 || height > 1024 || headerSize < 40 || headerSize > len(data) {
+		return nil, fmt.Errorf("invalid bitmap header")
+	}
+
+	var palette [][4]uint8
+	position := headerSize
+	if bitCount <= 8 {
+		if paletteSize == 0 {
+			paletteSize = 1 << bitCount
+		}
+		if position+paletteSize*4 > len(data) {
+			return nil, fmt.Errorf("bitmap palette is truncated")
+		}
+		for i := 0; i < paletteSize; i++ {
+			blue, green, red := data[position+i*4], data[position+i*4+1], data[position+i*4+2]
+			palette = append(palette, [4]uint8{red, green, blue, 0xff})
+		}
+		position += paletteSize * 4
+	}
+
+	switch bitCount {
+	case 1, 4, 8, 24, 32:
+	default:
+		return nil, fmt.Errorf("%d bit bitmaps aren't supported", bitCount)
+	}
+
+	// rows are padded to 4 bytes and stored bottom row first, the mask comes after the colors
+	rowBytes := (width*bitCount + 31) / 32 * 4
+	maskRowBytes := (width + 31) / 32 * 4
+	colors := data[position:]
+	if len(colors) < rowBytes*height {
+		return nil, fmt.Errorf("bitmap pixels are truncated")
+	}
+	var mask []byte
+	if len(colors) >= rowBytes*height+maskRowBytes*height {
+		mask = colors[rowBytes*height:]
+	}
+
+	result := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		row := colors[(height-1-y)*rowBytes:]
+		for x := 0; x < width; x++ {
+			var pixel color.NRGBA
+			switch bitCount {
+			case 32:
+				pixel = color.NRGBA{row[x*4+2], row[x*4+1], row[x*4], row[x*4+3]}
+			case 24:
+				pixel = color.NRGBA{row[x*3+2], row[x*3+1], row[x*3], 0xff}
+			default:
+				bit := x * bitCount
+				index := int(row[bit/8]>>(8-bitCount-bit%8)) & (1<<bitCount - 1)
+				if index >= len(palette) {
+					return nil, fmt.Errorf("palette index %d is out of range", index)
+				}
+				entry := palette[index]
+				pixel = color.NRGBA{entry[0], entry[1], entry[2], entry[3]}
+			}
+
+			// 32 bit bitmaps have real alpha, everything else only has the mask
+			if bitCount != 32 && mask != nil {
+				maskRow := mask[(height-1-y)*maskRowBytes:]
+				if maskRow[x/8]>>(7-x%8)&1 != 0 {
+					pixel.A = 0
+				}
+			}
+			result.SetNRGBA(x, y, pixel)
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+// An .ico with a directory entry for every image, one after the other behind the directory
+func icoFile(images ...[]byte) []byte {
+	data := make([]byte, 6+16*len(images))
+	binary.LittleEndian.PutUint16(data[2:], 1)
+	binary.LittleEndian.PutUint16(data[4:], uint16(len(images)))
+	for i, imageData := range images {
+		entry := data[6+i*16:]
+		binary.LittleEndian.PutUint32(entry[8:], uint32(len(imageData)))
+		binary.LittleEndian.PutUint32(entry[12:], uint32(len(data)))
+		data = append(data, imageData...)
+	}
+	return data
+}
+
+// A bitmap the way .ico files have them, without the file header and twice as high for the mask
+func icoBitmap(width int, height int, bitCount int, palette []uint8, colors []uint8, mask []uint8) []byte {
+	header := make([]byte, 40)
+	binary.LittleEndian.PutUint32(header[0:], 40)
+	binary.LittleEndian.PutUint32(header[4:], uint32(width))
+	binary.LittleEndian.PutUint32(header[8:], uint32(height*2))
+	binary.LittleEndian.PutUint16(header[12:], 1)
+	binary.LittleEndian.PutUint16(header[14:], uint16(bitCount))
+	binary.LittleEndian.PutUint32(header[32:], uint32(len(palette)/4))
+	data := append(header, palette...)
+	data = append(data, colors...)
+	return append(data, mask...)
+}
+
+func pngBytes(t *testing.T, source image.Image) []byte {
+	t.Helper()
+	var buffer bytes.Buffer
+	if err := png.Encode(&buffer, source); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func TestDecodeICO(t *testing.T) {
+	embedded := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	embedded.SetNRGBA(2, 1, color.NRGBA{10, 20, 30, 40})
+
+	// 2x2, bgra and bottom row first. The mask says the bottom left pixel is transparent, but 32 bit
+	// bitmaps have real alpha and the mask doesn't count
+	bgra := []uint8{
+		1, 2, 3, 0xff, 4, 5, 6, 0x80,
+		7, 8, 9, 0xff, 10, 11, 12, 0,
+	}
+	mask := []uint8{0x80, 0, 0, 0, 0, 0, 0, 0}
+	// 2x2 with a red and green palette, rows padded to 4 bytes. The mask hides the bottom left pixel
+	palette := []uint8{0, 0, 0xff, 0, 0, 0xff, 0, 0}
+	indices := []uint8{0x40, 0, 0, 0, 0x80, 0, 0, 0}
+
+	icons, err := decodeICO(icoFile(pngBytes(t, embedded), icoBitmap(2, 2, 32, nil, bgra, mask), icoBitmap(2, 2, 1, palette, indices, mask)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(icons) != 3 {
+		t.Fatalf("got %d images, want 3", len(icons))
+	}
+
+	if icons[0].Bounds() != embedded.Bounds() || color.NRGBAModel.Convert(icons[0].At(2, 1)) != embedded.At(2, 1) {
+		t.Errorf("png: got %v with %v at 2, 1", icons[0].Bounds(), icons[0].At(2, 1))
+	}
+
+	tests := []struct {
+		name   string
+		image  image.Image
+		pixels [4]color.NRGBA // top left, top right, bottom left, bottom right
+	}{
+		{"32 bit", icons[1], [4]color.NRGBA{{9, 8, 7, 0xff}, {12, 11, 10, 0}, {3, 2, 1, 0xff}, {6, 5, 4, 0x80}}},
+		{"1 bit", icons[2], [4]color.NRGBA{{0, 0xff, 0, 0xff}, {0xff, 0, 0, 0xff}, {0xff, 0, 0, 0}, {0, 0xff, 0, 0xff}}},
+	}
+	for _, test := range tests {
+		if test.image.Bounds() != image.Rect(0, 0, 2, 2) {
+			t.Errorf("%s: the bitmap is %v", test.name, test.image.Bounds())
+			continue
+		}
+		for i, want := range test.pixels {
+			if got := test.image.At(i%2, i/2); got != want {
+				t.Errorf("%s: pixel %d, %d is %v, want %v", test.name, i%2, i/2, got, want)
+			}
+		}
+	}
+}
+
+func TestDecodeICOErrors(t *testing.T) {
+	bitmap := icoBitmap(1, 1, 32, nil, []uint8{1, 2, 3, 4}, nil)
+	pastTheEnd := icoFile(bitmap)
+	binary.LittleEndian.PutUint32(pastTheEnd[6+12:], 1000)
+	// the offset and the size add up to more than 32 bits
+	wrapping := icoFile(bitmap)
+	binary.LittleEndian.PutUint32(wrapping[6+8:], 0xffffffff)
+	missingEntry := icoFile(bitmap)
+	binary.LittleEndian.PutUint16(missingEntry[4:], 2)
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"no images", icoFile(), "directory is truncated"},
+		{"missing directory entry", missingEntry[:6+16], "directory is truncated"},
+		{"image past the end", pastTheEnd, "image 0 is truncated"},
+		{"wrapping size", wrapping, "image 0 is truncated"},
+		{"short bitmap header", icoFile(bitmap[:20]), "header is truncated"},
+		{"huge bitmap", icoFile(icoBitmap(5000, 1, 32, nil, nil, nil)), "invalid bitmap header"},
+		{"16 bit", icoFile(icoBitmap(1, 1, 16, nil, make([]uint8, 4), nil)), "16 bit"},
+		{"missing pixels", icoFile(icoBitmap(2, 2, 24, nil, make([]uint8, 8), nil)), "pixels are truncated"},
+		{"bad png", icoFile([]byte("\x89PNG broken")), "ICO image 0"},
+	}
+
+	for _, test := range tests {
+		_, err := decodeICO(test.data)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got %v, want an error about %q", test.name, err, test.want)
+		}
+	}
+}
+
+func TestIconImages(t *testing.T) {
+	// twice as wide as it's high, it gets a transparent band above and below
+	wide := image.NewNRGBA(image.Rect(0, 0, 200, 100))
+	for i := 0; i < len(wide.Pix); i += 4 {
+		copy(wide.Pix[i:], []uint8{0xff, 0, 0, 0xff})
+	}
+
+	icons, err := iconImages(pngBytes(t, wide))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(icons) != len(iconSizes) {
+		t.Fatalf("got %d icons, want %d", len(icons), len(iconSizes))
+	}
+	for i, icon := range icons {
+		size := iconSizes[i]
+		if icon.Bounds() != image.Rect(0, 0, size, size) {
+			t.Errorf("icon %d is %v, want %dx%d", i, icon.Bounds(), size, size)
+			continue
+		}
+
+		// the image is size/2 high in the middle
+		for _, y := range []int{0, size/4 - 1, size / 4, size/2 - 1, size/2 + size/4 - 1, size/2 + size/4, size - 1} {
+			inside := y >= size/4 && y < size/4+size/2
+			pixel := color.NRGBAModel.Convert(icon.At(size/2, y)).(color.NRGBA)
+			if inside && (pixel.A != 0xff || pixel.R != 0xff) || !inside && pixel.A != 0 {
+				t.Errorf("%dx%d: row %d is %v, inside the image %v", size, size, y, pixel, inside)
+			}
+		}
+	}
+
+	// .ico files are used the way they are
+	embedded := image.NewNRGBA(image.Rect(0, 0, 7, 7))
+	icons, err = iconImages(icoFile(pngBytes(t, embedded)))
+	if err != nil || len(icons) != 1 || icons[0].Bounds() != embedded.Bounds() {
+		t.Errorf("an .ico with one 7x7 image gave %d images, %v", len(icons), err)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// KTX2 (https://registry.khronos.org/KTX/specs/2.0/ktxspec.v2.html), the formats are vulkan ones. Only
+// formats textureData can hold are supported and no supercompression (basis, zstd)
+
+var ktx2Identifier = []byte{0xab, 'K', 'T', 'X', ' ', '2', '0', 0xbb, '\r', '\n', 0x1a, '\n'}
+
+type vkFormatInfo struct {
+	format textureFormat
+	srgb   bool
+	bgr    bool // blue and red are swapped
+}
+
+var vkFormats = map[uint32]vkFormatInfo{
+	9:   {textureGray, false, false},   // R8_UNORM
+	15:  {textureGray, true, false},    // R8_SRGB
+	23:  {textureRGB, false, false},    // R8G8B8_UNORM
+	29:  {textureRGB, true, false},     // R8G8B8_SRGB
+	37:  {textureRGBA, false, false},   // R8G8B8A8_UNORM
+	43:  {textureRGBA, true, false},    // R8G8B8A8_SRGB
+	44:  {textureRGBA, false, true},    // B8G8R8A8_UNORM
+	50:  {textureRGBA, true, true},     // B8G8R8A8_SRGB
+	90:  {textureRGB16F, false, false}, // R16G16B16_SFLOAT
+	106: {textureRGB32F, false, false}, // R32G32B32_SFLOAT
+	131: {textureBC1, false, false},    // BC1_RGB_UNORM_BLOCK
+	132: {textureBC1, true, false},     // BC1_RGB_SRGB_BLOCK
+	133: {textureBC1, false, false},    // BC1_RGBA_UNORM_BLOCK
+	134: {textureBC1, true, false},     // BC1_RGBA_SRGB_BLOCK
+	135: {textureBC2, false, false},    // BC2_UNORM_BLOCK
+	136: {textureBC2, true, false},     // BC2_SRGB_BLOCK
+	137: {textureBC3, false, false},    // BC3_UNORM_BLOCK
+	138: {textureBC3, true, false},     // BC3_SRGB_BLOCK
+	139: {textureBC4, false, false},    // BC4_UNORM_BLOCK
+	140: {textureBC4Signed, false, false},
+	141: {textureBC5, false, false}, // BC5_UNORM_BLOCK
+	142: {textureBC5Signed, false, false},
+	143: {textureBC6H, false, false}, // BC6H_UFLOAT_BLOCK
+	144: {textureBC6HSigned, false, false},
+	145: {textureBC7, false, false}, // BC7_UNORM_BLOCK
+	146: {textureBC7, true, false},  // BC7_SRGB_BLOCK
+}
+
+// Returns the texture and whether its rows are stored top row first (the default for ktx2). Unlike
+// images the format says whether the texture is srgb, the descriptor doesn't matter for that
+func decodeKTX2(data []byte) (*textureData, bool, error) {
+	const headerSize = 80
+	if !bytes.HasPrefix(data, ktx2Identifier) {
+		return nil, false, fmt.Errorf("not a KTX2 file")
+	}
+	if len(data) < headerSize {
+		return nil, false, fmt.Errorf("KTX2 file is too short")
+	}
+
+	header := data[len(ktx2Identifier):]
+	vkFormat := binary.LittleEndian.Uint32(header[0:])
+	width := binary.LittleEndian.Uint32(header[8:])
+	height := binary.LittleEndian.Uint32(header[12:])
+	depth := binary.LittleEndian.Uint32(header[16:])
+	layerCount := binary.LittleEndian.Uint32(header[20:])
+	faceCount := binary.LittleEndian.Uint32(header[24:])
+	levelCount := binary.LittleEndian.Uint32(header[28:])
+	supercompression := binary.LittleEndian.Uint32(header[32:])
+	keyValueOffset := binary.LittleEndian.Uint32(header[44:])
+	keyValueLength := binary.LittleEndian.Uint32(header[48:])
+
+	info, ok := vkFormats[vkFormat]
+	switch {
+	case !ok:
+		return nil, false, fmt.Errorf("KTX2 format %d isn't supported", vkFormat)
+	case supercompression != 0:
+		return nil, false, fmt.Errorf("KTX2 supercompression %d isn't supported", supercompression)
+	case depth > 1:
+		return nil, false, fmt.Errorf("3D textures aren't supported")
+	case faceCount != 1 && faceCount != 6:
+		return nil, false, fmt.Errorf("KTX2 file has %d faces", faceCount)
+	case width == 0 || height == 0 || width > maxTextureSize || height > maxTextureSize:
+		return nil, false, fmt.Errorf("KTX2 file is %dx%d", width, height)
+	case layerCount > maxTextureLayers:
+		return nil, false, fmt.Errorf("KTX2 file has %d layers, at most %d are supported", layerCount, maxTextureLayers)
+	}
+
+	// 0 levels means the mipmaps should be generated when loading
+	if levelCount == 0 {
+		levelCount = 1
+	}
+
+	faces := int(faceCount)
+	if layerCount > 0 {
+		faces *= int(layerCount)
+	}
+
+	// the level index comes right after the header
+	if len(data) < headerSize+int(levelCount)*24 {
+		return nil, false, fmt.Errorf("KTX2 level index is truncated")
+	}
+
+	var levels []*textureData
+	for level := 0; level < int(levelCount); level++ {
+		entry := data[headerSize+level*24:]
+		offset := binary.LittleEndian.Uint64(entry[0:])
+		length := binary.LittleEndian.Uint64(entry[8:])
+
+		levelWidth, levelHeight := maxInt(int(width)>>level, 1), maxInt(int(height)>>level, 1)
+		expected := uint64(info.format.imageSize(levelWidth, levelHeight) * faces)
+		if length != expected || offset > uint64(len(data)) || length > uint64(len(data))-offset {
+			return nil, false, fmt.Errorf("KTX2 level %d should be %d bytes, it's %d at %d in a %d byte file", level, expected, length, offset, len(data))
+		}
+
+		pixels := append([]uint8(nil), data[offset:offset+length]...)
+		if info.bgr {
+			swapRedBlue(pixels, info.format.channels())
+		}
+
+		levels = append(levels, &textureData{
+			width:   int32(levelWidth),
+			height:  int32(levelHeight),
+			format:  info.format,
+			pixels:  pixels,
+			srgb:    info.srgb,
+			layers:  int(layerCount),
+			cubemap: faceCount == 6,
+		})
+	}
+
+	levels[0].mipmaps = levels[1:]
+
+	// KTXorientation is "rd" by default, right and down. "ru" means the rows are bottom row first already
+	topRowFirst := true
+	if keyValueOffset != 0 && uint64(keyValueOffset)+uint64(keyValueLength) <= uint64(len(data)) {
+		orientation := ktx2KeyValue(data[keyValueOffset:keyValueOffset+keyValueLength], "KTXorientation")
+		if len(orientation) >= 2 && orientation[1] == 'u' {
+			topRowFirst = false
+		}
+	}
+
+	return levels[0], topRowFirst, nil
+}
+
+// Looks up a value in the key/value data, every entry is a length, then key\0value, then padding to 4 bytes
+func ktx2KeyValue(data []byte, key string) string {
+	for len(data) >= 4 {
+		length := binary.LittleEndian.Uint32(data)
+		if uint64(length)+4 > uint64(len(data)) {
+			return ""
+		}
+
+		entry := data[4 : 4+length]
+		if name, value, ok := bytes.Cut(entry, []byte{0}); ok && string(name) == key {
+			return string(bytes.TrimRight(value, "\x00"))
+		}
+
+		next := 4 + (int(length)+3)&^3
+		if next > len(data) {
+			return ""
+		}
+		data = data[next:]
+	}
+	return ""
+}
+
+func swapRedBlue(pixels []uint8, channels int) {
+	for i := 0; i+2 < len(pixels); i += channels {
+		pixels[i], pixels[i+2] = pixels[i+2], pixels[i]
+	}
+}
+
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
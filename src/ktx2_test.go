@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+type ktx2Header struct {
+	vkFormat, width, height uint32
+	layers, faces           uint32
+	orientation             string // KTXorientation, left out when empty
+}
+
+// The level index points at the levels, which are stored smallest first like the spec wants
+func (header ktx2Header) file(levels ...[]byte) []byte {
+	const headerSize = 80
+	data := make([]byte, headerSize+24*len(levels))
+	copy(data, ktx2Identifier)
+	fields := data[len(ktx2Identifier):]
+	binary.LittleEndian.PutUint32(fields[0:], header.vkFormat)
+	binary.LittleEndian.PutUint32(fields[8:], header.width)
+	binary.LittleEndian.PutUint32(fields[12:], header.height)
+	binary.LittleEndian.PutUint32(fields[20:], header.layers)
+	binary.LittleEndian.PutUint32(fields[24:], header.faces)
+	binary.LittleEndian.PutUint32(fields[28:], uint32(len(levels)))
+
+	if header.orientation != "" {
+		// another key first, the lookup has to skip it and its padding
+		keyValues := ktx2Entry("KTXwriter", "test") + ktx2Entry("KTXorientation", header.orientation)
+		binary.LittleEndian.PutUint32(fields[44:], uint32(len(data)))
+		binary.LittleEndian.PutUint32(fields[48:], uint32(len(keyValues)))
+		data = append(data, keyValues...)
+	}
+
+	for level := len(levels) - 1; level >= 0; level-- {
+		entry := data[headerSize+level*24:]
+		binary.LittleEndian.PutUint64(entry[0:], uint64(len(data)))
+		binary.LittleEndian.PutUint64(entry[8:], uint64(len(levels[level])))
+		binary.LittleEndian.PutUint64(entry[16:], uint64(len(levels[level])))
+		data = append(data, levels[level]...)
+	}
+	return data
+}
+
+func ktx2Entry(key string, value string) string {
+	entry := key + "\x00" + value + "\x00"
+	length := make([]byte, 4)
+	binary.LittleEndian.PutUint32(length, uint32(len(entry)))
+	return string(length) + entry + strings.Repeat("\x00", (4-len(entry)%4)%4)
+}
+
+func TestDecodeKTX2Levels(t *testing.T) {
+	level0 := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	level1 := []byte{17, 18, 19, 20}
+	rgba := ktx2Header{vkFormat: 43, width: 2, height: 2, faces: 1}
+	texture, topRowFirst, err := decodeKTX2(rgba.file(level0, level1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if texture.format != textureRGBA || !texture.srgb || texture.width != 2 || texture.height != 2 || !topRowFirst {
+		t.Errorf("rgba: got a %dx%d %v texture, srgb %v, top row first %v", texture.width, texture.height, texture.format, texture.srgb, topRowFirst)
+	}
+	checkLevels(t, "rgba", texture, [][]uint8{level0, level1})
+
+	// bgra gets swapped
+	bgra := ktx2Header{vkFormat: 44, width: 1, height: 1, faces: 1}
+	texture, _, err = decodeKTX2(bgra.file([]byte{1, 2, 3, 4}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkLevels(t, "bgra", texture, [][]uint8{{3, 2, 1, 4}})
+
+	// a cubemap array, every face of every layer is in the one level
+	cubes := ktx2Header{vkFormat: 9, width: 1, height: 1, layers: 2, faces: 6}
+	faces := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+	texture, _, err = decodeKTX2(cubes.file(faces))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !texture.cubemap || texture.layers != 2 || texture.faceCount() != 12 {
+		t.Errorf("cubemap array: cubemap %v, %d layers, %d faces", texture.cubemap, texture.layers, texture.faceCount())
+	}
+	checkLevels(t, "cubemap array", texture, [][]uint8{faces})
+}
+
+func TestDecodeKTX2Orientation(t *testing.T) {
+	for _, test := range []struct {
+		orientation string
+		topRowFirst bool
+	}{{"", true}, {"rd", true}, {"ru", false}, {"r", true}} {
+		header := ktx2Header{vkFormat: 9, width: 1, height: 1, faces: 1, orientation: test.orientation}
+		_, topRowFirst, err := decodeKTX2(header.file([]byte{1}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if topRowFirst != test.topRowFirst {
+			t.Errorf("KTXorientation %q: top row first is %v", test.orientation, topRowFirst)
+		}
+	}
+}
+
+func TestDecodeKTX2Errors(t *testing.T) {
+	gray := func(width, height uint32) ktx2Header {
+		return ktx2Header{vkFormat: 9, width: width, height: height, faces: 1}
+	}
+	wrongSize := gray(2, 2).file([]byte{1, 2, 3})
+	indexed := gray(1, 1).file([]byte{1})
+	// the level index says there are 3 levels, but there's only room for 1 entry
+	binary.LittleEndian.PutUint32(indexed[len(ktx2Identifier)+28:], 3)
+	pastTheEnd := gray(1, 1).file([]byte{1})
+	binary.LittleEndian.PutUint64(pastTheEnd[80:], 1<<40)
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"not ktx2", append([]byte("KTX 11"), make([]byte, 100)...), "not a KTX2"},
+		{"too short", ktx2Identifier, "too short"},
+		{"unknown format", ktx2Header{vkFormat: 1000, width: 1, height: 1, faces: 1}.file([]byte{1}), "format 1000"},
+		{"no size", gray(0, 1).file(), "0x1"},
+		{"huge", gray(1<<31, 1<<31).file([]byte{1}), "2147483648x2147483648"},
+		{"too wide", gray(maxTextureSize*2, 1).file([]byte{1}), "65536x1"},
+		{"huge array", ktx2Header{vkFormat: 9, width: 1, height: 1, layers: 1 << 30, faces: 1}.file([]byte{1}), "layers"},
+		{"5 faces", ktx2Header{vkFormat: 9, width: 1, height: 1, faces: 5}.file([]byte{1}), "5 faces"},
+		{"level of the wrong size", wrongSize, "level 0"},
+		{"level past the end", pastTheEnd, "level 0"},
+		{"truncated level index", indexed, "level index"},
+	}
+
+	for _, test := range tests {
+		_, _, err := decodeKTX2(test.data)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got %v, want an error about %q", test.name, err, test.want)
+		}
+	}
+}
@@ -1,94 +1,175 @@
 package main
 
 import (
-	"bytes"
+	"flag"
 	"fmt"
 	"image"
 
-	"image/draw"
 	_ "image/draw"
 	_ "image/gif"
 	_ "image/jpeg"
 	_ "image/png"
 	"main/src/util"
 	"math"
+	"os"
+	"path"
 	"runtime"
 	"strings"
+	"time"
 	"unsafe"
 
 	gl "github.com/go-gl/gl/v4.6-core/gl"
 	glfw "github.com/go-gl/glfw/v3.3/glfw"
 
+	// more image formats for textures, tga is in tga.go
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+
 	// not actually glm but I'll call it glm anyway
 	glm "github.com/go-gl/mathgl/mgl32"
 )
 
+// things you can run instead of the viewer, like `build manifest check`
+var commands = map[string]func(args []string) int{
+	"manifest": runManifestCommand,
+	"pack":     runPackCommand,
+	"atlas":    runAtlasCommand,
+	"shaders":  runShadersCommand,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			os.Exit(command(os.Args[2:]))
+		}
+	}
+
 	runtime.LockOSThread() // This is because GLFW has to run on the same thread it was initialized on
 
+	var overrideDirectories, archives []string
+	var shaderDefines [][2]string
+	flag.Func("assets", "directory that overrides the embedded assets, can be given more than once (earlier ones win). changes to textures and meshes get picked up while running", func(directory string) error {
+		overrideDirectories = append(overrideDirectories, directory)
+		return nil
+	})
+	flag.Func("archive", "zip or .pack archive that overrides the embedded assets, can be given more than once (earlier ones win)", func(archive string) error {
+		archives = append(archives, archive)
+		return nil
+	})
+	flag.Func("mip-filter", "filter used for building mipmaps: box, kaiser (default) or lanczos", func(name string) error {
+		filters := map[string]mipFilter{"box": mipFilterBox, "kaiser": mipFilterKaiser, "lanczos": mipFilterLanczos}
+		filter, ok := filters[name]
+		if !ok {
+			return fmt.Errorf("unknown filter %q", name)
+		}
+		defaultMipmapOptions.filter = filter
+		return nil
+	})
+	flag.Func("shader-define", "NAME or NAME=VALUE to #define in every shader, can be given more than once", func(define string) error {
+		name, value, err := parseShaderDefine(define)
+		if err != nil {
+			return err
+		}
+		shaderDefines = append(shaderDefines, [2]string{name, value})
+		return nil
+	})
+	materialName := flag.String("material", "burger", "material from assets/burger2.mtl to draw the burgers with")
+	iconName := flag.String("icon", "assets/cat.png", "window icon, an image that gets scaled to every icon size or an .ico file with its own sizes")
+	skinsName := flag.String("skins", "assets/skins.array", "texture array the burgers take turns picking a layer from, empty draws them with the material's texture")
+	skyboxName := flag.String("skybox", "assets/sky.png", "cubemap texture drawn behind everything (six faces in a .cube file, or an image with a cubemap layout in its json), empty turns it off")
+	screenshotDirectory := flag.String("screenshots", "screenshots", "directory screenshots get saved in")
+	screenshotScale := flag.Int("screenshot-scale", 4, "how many times the window resolution F12 screenshots get rendered at")
+	screenshotDownscale := flag.Bool("screenshot-downscale", false, "scale F12 screenshots back down to the window resolution, for supersampled instead of bigger screenshots")
+	recordPath := flag.String("record", "", "record every frame, into a .y4m video or as numbered pngs into a directory. recordings run at -record-fps no matter how fast frames actually get drawn")
+	recordFrameRate := flag.Int("record-fps", 60, "frame rate recordings are simulated at")
+	recordFrames := flag.Int("record-frames", 0, "stop after recording this many frames, 0 records until the window gets closed")
+	flag.BoolVar(&assetFS.Verify, "verify", true, "check assets from directories and archives against the asset manifest, packs and archives with their own manifest fail on a mismatch")
+	flag.Parse()
+
+	// override directories beat archives, archives beat whatever is baked into the binary
+	for _, directory := range overrideDirectories {
+		if err := assetFS.AddDirectory(directory); err != nil {
+			util.ThrowError(err)
+		}
+	}
+	for _, archive := range archives {
+		if err := assetFS.AddArchive(archive); err != nil {
+			util.ThrowError(err)
+		}
+	}
+	// big asset sets can ship as a pack next to the binary instead of inside it
+	if sidecar, ok := sidecarPack(); ok {
+		if err := assetFS.AddPack(sidecar); err != nil {
+			util.ThrowError(err)
+		}
+	}
+	assetFS.AddEmbedded()
+	defer assetFS.Close()
+
 	window := initGlfw(600, 800, "test")
 	defer glfw.Terminate()
 
-	cat, err := Asset("assets/cat.png")
+	// without an icon the window just keeps the default one
+	icon, err := loadAsset(*iconName)
 	if err != nil {
-		util.ThrowError(err)
+		util.ThrowWarning("Failed to load the window icon: " + err.Error())
+	} else {
+		setIcon(window, icon)
 	}
 
-	setIcon(window, cat)
+	initOpenGL()
 
-
-	vertexShaderSource, err := Asset("assets/vertex.glsl")
-	if err != nil {
-		util.ThrowError(err)
+	// shaders get the #version of the context, and whatever got defined on the command line
+	shaders := newShaderPreprocessor()
+	for _, define := range shaderDefines {
+		shaders.define(define[0], define[1])
 	}
 
-	fragmentShaderSource, err := Asset("assets/frag.glsl")
+	program, err := LoadProgram(shaders, "assets/vertex.glsl", "assets/frag.glsl")
 	if err != nil {
 		util.ThrowError(err)
 	}
-
-	program, err := initOpenGL(
-		string(vertexShaderSource)+"\x00", // \x00 is required because openGL
-		string(fragmentShaderSource)+"\x00",
-	)
-
-	defer gl.DeleteProgram(program)
-
+	defer program.Delete()
+	// what the mesh and texture array buffers feed it and what gets set from here
+	err = program.CheckInterface(ExpectedInterface{
+		Attributes: []ShaderVariable{
+			{Name: "position", Type: gl.FLOAT_VEC3, Location: 0},
+			{Name: "texCoord", Type: gl.FLOAT_VEC2, Location: 1},
+			{Name: "layer", Type: gl.INT, Location: 2},
+		},
+		Uniforms: []ShaderVariable{
+			{Name: "u_MVP", Type: gl.FLOAT_MAT4},
+			{Name: "u_BurgerCount", Type: gl.INT},
+			{Name: "u_Radius", Type: gl.FLOAT},
+			{Name: "u_InstanceOffset", Type: gl.INT},
+			{Name: "u_Texture", Type: gl.SAMPLER_2D},
+			{Name: "u_UseTextureArray", Type: gl.BOOL},
+			{Name: "u_TextureArray", Type: gl.SAMPLER_2D_ARRAY},
+			{Name: "u_AlphaCutoff", Type: gl.FLOAT},
+			{Name: "u_Opacity", Type: gl.FLOAT},
+			{Name: "u_PremultipliedAlpha", Type: gl.BOOL},
+			{Name: "u_EncodeSRGB", Type: gl.BOOL},
+		},
+	})
 	if err != nil {
 		util.ThrowError(err)
 	}
 
-	gl.UseProgram(program)
+	program.Use()
 
-	obj, err := Asset("assets/burger.obj")
+	sky, err := newSkybox(shaders)
 	if err != nil {
 		util.ThrowError(err)
 	}
+	defer sky.delete()
 
-	vertices := parseObj(&obj)
-
-	// vertex buffer
-	var vbo uint32
-	gl.GenBuffers(1, &vbo)
-	defer gl.DeleteBuffers(1, &vbo)
-	
-	gl.BindBuffer(gl.ARRAY_BUFFER, vbo)
-	gl.BufferData(gl.ARRAY_BUFFER, len(vertices)*util.Sizeoffloat32(), gl.Ptr(vertices), gl.STATIC_DRAW)
-
-
-	// vertex array
-	var vao uint32
-	gl.GenVertexArrays(1, &vao)
-	defer gl.DeleteVertexArrays(1, &vao)
-	
-	gl.BindVertexArray(vao)
-
-	gl.EnableVertexAttribArray(0)
-	gl.VertexAttribPointerWithOffset(0, 3, gl.FLOAT, false, int32(5*util.Sizeoffloat32()), 0)
-
-	gl.EnableVertexAttribArray(1)
-	gl.VertexAttribPointerWithOffset(1, 2, gl.FLOAT, false, int32(5*util.Sizeoffloat32()), uintptr(3*util.Sizeoffloat32()))
+	assets := NewAssetManager()
+	defer assets.Close()
 
+	// the burger and its texture get decoded in the background, the window keeps rendering in the meantime
+	burger := assets.MeshAsync("assets/burger.obj")
+	defer assets.Release("assets/burger.obj")
 
 	// index buffer
 	/*
@@ -100,61 +181,52 @@ func main() {
 	gl.BufferData(gl.ELEMENT_ARRAY_BUFFER, len(indices)*util.Sizeofuint32(), gl.Ptr(indices), gl.STATIC_DRAW)
 	*/
 
-	// texture
-	catBytes, err := Asset("assets/texture.png")
+	// material, the obj points at its mtl file but parseObj doesn't care about that
+	mtl, err := loadAsset("assets/burger2.mtl")
 	if err != nil {
 		util.ThrowError(err)
 	}
-
-	reader := bytes.NewReader(catBytes)
-	catImage, _, err := image.Decode(reader)
+	materials, err := parseMTL(mtl, "assets")
 	if err != nil {
-		util.ThrowError(err)
+		util.ThrowError(fmt.Errorf("Failed to parse assets/burger2.mtl: %v", err))
+	}
+	material, ok := materials[*materialName]
+	if !ok {
+		util.ThrowError(fmt.Errorf("assets/burger2.mtl doesn't have a material called %s", *materialName))
 	}
 
-	catTexture := image.NewRGBA(catImage.Bounds())
-	// stolen
-	draw.Draw(catTexture, catTexture.Bounds(), catImage, image.Point{0, 0}, draw.Src)
-	flippedPixels := flipImage(catTexture)
-
-	var texture uint32
-	gl.GenTextures(1, &texture)
-	defer gl.DeleteTextures(1, &texture)
-	
-	gl.BindTexture(gl.TEXTURE_2D, texture)
-	
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.NEAREST)
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.NEAREST)
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
-
-	gl.TexImage2D(
-		gl.TEXTURE_2D,
-		0,
-		gl.RGBA,
-		int32(catTexture.Rect.Size().X),
-		int32(catTexture.Rect.Size().Y),
-		0,
-		gl.RGBA,
-		gl.UNSIGNED_BYTE,
-		gl.Ptr(flippedPixels),
-	)
+	// texture, relative to the mtl file
+	textureName := path.Join("assets", material.diffuseMap)
+	texture := assets.TextureAsync(textureName)
+	defer assets.Release(textureName)
+	sampler := assets.Sampler(material.diffuseDescriptor)
+
+	// skins, every burger gets its own layer through a per instance attribute
+	var skins *AssetHandle
+	if *skinsName != "" {
+		skins = assets.TextureAsync(*skinsName)
+		defer assets.Release(*skinsName)
+	}
+	var skinLayerBuffer uint32
+	gl.GenBuffers(1, &skinLayerBuffer)
+	defer gl.DeleteBuffers(1, &skinLayerBuffer)
+	var skinLayers int32 // what the buffer got filled for
+
+	// the sky starts out black until its cubemap is ready, like before there was one
+	var skyTexture *AssetHandle
+	if *skyboxName != "" {
+		skyTexture = assets.TextureAsync(*skyboxName)
+		defer assets.Release(*skyboxName)
+	}
 
 	gl.ActiveTexture(gl.TEXTURE0)
 
-	// probably does nothing because go has a garbage collector but it helps me sleep at night
-	catBytes = nil
-	catImage = nil
-	catTexture = nil
+	// bind texture to texture slot 0, the skins go in slot 1
+	mustSetUniform(program.SetSampler("u_Texture", 0))
+	mustSetUniform(program.SetSampler("u_TextureArray", 1))
 
-	
-	// bind texture to texture slot 0
-	textureLocation := uniformLocation("u_Texture", &program)
-	gl.Uniform1i(textureLocation, 0)
-	
-	mvpLocation := uniformLocation("u_MVP", &program)
-	burgerCountLocation := uniformLocation("u_BurgerCount", &program)
-	radiusLocation := uniformLocation("u_Radius", &program)
+	var queue renderQueue
+	camera := glm.Vec3{10, 5, 10}
 
 	var burgerCount int32 = 25;
 	var radius float32 = 10;
@@ -166,9 +238,67 @@ func main() {
 	
 	var previousCursorX float64
 
+	keyBindings[glfw.KeyI] = func() {
+		printResidentAssets(assets)
+	}
+
+	// G switches between the linear pipeline and the old gamma space one to compare them
+	colors := newColorPipeline(program, sky.program)
+	colors.set(true, assets)
+	keyBindings[glfw.KeyG] = func() {
+		colors.toggle(assets)
+	}
+
+	// P saves a screenshot of the window, F12 renders one at a higher resolution
+	screenshots := newScreenshotter(*screenshotDirectory, *screenshotScale, *screenshotDownscale)
+	defer screenshots.wait()
+	keyBindings[glfw.KeyP] = screenshots.take
+	keyBindings[glfw.KeyF12] = screenshots.takeHighResolution
+
+	var recording *recorder
+	if *recordPath != "" {
+		width, height := window.GetFramebufferSize()
+		recording, err = newRecorder(*recordPath, *recordFrameRate, width, height)
+		if err != nil {
+			util.ThrowError(fmt.Errorf("Failed to start recording: %v", err))
+		}
+		defer func() {
+			if err := recording.close(); err != nil {
+				util.ThrowWarning("Failed to record: " + err.Error())
+			} else {
+				util.ThrowNotification(fmt.Sprintf("Recorded %d frames to %s", recording.frames, *recordPath))
+			}
+		}()
+	}
+
+	// hot reloading only makes sense when the assets don't all come from the binary
+	var watcher *assetWatcher
+	if assetFS.HasExternalLayers() {
+		watched := []string{textureName, textureName + ".json", "assets/burger.obj", manifestName}
+		if *skyboxName != "" {
+			watched = append(watched, *skyboxName, *skyboxName+".json")
+		}
+		if *skinsName != "" {
+			watched = append(watched, *skinsName, *skinsName+".json")
+		}
+		watcher = newAssetWatcher(watched, 250*time.Millisecond)
+		defer watcher.Close()
+	}
+
 	for !window.ShouldClose() {
+		// don't spend more than a few milliseconds per frame on uploads so big assets don't cause hitches
+		assets.ProcessUploads(4 * time.Millisecond)
+
+		if watcher != nil {
+			watcher.Poll(assets.Reload)
+		}
+
 		// FPS
 		currentTime := glfw.GetTime()
+		if recording != nil {
+			// every recorded frame is exactly one frame apart, however long it really took
+			currentTime = recording.time()
+		}
 		deltaTime := currentTime - previousTime
 		timeSinceFPSUpdate := currentTime - fpsUpdatePreviousTime
 
@@ -181,8 +311,6 @@ func main() {
 		
 		previousTime = currentTime
 
-		gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
-
 		screenX, screenY := window.GetSize()
 		aspectRatio := float32(screenX) / float32(screenY)
 		
@@ -198,19 +326,95 @@ func main() {
 		angle += float32(deltaCursorX * 0.01)
 		previousCursorX = cursorX
 
-		projection := glm.Perspective(glm.DegToRad(90), aspectRatio, 0.01, 1000.0)
-		view := glm.LookAtV(glm.Vec3{10, 5, 10}, glm.Vec3{0, 0, 0}, glm.Vec3{0, 1, 0})
-		model := glm.Translate3D(0, 0, 0).Mul4(glm.HomogRotate3DY(angle))
+		// the whole frame, screenshots can draw it again somewhere else
+		drawFrame := func() {
+			gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
+
+			projection := glm.Perspective(glm.DegToRad(90), aspectRatio, 0.01, 1000.0)
+			view := glm.LookAtV(camera, glm.Vec3{0, 0, 0}, glm.Vec3{0, 1, 0})
+			model := glm.Translate3D(0, 0, 0).Mul4(glm.HomogRotate3DY(angle))
+
+			mvp := projection.Mul4(view).Mul4(model)
+			mustSetUniform(program.SetMat4("u_MVP", mvp))
+
+			mustSetUniform(program.SetInt("u_BurgerCount", burgerCount))
+			mustSetUniform(program.SetFloat("u_Radius", radius))
 
-		mvp := projection.Mul4(view).Mul4(model)
-		gl.UniformMatrix4fv(mvpLocation, 1, false, &mvp[0])
+			for _, handle := range []*AssetHandle{burger, texture, skyTexture, skins} {
+				if handle != nil && handle.State() == AssetFailed {
+					util.ThrowError(handle.Err())
+				}
+			}
 
-		gl.Uniform1i(burgerCountLocation, burgerCount)
-		gl.Uniform1f(radiusLocation, radius)
+			// gl.DrawElements(gl.TRIANGLES, int32(len(indices)), gl.UNSIGNED_INT, nil)
+			// gl.DrawArrays(gl.TRIANGLES, 0, burger.Mesh().VertexCount)
+			if burger.State() == AssetReady && texture.State() == AssetReady {
+				useSkins := skins != nil && skins.State() == AssetReady && skins.Texture().Target == gl.TEXTURE_2D_ARRAY
+				// a reload can change how many layers there are
+				if useSkins && skins.Texture().Layers != skinLayers {
+					skinLayers = skins.Texture().Layers
+					uploadInstanceLayers(burger.Mesh().VAO, skinLayerBuffer, burgerCount, skinLayers)
+				}
+
+				drawBurgers := func(first int32, count int32) {
+					program.Use()
+					mustSetUniform(setMaterialUniforms(program, material))
+					mustSetUniform(program.SetInt("u_InstanceOffset", first))
+					mustSetUniform(program.SetBool("u_UseTextureArray", useSkins))
+
+					gl.BindVertexArray(burger.Mesh().VAO)
+					gl.ActiveTexture(gl.TEXTURE0)
+					gl.BindTexture(texture.Texture().Target, texture.Texture().Handle)
+					gl.BindSampler(0, sampler)
+
+					if useSkins {
+						gl.ActiveTexture(gl.TEXTURE1)
+						gl.BindTexture(gl.TEXTURE_2D_ARRAY, skins.Texture().Handle)
+						gl.BindSampler(1, skins.Texture().Sampler)
+					}
+
+					// the base instance is where the per instance layers start
+					gl.DrawArraysInstancedBaseInstance(gl.TRIANGLES, 0, burger.Mesh().VertexCount, count, uint32(first))
+				}
+
+				if material.blend.transparent() {
+					// one burger at a time, so they can be sorted
+					for i := int32(0); i < burgerCount; i++ {
+						instance := i
+						burgerAngle := 2 * math.Pi / float64(burgerCount) * float64(i)
+						position := model.Mul4x1(glm.Vec4{float32(math.Cos(burgerAngle)) * radius, 0, float32(math.Sin(burgerAngle)) * radius, 1}).Vec3()
+						queue.add(drawCall{blend: material.blend, position: position, draw: func() { drawBurgers(instance, 1) }})
+					}
+				} else {
+					queue.add(drawCall{blend: material.blend, position: model.Col(3).Vec3(), draw: func() { drawBurgers(0, burgerCount) }})
+				}
+			}
 
-		// gl.DrawElements(gl.TRIANGLES, int32(len(indices)), gl.UNSIGNED_INT, nil)
-		// gl.DrawArrays(gl.TRIANGLES, 0, int32(len(vertices)))
-		gl.DrawArraysInstanced(gl.TRIANGLES, 0, int32(len(vertices)), burgerCount)
+			queue.drawOpaque(camera)
+
+			// after the opaque things so it only gets drawn where they aren't, before the transparent ones
+			// since those don't write depth
+			if skyTexture != nil && skyTexture.State() == AssetReady {
+				if skyTexture.Texture().Target == gl.TEXTURE_CUBE_MAP {
+					sky.draw(skyTexture.Texture(), projection, view)
+				} else {
+					// it stays loaded until the end, the deferred release still needs it
+					util.ThrowWarning(*skyboxName + " isn't a cubemap, there won't be a skybox")
+					skyTexture = nil
+				}
+			}
+
+			queue.drawTransparent(camera)
+		}
+
+		drawFrame()
+		if recording != nil {
+			recording.capture(window)
+			if *recordFrames > 0 && recording.frames >= *recordFrames {
+				window.SetShouldClose(true)
+			}
+		}
+		screenshots.capture(window, colors.framebufferSRGB, drawFrame)
 
 		glfw.PollEvents()
 		window.SwapBuffers()
@@ -265,32 +469,38 @@ func parseObj(obj *[]byte) []float32 {
 	return vertices
 }
 
-func flipImage(image *image.RGBA) []uint8 {
-	pixels := make([]uint8, len(image.Pix))
-	imageX := image.Rect.Size().X
-	imageY := image.Rect.Size().Y
-
-	for y := 0; y < imageY; y++ {	
-		for x := 0; x < imageX; x++ {
-			index := (y * imageX + x) * 4
-			flippedIndex := ((imageY - y - 1) * imageX + x) * 4
-
-			pixels[index + 0] = image.Pix[flippedIndex + 0]
-			pixels[index + 1] = image.Pix[flippedIndex + 1]
-			pixels[index + 2] = image.Pix[flippedIndex + 2]
-			pixels[index + 3] = image.Pix[flippedIndex + 3]
+func decodeMesh(obj []byte) (vertices []float32, err error) {
+	// parseObj panics on bad input, which is fine on startup but a half exported file shouldn't kill the app
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Failed to parse obj: %v", r)
 		}
+	}()
+
+	vertices = parseObj(&obj)
+	if len(vertices) == 0 {
+		return nil, fmt.Errorf("Obj file doesn't contain any faces")
 	}
 
-	return pixels
+	return vertices, nil
 }
 
-func uniformLocation(name string, program *uint32) int32 {
-	location := gl.GetUniformLocation(*program, gl.Str(name + "\x00"))
-	if location == -1 {
-		util.ThrowWarning("Could not find location of uniform: " + name)
-	}
-	return location
+// (Re)uploads vertices into an existing buffer
+func uploadMesh(vbo uint32, vertices []float32) {
+	gl.BindBuffer(gl.ARRAY_BUFFER, vbo)
+	gl.BufferData(gl.ARRAY_BUFFER, len(vertices)*util.Sizeoffloat32(), gl.Ptr(vertices), gl.STATIC_DRAW)
+}
+
+func flipImage(image *image.RGBA) []uint8 {
+	size := image.Rect.Size()
+	return copyRowsFlipped(image.Pix, image.Stride, size.X*4, size.Y, 0)
+}
+
+// the other way around, bottom row first rgba from opengl back into an image
+func unflipImage(pixels []uint8, width int, height int) *image.RGBA {
+	result := image.NewRGBA(image.Rect(0, 0, width, height))
+	copy(result.Pix, copyRowsFlipped(pixels, width*4, width*4, height, 0))
+	return result
 }
 
 func compileShader(source string, shaderType uint32) (uint32, error) {
@@ -313,25 +523,23 @@ func compileShader(source string, shaderType uint32) (uint32, error) {
 
 		gl.DeleteShader(shader)
 
-		var shaderTypeString string
-
-		switch shaderType {
-		case gl.VERTEX_SHADER:
-			shaderTypeString = "vertex"
-		case gl.FRAGMENT_SHADER:
-			shaderTypeString = "fragment"
-		}
-
-		return 0, fmt.Errorf("Failed to compile %s shader: %v", shaderTypeString, log)
+		return 0, fmt.Errorf("Failed to compile %s shader: %v", shaderStageName(shaderType), log)
 	}
 	
 	return shader, nil
 }
 
+// things that need state from main register themselves here instead of the callback knowing about everything
+var keyBindings = map[glfw.Key]func(){}
+
 func keyCallback(window *glfw.Window, key glfw.Key, scancode int, action glfw.Action, mods glfw.ModifierKey) {
 	if key == glfw.KeyQ && action == glfw.Press {
 		fmt.Println("HI")
 	}
+
+	if binding, ok := keyBindings[key]; ok && action == glfw.Press {
+		binding()
+	}
 }
 
 func resizeCallback(window *glfw.Window, width int, height int) {
@@ -375,7 +583,7 @@ func messageCallback(source uint32, gltype uint32, id uint32, severity uint32, l
 	}
 }
 
-func initOpenGL(vertexShaderSource string, fragmentShaderSource string) (uint32, error) {
+func initOpenGL() {
 	if err := gl.Init(); err != nil {
 		util.ThrowError(err)
 	}
@@ -389,39 +597,8 @@ func initOpenGL(vertexShaderSource string, fragmentShaderSource string) (uint32,
 	gl.Enable(gl.DEPTH_TEST)
 	gl.DepthFunc(gl.LESS)
 
-	vertexShader, err := compileShader(vertexShaderSource, gl.VERTEX_SHADER)
-	if err != nil {
-		util.ThrowError(err)
-	}
-
-	fragmentShader, err := compileShader(fragmentShaderSource, gl.FRAGMENT_SHADER)
-	if err != nil {
-		util.ThrowError(err)
-	}
-
-	program := gl.CreateProgram()
-	gl.AttachShader(program, vertexShader)
-	gl.AttachShader(program, fragmentShader)
-
-	gl.LinkProgram(program)
-	gl.ValidateProgram(program)
-
-	var status int32
-	gl.GetProgramiv(program, gl.LINK_STATUS, &status)
-	if status == gl.FALSE {
-		var logLength int32
-		gl.GetProgramiv(program, gl.INFO_LOG_LENGTH, &logLength)
-
-		log := strings.Repeat("\x00", int(logLength+1))
-		gl.GetProgramInfoLog(program, logLength, nil, gl.Str(log))
-
-		return 0, fmt.Errorf("failed to link program: %v", log)
-	}
-
-	gl.DeleteShader(vertexShader)
-	gl.DeleteShader(fragmentShader)
-
-	return program, nil
+	// filter across cubemap face edges instead of clamping at them, otherwise the seams show on the skybox
+	gl.Enable(gl.TEXTURE_CUBE_MAP_SEAMLESS)
 }
 
 func initGlfw(width, height int, name string) *glfw.Window {
@@ -436,6 +613,9 @@ func initGlfw(width, height int, name string) *glfw.Window {
 	glfw.WindowHint(glfw.OpenGLProfile, glfw.OpenGLCoreProfile)
 	glfw.WindowHint(glfw.OpenGLForwardCompatible, glfw.True)
 
+	// lets the framebuffer encode linear colors to sRGB, see colorPipeline
+	glfw.WindowHint(glfw.SRGBCapable, glfw.True)
+
 	window, err := glfw.CreateWindow(width, height, name, nil, nil)
 	if err != nil {
 		util.ThrowError(err)
@@ -451,16 +631,12 @@ func initGlfw(width, height int, name string) *glfw.Window {
 }
 
 func setIcon(window *glfw.Window, imageBytes []byte) {
-	reader := bytes.NewReader(imageBytes)
-
-	// defer reader.Close()
-
-	png, _, err := image.Decode(reader)
+	// several sizes, the OS picks the one that fits
+	images, err := iconImages(imageBytes)
 	if err != nil {
-		util.ThrowError(err)
+		util.ThrowWarning("Failed to decode the window icon: " + err.Error())
+		return
 	}
 
-	// Dunno why it takes an array tbh
-	var images = []image.Image{png}
 	window.SetIcon(images)
 }
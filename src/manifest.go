@@ -0,0 +1,229 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// lives next to the assets it describes and is skipped when hashing
+const manifestName = "assets/manifest.json"
+
+type ManifestEntry struct {
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+type Manifest struct {
+	Assets map[string]ManifestEntry `json:"assets"`
+}
+
+type ManifestMismatchError struct {
+	Name   string
+	Source string // the layer the asset was read from
+
+	ExpectedSize int64
+	ActualSize   int64
+	ExpectedHash string
+	ActualHash   string
+}
+
+func (err *ManifestMismatchError) Error() string {
+	message := fmt.Sprintf("Asset %s from %s doesn't match the manifest:", err.Name, err.Source)
+	if err.ExpectedSize != err.ActualSize {
+		message += fmt.Sprintf("\n	size: expected %d bytes, got %d bytes", err.ExpectedSize, err.ActualSize)
+	}
+	message += fmt.Sprintf("\n	sha256: expected %s, got %s", err.ExpectedHash, err.ActualHash)
+	message += "\n	if the change is intentional regenerate the manifest with: manifest generate <dir>"
+	return message
+}
+
+func hashAsset(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Hashes every file under assets/ in the given filesystem
+func generateManifest(fsys fs.FS) (*Manifest, error) {
+	manifest := &Manifest{Assets: make(map[string]ManifestEntry)}
+
+	err := fs.WalkDir(fsys, "assets", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || name == manifestName {
+			return nil
+		}
+
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+
+		manifest.Assets[name] = ManifestEntry{Size: int64(len(data)), SHA256: hashAsset(data)}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+func parseManifest(data []byte) (*Manifest, error) {
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("Invalid manifest: %v", err)
+	}
+	if manifest.Assets == nil {
+		manifest.Assets = make(map[string]ManifestEntry)
+	}
+	return &manifest, nil
+}
+
+func loadManifest(fsys fs.FS) (*Manifest, error) {
+	data, err := fs.ReadFile(fsys, manifestName)
+	if err != nil {
+		return nil, err
+	}
+	return parseManifest(data)
+}
+
+func (manifest *Manifest) Has(name string) bool {
+	_, ok := manifest.Assets[name]
+	return ok
+}
+
+func (manifest *Manifest) Verify(name string, source string, data []byte) error {
+	entry, ok := manifest.Assets[name]
+	if !ok {
+		return fmt.Errorf("Asset %s from %s isn't in the manifest", name, source)
+	}
+
+	hash := hashAsset(data)
+	if int64(len(data)) != entry.Size || hash != entry.SHA256 {
+		return &ManifestMismatchError{
+			Name:         name,
+			Source:       source,
+			ExpectedSize: entry.Size,
+			ActualSize:   int64(len(data)),
+			ExpectedHash: entry.SHA256,
+			ActualHash:   hash,
+		}
+	}
+
+	return nil
+}
+
+func (manifest *Manifest) Marshal() ([]byte, error) {
+	// encoding/json sorts map keys so the output is stable and diffs nicely
+	data, err := json.MarshalIndent(manifest, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// Compares the manifest against the files in fsys, returns one error per problem
+func (manifest *Manifest) Check(fsys fs.FS, source string) []error {
+	var problems []error
+
+	names := make([]string, 0, len(manifest.Assets))
+	for name := range manifest.Assets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("Asset %s is in the manifest but can't be read: %v", name, err))
+			continue
+		}
+		if err := manifest.Verify(name, source, data); err != nil {
+			problems = append(problems, err)
+		}
+	}
+
+	current, err := generateManifest(fsys)
+	if err != nil {
+		return append(problems, err)
+	}
+
+	var extra []string
+	for name := range current.Assets {
+		if !manifest.Has(name) {
+			extra = append(extra, name)
+		}
+	}
+	sort.Strings(extra)
+
+	for _, name := range extra {
+		problems = append(problems, fmt.Errorf("Asset %s isn't in the manifest", name))
+	}
+
+	return problems
+}
+
+// manifest generate [dir] | manifest check [dir]
+// dir is the directory containing assets/, defaults to the current directory
+func runManifestCommand(args []string) int {
+	if len(args) < 1 || len(args) > 2 || (args[0] != "generate" && args[0] != "check") {
+		fmt.Fprintln(os.Stderr, "usage: manifest generate|check [dir]")
+		return 2
+	}
+
+	directory := "."
+	if len(args) == 2 {
+		directory = args[1]
+	}
+	fsys := os.DirFS(directory)
+	manifestPath := filepath.Join(directory, filepath.FromSlash(manifestName))
+
+	if args[0] == "generate" {
+		manifest, err := generateManifest(fsys)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		data, err := manifest.Marshal()
+		if err == nil {
+			err = os.WriteFile(manifestPath, data, 0644)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		fmt.Printf("Wrote %d assets to %s\n", len(manifest.Assets), manifestPath)
+		return 0
+	}
+
+	manifest, err := loadManifest(fsys)
+	if errors.Is(err, fs.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "%s doesn't exist, run manifest generate first\n", manifestPath)
+		return 1
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	problems := manifest.Check(fsys, filepath.Clean(directory))
+	for _, problem := range problems {
+		fmt.Fprintln(os.Stderr, problem)
+	}
+	if len(problems) > 0 {
+		fmt.Fprintf(os.Stderr, "%d problems found\n", len(problems))
+		return 1
+	}
+
+	fmt.Printf("All %d assets match %s\n", len(manifest.Assets), manifestPath)
+	return 0
+}
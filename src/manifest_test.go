@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestGenerateManifest(t *testing.T) {
+	fsys := fstest.MapFS{
+		"assets/cat.png":       {Data: []byte("cat")},
+		"assets/models/a.obj":  {Data: []byte("")},
+		"assets/manifest.json": {Data: []byte("{}")},
+		"src/main.go":          {Data: []byte("package main")},
+	}
+
+	manifest, err := generateManifest(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]ManifestEntry{
+		"assets/cat.png":      {Size: 3, SHA256: "77af778b51abd4a3c51c5ddd97204a9c3ae614ebccb75a606c3b6865aed6744e"},
+		"assets/models/a.obj": {Size: 0, SHA256: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+	}
+	if len(manifest.Assets) != len(want) {
+		t.Errorf("manifest has %v, want %v", manifest.Assets, want)
+	}
+	for name, entry := range want {
+		if manifest.Assets[name] != entry {
+			t.Errorf("%s is %+v, want %+v", name, manifest.Assets[name], entry)
+		}
+	}
+
+	// what gets written reads back the same
+	data, err := manifest.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := parseManifest(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed.Assets) != len(want) || parsed.Assets["assets/cat.png"] != want["assets/cat.png"] {
+		t.Errorf("parsed manifest is %v", parsed.Assets)
+	}
+}
+
+func TestManifestVerify(t *testing.T) {
+	fsys := fstest.MapFS{"assets/cat.png": {Data: []byte("cat")}}
+	manifest, _ := generateManifest(fsys)
+
+	if err := manifest.Verify("assets/cat.png", "test", []byte("cat")); err != nil {
+		t.Errorf("the same data doesn't match: %v", err)
+	}
+
+	var mismatch *ManifestMismatchError
+	err := manifest.Verify("assets/cat.png", "test", []byte("dog!"))
+	if !errors.As(err, &mismatch) || mismatch.ActualSize != 4 || mismatch.ExpectedSize != 3 || !strings.Contains(err.Error(), "size") {
+		t.Errorf("different size gave %v", err)
+	}
+
+	err = manifest.Verify("assets/cat.png", "test", []byte("dog"))
+	if !errors.As(err, &mismatch) || mismatch.ActualSize != mismatch.ExpectedSize || mismatch.ActualHash == mismatch.ExpectedHash {
+		t.Errorf("same size but different contents gave %v", err)
+	}
+	if strings.Contains(err.Error(), "size:") {
+		t.Errorf("a hash mismatch talks about the size: %v", err)
+	}
+
+	if err := manifest.Verify("assets/dog.png", "test", []byte("dog")); err == nil || errors.As(err, &mismatch) {
+		t.Errorf("a file that isn't in the manifest gave %v", err)
+	}
+}
+
+func TestManifestCheck(t *testing.T) {
+	fsys := fstest.MapFS{
+		"assets/cat.png":    {Data: []byte("cat")},
+		"assets/burger.obj": {Data: []byte("burger")},
+		"assets/frag.glsl":  {Data: []byte("shader")},
+	}
+	manifest, _ := generateManifest(fsys)
+	if problems := manifest.Check(fsys, "test"); len(problems) != 0 {
+		t.Errorf("a fresh manifest has problems: %v", problems)
+	}
+
+	fsys["assets/cat.png"] = &fstest.MapFile{Data: []byte("kitten")}
+	fsys["assets/burger.obj"] = &fstest.MapFile{Data: []byte("BURGER")}
+	delete(fsys, "assets/frag.glsl")
+	fsys["assets/new.png"] = &fstest.MapFile{Data: []byte("new")}
+
+	problems := manifest.Check(fsys, "test")
+	want := []string{
+		"assets/burger.obj from test doesn't match",
+		"assets/cat.png from test doesn't match",
+		"assets/frag.glsl is in the manifest but can't be read",
+		"assets/new.png isn't in the manifest",
+	}
+	if len(problems) != len(want) {
+		t.Fatalf("got %d problems, want %d: %v", len(problems), len(want), problems)
+	}
+	for i, problem := range problems {
+		if !strings.Contains(problem.Error(), want[i]) {
+			t.Errorf("problem %d is %q, want it to mention %q", i, problem, want[i])
+		}
+	}
+}
+
+func TestVerifyPolicy(t *testing.T) {
+	// a layer with a manifest that doesn't fit its shader anymore
+	changed := func() fstest.MapFS {
+		fsys := fstest.MapFS{"assets/frag.glsl": {Data: []byte("shader")}}
+		addManifest(t, fsys)
+		fsys["assets/frag.glsl"] = &fstest.MapFile{Data: []byte("patched shader")}
+		return fsys
+	}
+
+	tests := []struct {
+		name      string
+		layer     *vfsLayer
+		wantError bool
+	}{
+		{"pack with its own manifest", &vfsLayer{name: "patch.pack", fsys: changed()}, true},
+		{"override directory", &vfsLayer{name: "mods", fsys: changed(), directory: true}, false},
+		// gets checked against the embedded manifest instead
+		{"pack without a manifest", &vfsLayer{name: "plain.pack", fsys: fstest.MapFS{"assets/frag.glsl": {Data: []byte("patched shader")}}}, false},
+	}
+
+	for _, test := range tests {
+		for _, verify := range []bool{true, false} {
+			vfs := NewVFS()
+			vfs.Verify = verify
+			vfs.layers = append(vfs.layers, test.layer)
+			vfs.AddEmbedded()
+
+			data, err := vfs.ReadFile("assets/frag.glsl")
+			switch {
+			case verify && test.wantError:
+				var mismatch *ManifestMismatchError
+				if !errors.As(err, &mismatch) || mismatch.Source != test.layer.name {
+					t.Errorf("%s: got %v instead of a mismatch", test.name, err)
+				}
+			case err != nil:
+				t.Errorf("%s (verify %v): %v", test.name, verify, err)
+			case string(data) != "patched shader":
+				t.Errorf("%s (verify %v): read %q", test.name, verify, data)
+			}
+		}
+	}
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"math"
+)
+
+// Mipmaps get built on the cpu instead of with glGenerateMipmap, drivers are free to do whatever they
+// want there (usually a box filter in gamma space), this way the result is the same everywhere
+type mipFilter int
+
+const (
+	mipFilterBox mipFilter = iota
+	mipFilterKaiser
+	mipFilterLanczos
+)
+
+type mipmapOptions struct {
+	filter mipFilter
+
+	// the color channels are sRGB encoded, so they get filtered in linear space instead
+	srgb bool
+	// the color channels are already multiplied by alpha, otherwise they get weighted by alpha while
+	// filtering so transparent texels don't bleed their (usually black) color into the visible ones
+	premultiplied bool
+}
+
+var defaultMipmapOptions = mipmapOptions{filter: mipFilterKaiser, srgb: true}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	x *= math.Pi
+	return math.Sin(x) / x
+}
+
+// modified bessel function of the first kind, order 0, for the kaiser window
+func bessel0(x float64) float64 {
+	sum, term := 1.0, 1.0
+	for k := 1; k < 32; k++ {
+		term *= (x / (2 * float64(k))) * (x / (2 * float64(k)))
+		sum += term
+		if term < sum*1e-12 {
+			break
+		}
+	}
+	return sum
+}
+
+// returns the kernel and how far it reaches on either side, in source texels at a 1:1 scale
+func (filter mipFilter) kernel() (func(float64) float64, float64) {
+	switch filter {
+	case mipFilterKaiser:
+		const width, alpha = 3.0, 4.0
+		normalization := bessel0(alpha)
+		return func(x float64) float64 {
+			t := x / width
+			if t*t >= 1 {
+				return 0
+			}
+			return sinc(x) * bessel0(alpha*math.Sqrt(1-t*t)) / normalization
+		}, width
+	case mipFilterLanczos:
+		const lobes = 3.0
+		return func(x float64) float64 {
+			if math.Abs(x) >= lobes {
+				return 0
+			}
+			return sinc(x) * sinc(x/lobes)
+		}, lobes
+	}
+
+	return func(x float64) float64 {
+		if x >= -0.5 && x < 0.5 {
+			return 1
+		}
+		return 0
+	}, 0.5
+}
+
+// linear, premultiplied pixels
+type floatImage struct {
+	width    int
+	height   int
+	channels int
+	pixels   []float32
+}
+
+var srgbToLinearTable = func() (table [256]float32) {
+	for i := range table {
+		table[i] = float32(srgbToLinear(float64(i) / 255))
+	}
+	return table
+}()
+
+func srgbToLinear(value float64) float64 {
+	if value <= 0.04045 {
+		return value / 12.92
+	}
+	return math.Pow((value+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(value float64) float64 {
+	if value <= 0.0031308 {
+		return value * 12.92
+	}
+	return 1.055*math.Pow(value, 1/2.4) - 0.055
+}
+
+func quantize(value float64) uint8 {
+	return uint8(math.Max(0, math.Min(255, math.Round(value*255))))
+}
+
+func hasAlpha(format textureFormat) bool {
+	return format == textureRGBA
+}
+
+func textureToFloat(data *textureData, options mipmapOptions) *floatImage {
+	channels := data.format.channels()
+	if data.format.float() {
+		// already linear, and there's no alpha to premultiply by
+		return &floatImage{width: int(data.width), height: int(data.height), channels: channels, pixels: readFloats(data)}
+	}
+
+	colorChannels := channels
+	if hasAlpha(data.format) {
+		colorChannels = 3
+	}
+
+	image := &floatImage{
+		width:    int(data.width),
+		height:   int(data.height),
+		channels: channels,
+		pixels:   make([]float32, len(data.pixels)),
+	}
+
+	for i := 0; i < len(data.pixels); i += channels {
+		alpha := float32(1)
+		if hasAlpha(data.format) {
+			alpha = float32(data.pixels[i+3]) / 255
+			image.pixels[i+3] = alpha
+		}
+
+		for c := 0; c < colorChannels; c++ {
+			var value float32
+			if options.srgb {
+				value = srgbToLinearTable[data.pixels[i+c]]
+			} else {
+				value = float32(data.pixels[i+c]) / 255
+			}
+			if !options.premultiplied {
+				value *= alpha
+			}
+			image.pixels[i+c] = value
+		}
+	}
+
+	return image
+}
+
+func floatToTexture(image *floatImage, format textureFormat, options mipmapOptions) *textureData {
+	channels := image.channels
+	if format.float() {
+		// no upper limit, but the sharper filters ring below 0 next to very bright texels
+		values := make([]float32, len(image.pixels))
+		for i, value := range image.pixels {
+			values[i] = float32(math.Max(0, float64(value)))
+		}
+		return &textureData{width: int32(image.width), height: int32(image.height), format: format, pixels: writeFloats(format, values)}
+	}
+
+	colorChannels := channels
+	if hasAlpha(format) {
+		colorChannels = 3
+	}
+
+	data := &textureData{
+		width:  int32(image.width),
+		height: int32(image.height),
+		format: format,
+		pixels: make([]uint8, len(image.pixels)),
+	}
+
+	for i := 0; i < len(image.pixels); i += channels {
+		alpha := 1.0
+		if hasAlpha(format) {
+			alpha = math.Max(0, math.Min(1, float64(image.pixels[i+3])))
+			data.pixels[i+3] = quantize(alpha)
+		}
+
+		for c := 0; c < colorChannels; c++ {
+			value := float64(image.pixels[i+c])
+			if !options.premultiplied {
+				if alpha == 0 {
+					value = 0
+				} else {
+					value /= alpha
+				}
+			}
+			value = math.Max(0, math.Min(1, value))
+			if options.srgb {
+				value = linearToSRGB(value)
+			}
+			data.pixels[i+c] = quantize(value)
+		}
+	}
+
+	return data
+}
+
+type contribution struct {
+	first   int
+	weights []float32
+}
+
+// Works out which source texels (and how much of each) make up every destination texel along one axis.
+// When shrinking the kernel gets stretched so it covers every source texel, that's what makes it a proper
+// low pass filter instead of just point sampling a sinc
+func contributions(sourceSize int, destinationSize int, filter mipFilter) []contribution {
+	kernel, support := filter.kernel()
+
+	scale := float64(sourceSize) / float64(destinationSize)
+	filterScale := math.Max(scale, 1)
+	support *= filterScale
+
+	result := make([]contribution, destinationSize)
+	for i := range result {
+		center := (float64(i)+0.5)*scale - 0.5
+		first := int(math.Ceil(center - support))
+		last := int(math.Floor(center + support))
+
+		weights := make([]float32, last-first+1)
+		var total float64
+		for j := first; j <= last; j++ {
+			weight := kernel((float64(j) - center) / filterScale)
+			weights[j-first] = float32(weight)
+			total += weight
+		}
+
+		if total != 0 {
+			for j := range weights {
+				weights[j] = float32(float64(weights[j]) / total)
+			}
+		}
+
+		result[i] = contribution{first: first, weights: weights}
+	}
+
+	return result
+}
+
+func clampIndex(index int, size int) int {
+	if index < 0 {
+		return 0
+	}
+	if index >= size {
+		return size - 1
+	}
+	return index
+}
+
+// Separable resize, edges are clamped. Works for any size, power of two or not
+func resampleFloat(source *floatImage, width int, height int, filter mipFilter) *floatImage {
+	channels := source.channels
+
+	// horizontal pass
+	horizontal := &floatImage{width: width, height: source.height, channels: channels, pixels: make([]float32, width*source.height*channels)}
+	columns := contributions(source.width, width, filter)
+	for y := 0; y < source.height; y++ {
+		sourceRow := source.pixels[y*source.width*channels:]
+		row := horizontal.pixels[y*width*channels:]
+		for x, column := range columns {
+			for c := 0; c < channels; c++ {
+				var sum float32
+				for k, weight := range column.weights {
+					sum += sourceRow[clampIndex(column.first+k, source.width)*channels+c] * weight
+				}
+				row[x*channels+c] = sum
+			}
+		}
+	}
+
+	// vertical pass
+	result := &floatImage{width: width, height: height, channels: channels, pixels: make([]float32, width*height*channels)}
+	rows := contributions(source.height, height, filter)
+	rowLength := width * channels
+	for y, row := range rows {
+		destination := result.pixels[y*rowLength : (y+1)*rowLength]
+		for k, weight := range row.weights {
+			sourceRow := horizontal.pixels[clampIndex(row.first+k, source.height)*rowLength:]
+			for i := range destination {
+				destination[i] += sourceRow[i] * weight
+			}
+		}
+	}
+
+	return result
+}
+
+// Resizes a texture to an arbitrary size with the same filtering the mipmaps use
+func resampleTexture(data *textureData, width int, height int, filter mipFilter, options mipmapOptions) *textureData {
+	source := textureToFloat(data, options)
+	return floatToTexture(resampleFloat(source, width, height, filter), data.format, options)
+}
+
+// Builds every mip level below the base one, each level is half the size of the previous one
+// (rounded down, but at least 1) until it gets to 1x1
+func buildMipmaps(data *textureData, options mipmapOptions) []*textureData {
+	var levels []*textureData
+
+	current := textureToFloat(data, options)
+	for current.width > 1 || current.height > 1 {
+		width := current.width / 2
+		if width < 1 {
+			width = 1
+		}
+		height := current.height / 2
+		if height < 1 {
+			height = 1
+		}
+
+		// every level gets filtered from the previous one while it's still in float, so rounding
+		// errors don't pile up
+		current = resampleFloat(current, width, height, options.filter)
+		levels = append(levels, floatToTexture(current, data.format, options))
+	}
+
+	return levels
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestMipmapLevelSizes(t *testing.T) {
+	tests := []struct {
+		width, height int32
+		levels        [][2]int32
+	}{
+		{13, 5, [][2]int32{{6, 2}, {3, 1}, {1, 1}}},
+		{1, 7, [][2]int32{{1, 3}, {1, 1}}},
+		{8, 8, [][2]int32{{4, 4}, {2, 2}, {1, 1}}},
+		{1, 1, nil},
+	}
+
+	for _, test := range tests {
+		data := &textureData{width: test.width, height: test.height, format: textureRGBA, pixels: make([]uint8, test.width*test.height*4)}
+		levels := buildMipmaps(data, mipmapOptions{filter: mipFilterKaiser, srgb: true})
+		if len(levels) != len(test.levels) {
+			t.Errorf("%dx%d: got %d levels, want %d", test.width, test.height, len(levels), len(test.levels))
+			continue
+		}
+		for i, level := range levels {
+			if level.width != test.levels[i][0] || level.height != test.levels[i][1] {
+				t.Errorf("%dx%d level %d: got %dx%d, want %dx%d", test.width, test.height, i+1, level.width, level.height, test.levels[i][0], test.levels[i][1])
+			}
+			if len(level.pixels) != int(level.width*level.height*4) {
+				t.Errorf("%dx%d level %d: %d bytes of pixels for %dx%d", test.width, test.height, i+1, len(level.pixels), level.width, level.height)
+			}
+		}
+	}
+}
+
+func TestMipmapBoxAverage(t *testing.T) {
+	// 4x2 opaque gray, each 2x2 quad averages to one texel
+	data := &textureData{width: 4, height: 2, format: textureGray, pixels: []uint8{
+		0, 100, 10, 10,
+		200, 40, 30, 250,
+	}}
+	levels := buildMipmaps(data, mipmapOptions{filter: mipFilterBox})
+
+	if got := levels[0].pixels; len(got) != 2 || got[0] != 85 || got[1] != 75 {
+		t.Errorf("2x1 level is %v, want [85 75]", got)
+	}
+	if got := levels[1].pixels; len(got) != 1 || got[0] != 80 {
+		t.Errorf("1x1 level is %v, want [80]", got)
+	}
+}
+
+func TestMipmapSRGB(t *testing.T) {
+	for value := 0; value < 256; value++ {
+		if got := quantize(linearToSRGB(float64(srgbToLinearTable[value]))); got != uint8(value) {
+			t.Errorf("sRGB %d comes back from linear as %d", value, got)
+		}
+	}
+
+	// black and white average to half the light, which is 188 in sRGB and not 128
+	data := &textureData{width: 2, height: 1, format: textureGray, pixels: []uint8{0, 255}}
+	if got := buildMipmaps(data, mipmapOptions{filter: mipFilterBox, srgb: true})[0].pixels[0]; got != 188 {
+		t.Errorf("sRGB average of black and white is %d, want 188", got)
+	}
+	if got := buildMipmaps(data, mipmapOptions{filter: mipFilterBox})[0].pixels[0]; got != 128 {
+		t.Errorf("linear average of black and white is %d, want 128", got)
+	}
+}
+
+func TestMipmapTransparentEdges(t *testing.T) {
+	// opaque red next to transparent black, the black must not darken the red
+	data := &textureData{width: 2, height: 1, format: textureRGBA, pixels: []uint8{
+		255, 0, 0, 255,
+		0, 0, 0, 0,
+	}}
+
+	for _, filter := range []mipFilter{mipFilterBox, mipFilterKaiser, mipFilterLanczos} {
+		got := buildMipmaps(data, mipmapOptions{filter: filter, srgb: true})[0].pixels
+		if got[0] != 255 || got[1] != 0 || got[2] != 0 {
+			t.Errorf("filter %d: color is %v, want pure red", filter, got[:3])
+		}
+		if got[3] < 120 || got[3] > 136 {
+			t.Errorf("filter %d: alpha is %d, want about half", filter, got[3])
+		}
+	}
+}
+
+func TestContributionsNormalized(t *testing.T) {
+	for _, filter := range []mipFilter{mipFilterBox, mipFilterKaiser, mipFilterLanczos} {
+		for _, sizes := range [][2]int{{13, 6}, {7, 3}, {2, 1}, {5, 5}} {
+			for i, contribution := range contributions(sizes[0], sizes[1], filter) {
+				var total float32
+				for _, weight := range contribution.weights {
+					total += weight
+				}
+				if total < 0.999 || total > 1.001 {
+					t.Errorf("filter %d, %d -> %d: weights of texel %d add up to %f", filter, sizes[0], sizes[1], i, total)
+				}
+			}
+		}
+	}
+}
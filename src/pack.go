@@ -0,0 +1,603 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Pack files are a simple archive format for shipping assets next to the binary instead of inside it.
+//
+//	header (32 bytes, little endian)
+//		magic        [8]byte  "ASSETPAK"
+//		version      uint32
+//		alignment    uint32   every entry's data starts at a multiple of this
+//		entryCount   uint32
+//		reserved     uint32
+//		indexOffset  uint64
+//	entry data, padded to the alignment
+//	index, one record per entry
+//		nameLength   uint16
+//		name         [nameLength]byte
+//		compression  uint8    packStore or packFlate
+//		reserved     uint8
+//		crc          uint32   crc32 (IEEE) of the uncompressed data
+//		offset       uint64
+//		storedSize   uint64   size in the file
+//		size         uint64   uncompressed size
+//		modTime      int64    unix seconds
+//
+// Stored entries can be read (or mmapped) straight out of the file since they're aligned,
+// which is what you want for pngs and jpgs that are already compressed anyway
+const (
+	packMagic            = "ASSETPAK"
+	packVersion          = 1
+	packHeaderSize       = 32
+	packDefaultAlignment = 16
+
+	// nothing we ship comes close, anything bigger is a corrupt index
+	packMaxEntrySize = 1 << 30
+)
+
+const (
+	packStore uint8 = iota
+	packFlate
+)
+
+type packEntry struct {
+	name        string
+	compression uint8
+	crc         uint32
+	offset      int64
+	storedSize  int64
+	size        int64
+	modTime     time.Time
+}
+
+func (entry *packEntry) info() fs.FileInfo {
+	return vfsFileInfo{name: path.Base(entry.name), size: entry.size, mode: 0444, modTime: entry.modTime}
+}
+
+// Reads a pack file, implements fs.FS so it can be used as a VFS layer
+type packReader struct {
+	file      *os.File
+	alignment uint32
+	entries   map[string]*packEntry
+	dirs      map[string][]fs.DirEntry
+}
+
+func openPack(name string) (*packReader, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	pack, err := readPack(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("Failed to read pack %s: %v", name, err)
+	}
+
+	return pack, nil
+}
+
+func readPack(file *os.File) (*packReader, error) {
+	header := make([]byte, packHeaderSize)
+	if _, err := file.ReadAt(header, 0); err != nil {
+		return nil, err
+	}
+
+	if string(header[0:8]) != packMagic {
+		return nil, errors.New("not a pack file")
+	}
+	if version := binary.LittleEndian.Uint32(header[8:]); version != packVersion {
+		return nil, fmt.Errorf("unsupported pack version %d", version)
+	}
+
+	pack := &packReader{
+		file:      file,
+		alignment: binary.LittleEndian.Uint32(header[12:]),
+		entries:   make(map[string]*packEntry),
+		dirs:      make(map[string][]fs.DirEntry),
+	}
+	if pack.alignment == 0 || pack.alignment&(pack.alignment-1) != 0 {
+		return nil, fmt.Errorf("alignment has to be a power of two, got %d", pack.alignment)
+	}
+
+	entryCount := binary.LittleEndian.Uint32(header[16:])
+	indexOffset := int64(binary.LittleEndian.Uint64(header[24:]))
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if indexOffset < packHeaderSize || indexOffset > info.Size() {
+		return nil, errors.New("index offset out of range")
+	}
+
+	index := make([]byte, info.Size()-indexOffset)
+	if _, err := file.ReadAt(index, indexOffset); err != nil {
+		return nil, err
+	}
+
+	reader := bytes.NewReader(index)
+	for i := uint32(0); i < entryCount; i++ {
+		entry, err := readPackEntry(reader, indexOffset, pack.alignment)
+		if err != nil {
+			return nil, fmt.Errorf("corrupt index: %v", err)
+		}
+		if _, ok := pack.entries[entry.name]; ok {
+			return nil, fmt.Errorf("corrupt index: %s is in it twice", entry.name)
+		}
+		pack.entries[entry.name] = entry
+	}
+
+	pack.buildDirs()
+
+	return pack, nil
+}
+
+// indexOffset is where the data section ends, every entry has to be inside of it and start at a multiple
+// of the alignment
+func readPackEntry(reader *bytes.Reader, indexOffset int64, alignment uint32) (*packEntry, error) {
+	var nameLength uint16
+	if err := binary.Read(reader, binary.LittleEndian, &nameLength); err != nil {
+		return nil, err
+	}
+
+	name := make([]byte, nameLength)
+	if _, err := io.ReadFull(reader, name); err != nil {
+		return nil, err
+	}
+
+	var record struct {
+		Compression uint8
+		Reserved    uint8
+		CRC         uint32
+		Offset      uint64
+		StoredSize  uint64
+		Size        uint64
+		ModTime     int64
+	}
+	if err := binary.Read(reader, binary.LittleEndian, &record); err != nil {
+		return nil, err
+	}
+
+	if !fs.ValidPath(string(name)) {
+		return nil, fmt.Errorf("invalid entry name %q", name)
+	}
+	if record.Compression != packStore && record.Compression != packFlate {
+		return nil, fmt.Errorf("entry %s has unknown compression %d", name, record.Compression)
+	}
+
+	// checked as unsigned before anything gets turned into an int64, so nothing can wrap around
+	dataEnd := uint64(indexOffset)
+	if record.Offset < packHeaderSize || record.Offset > dataEnd || record.StoredSize > dataEnd-record.Offset {
+		return nil, fmt.Errorf("entry %s points outside of the data section", name)
+	}
+	if record.Offset%uint64(alignment) != 0 {
+		return nil, fmt.Errorf("entry %s at %d isn't aligned to %d bytes", name, record.Offset, alignment)
+	}
+	if record.Size > packMaxEntrySize {
+		return nil, fmt.Errorf("entry %s is %d bytes, that's more than a pack entry can be", name, record.Size)
+	}
+	if record.Compression == packStore && record.Size != record.StoredSize {
+		return nil, fmt.Errorf("entry %s is stored but its size (%d) isn't its stored size (%d)", name, record.Size, record.StoredSize)
+	}
+
+	return &packEntry{
+		name:        string(name),
+		compression: record.Compression,
+		crc:         record.CRC,
+		offset:      int64(record.Offset),
+		storedSize:  int64(record.StoredSize),
+		size:        int64(record.Size),
+		modTime:     time.Unix(record.ModTime, 0),
+	}, nil
+}
+
+// the index only has files in it, directories are whatever is in between
+func (pack *packReader) buildDirs() {
+	seen := make(map[string]bool)
+
+	for name, entry := range pack.entries {
+		dir := path.Dir(name)
+		pack.dirs[dir] = append(pack.dirs[dir], fs.FileInfoToDirEntry(entry.info()))
+
+		for dir != "." {
+			parent := path.Dir(dir)
+			if !seen[dir] {
+				seen[dir] = true
+				pack.dirs[parent] = append(pack.dirs[parent], fs.FileInfoToDirEntry(packDirInfo(dir)))
+			}
+			dir = parent
+		}
+	}
+
+	if _, ok := pack.dirs["."]; !ok {
+		pack.dirs["."] = nil
+	}
+
+	for _, entries := range pack.dirs {
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].Name() < entries[j].Name()
+		})
+	}
+}
+
+func packDirInfo(name string) fs.FileInfo {
+	return vfsFileInfo{name: path.Base(name), mode: fs.ModeDir | 0555}
+}
+
+func (pack *packReader) Close() error {
+	return pack.file.Close()
+}
+
+func (pack *packReader) Stat(name string) (fs.FileInfo, error) {
+	if entry, ok := pack.entries[name]; ok {
+		return entry.info(), nil
+	}
+	if _, ok := pack.dirs[name]; ok {
+		return packDirInfo(name), nil
+	}
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+func (pack *packReader) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, ok := pack.dirs[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	return append([]fs.DirEntry(nil), entries...), nil
+}
+
+func (pack *packReader) ReadFile(name string) ([]byte, error) {
+	entry, ok := pack.entries[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	data := make([]byte, entry.size)
+	if _, err := io.ReadFull(pack.entryReader(entry), data); err != nil {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: err}
+	}
+
+	if crc32.ChecksumIEEE(data) != entry.crc {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: errors.New("crc mismatch, the pack is corrupt")}
+	}
+
+	return data, nil
+}
+
+func (pack *packReader) entryReader(entry *packEntry) io.Reader {
+	section := io.NewSectionReader(pack.file, entry.offset, entry.storedSize)
+	if entry.compression == packFlate {
+		return flate.NewReader(section)
+	}
+	return section
+}
+
+func (pack *packReader) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	if entry, ok := pack.entries[name]; ok {
+		// stored entries get read straight from the file, compressed ones get inflated up front
+		if entry.compression == packStore {
+			return &packFile{
+				SectionReader: io.NewSectionReader(pack.file, entry.offset, entry.storedSize),
+				entry:         entry,
+				checksum:      crc32.NewIEEE(),
+			}, nil
+		}
+
+		data, err := pack.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		return &memoryFile{Reader: bytes.NewReader(data), info: entry.info()}, nil
+	}
+
+	if entries, ok := pack.dirs[name]; ok {
+		return &memoryDir{info: packDirInfo(name), entries: append([]fs.DirEntry(nil), entries...)}, nil
+	}
+
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// The crc gets checked while the file is read from start to end, the read that gets to the end fails
+// when it doesn't match. ReadAt and reading after seeking around don't get checked
+type packFile struct {
+	*io.SectionReader
+	entry    *packEntry
+	checksum hash.Hash32
+	checked  int64 // how far the checksum got
+}
+
+func (file *packFile) Read(buffer []byte) (int, error) {
+	start, _ := file.Seek(0, io.SeekCurrent)
+	count, err := file.SectionReader.Read(buffer)
+
+	if start == file.checked {
+		file.checksum.Write(buffer[:count])
+		file.checked += int64(count)
+		if file.checked == file.Size() && file.checksum.Sum32() != file.entry.crc {
+			return count, &fs.PathError{Op: "read", Path: file.entry.name, Err: errors.New("crc mismatch, the pack is corrupt")}
+		}
+	}
+
+	return count, err
+}
+
+func (file *packFile) Stat() (fs.FileInfo, error) { return file.entry.info(), nil }
+func (file *packFile) Close() error               { return nil }
+
+type packSource struct {
+	name    string
+	data    []byte
+	modTime time.Time
+}
+
+type packCompression int
+
+const (
+	packCompressionAuto packCompression = iota
+	packCompressionStore
+	packCompressionFlate
+)
+
+// only bother compressing when it actually saves something, pngs and jpgs usually just get bigger
+func compressPackEntry(data []byte, mode packCompression) (uint8, []byte, error) {
+	if mode == packCompressionStore || len(data) == 0 {
+		return packStore, data, nil
+	}
+
+	var compressed bytes.Buffer
+	writer, err := flate.NewWriter(&compressed, flate.BestCompression)
+	if err != nil {
+		return 0, nil, err
+	}
+	if _, err := writer.Write(data); err != nil {
+		return 0, nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return 0, nil, err
+	}
+
+	if mode == packCompressionFlate || compressed.Len() < len(data)*9/10 {
+		return packFlate, compressed.Bytes(), nil
+	}
+	return packStore, data, nil
+}
+
+func writePack(writer io.WriteSeeker, sources []packSource, alignment uint32, mode packCompression) error {
+	if alignment == 0 || alignment&(alignment-1) != 0 {
+		return fmt.Errorf("alignment has to be a power of two, got %d", alignment)
+	}
+
+	if _, err := writer.Write(make([]byte, packHeaderSize)); err != nil {
+		return err
+	}
+	offset := int64(packHeaderSize)
+
+	pad := func() error {
+		padding := (int64(alignment) - offset%int64(alignment)) % int64(alignment)
+		if padding == 0 {
+			return nil
+		}
+		offset += padding
+		_, err := writer.Write(make([]byte, padding))
+		return err
+	}
+
+	var index bytes.Buffer
+	written := make(map[string]bool)
+	for _, source := range sources {
+		if len(source.name) > 0xffff || !fs.ValidPath(source.name) {
+			return fmt.Errorf("invalid asset name %q", source.name)
+		}
+		if written[source.name] {
+			return fmt.Errorf("%s is in the pack twice", source.name)
+		}
+		written[source.name] = true
+
+		compression, stored, err := compressPackEntry(source.data, mode)
+		if err != nil {
+			return err
+		}
+
+		if err := pad(); err != nil {
+			return err
+		}
+
+		binary.Write(&index, binary.LittleEndian, uint16(len(source.name)))
+		index.WriteString(source.name)
+		binary.Write(&index, binary.LittleEndian, struct {
+			Compression uint8
+			Reserved    uint8
+			CRC         uint32
+			Offset      uint64
+			StoredSize  uint64
+			Size        uint64
+			ModTime     int64
+		}{
+			Compression: compression,
+			CRC:         crc32.ChecksumIEEE(source.data),
+			Offset:      uint64(offset),
+			StoredSize:  uint64(len(stored)),
+			Size:        uint64(len(source.data)),
+			ModTime:     source.modTime.Unix(),
+		})
+
+		if _, err := writer.Write(stored); err != nil {
+			return err
+		}
+		offset += int64(len(stored))
+	}
+
+	if err := pad(); err != nil {
+		return err
+	}
+	indexOffset := offset
+	if _, err := writer.Write(index.Bytes()); err != nil {
+		return err
+	}
+
+	header := make([]byte, packHeaderSize)
+	copy(header, packMagic)
+	binary.LittleEndian.PutUint32(header[8:], packVersion)
+	binary.LittleEndian.PutUint32(header[12:], alignment)
+	binary.LittleEndian.PutUint32(header[16:], uint32(len(sources)))
+	binary.LittleEndian.PutUint64(header[24:], uint64(indexOffset))
+
+	if _, err := writer.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := writer.Write(header)
+	return err
+}
+
+// Collects everything under assets/ (manifest included, so the pack can be verified on its own)
+func collectPackSources(fsys fs.FS) ([]packSource, error) {
+	var sources []packSource
+
+	err := fs.WalkDir(fsys, "assets", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		sources = append(sources, packSource{name: name, data: data, modTime: info.ModTime()})
+		return nil
+	})
+
+	return sources, err
+}
+
+// pack build [-align n] [-compression auto|store|flate] <output> [dir]
+// pack list <file>
+func runPackCommand(args []string) int {
+	if len(args) < 1 || (args[0] != "build" && args[0] != "list") {
+		fmt.Fprintln(os.Stderr, "usage: pack build [-align n] [-compression auto|store|flate] <output> [dir]\n       pack list <file>")
+		return 2
+	}
+
+	if args[0] == "list" {
+		if len(args) != 2 {
+			fmt.Fprintln(os.Stderr, "usage: pack list <file>")
+			return 2
+		}
+		return listPack(args[1])
+	}
+
+	flags := flag.NewFlagSet("pack build", flag.ContinueOnError)
+	alignment := flags.Uint("align", packDefaultAlignment, "alignment of every entry in bytes, power of two")
+	compression := flags.String("compression", "auto", "auto only compresses entries when it saves at least 10%, store and flate force it")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if flags.NArg() < 1 || flags.NArg() > 2 {
+		fmt.Fprintln(os.Stderr, "usage: pack build [-align n] [-compression auto|store|flate] <output> [dir]")
+		return 2
+	}
+
+	modes := map[string]packCompression{"auto": packCompressionAuto, "store": packCompressionStore, "flate": packCompressionFlate}
+	mode, ok := modes[*compression]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown compression %q\n", *compression)
+		return 2
+	}
+
+	directory := "."
+	if flags.NArg() == 2 {
+		directory = flags.Arg(1)
+	}
+
+	sources, err := collectPackSources(os.DirFS(directory))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	output, err := os.Create(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	err = writePack(output, sources, uint32(*alignment), mode)
+	if closeErr := output.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Remove(flags.Arg(0))
+		return 1
+	}
+
+	fmt.Printf("Packed %d assets into %s\n", len(sources), flags.Arg(0))
+	return listPack(flags.Arg(0))
+}
+
+func listPack(name string) int {
+	pack, err := openPack(name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer pack.Close()
+
+	names := make([]string, 0, len(pack.entries))
+	for entryName := range pack.entries {
+		names = append(names, entryName)
+	}
+	sort.Strings(names)
+
+	compressionNames := map[uint8]string{packStore: "store", packFlate: "flate"}
+	for _, entryName := range names {
+		entry := pack.entries[entryName]
+		fmt.Printf("  %-28s %-6s %10d -> %-10d @ %d\n", entry.name, compressionNames[entry.compression], entry.size, entry.storedSize, entry.offset)
+	}
+
+	return 0
+}
+
+// assets.pack in the same directory as the executable
+func sidecarPack() (string, bool) {
+	executable, err := os.Executable()
+	if err != nil {
+		return "", false
+	}
+
+	name := filepath.Join(filepath.Dir(executable), "assets.pack")
+	if _, err := os.Stat(name); err != nil {
+		return "", false
+	}
+	return name, true
+}
+
+func isPackFile(name string) bool {
+	return strings.EqualFold(path.Ext(name), ".pack")
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func writeTestPack(t *testing.T, sources []packSource, alignment uint32, mode packCompression) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "test.pack")
+	file, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := writePack(file, sources, alignment, mode); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func testPackSources() []packSource {
+	modTime := time.Unix(1600000000, 0)
+	return []packSource{
+		{name: "assets/frag.glsl", data: []byte(strings.Repeat("void main() {}\n", 100)), modTime: modTime},
+		{name: "assets/models/burger.obj", data: []byte("v 0 0 0\nv 1 0 0\nv 0 1 0\n"), modTime: modTime},
+		{name: "assets/empty.txt", data: nil, modTime: modTime},
+		{name: "assets/noise.bin", data: []byte{0x8f, 0x12, 0x00, 0xff, 0x37, 0x91, 0x4c}, modTime: modTime},
+	}
+}
+
+func TestPackRoundTrip(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		mode packCompression
+		want uint8
+	}{{"store", packCompressionStore, packStore}, {"flate", packCompressionFlate, packFlate}} {
+		sources := testPackSources()
+		pack, err := openPack(writeTestPack(t, sources, 64, test.mode))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		for _, source := range sources {
+			entry := pack.entries[source.name]
+			if entry == nil {
+				t.Fatalf("%s: %s isn't in the pack", test.name, source.name)
+			}
+			if entry.offset%64 != 0 {
+				t.Errorf("%s: %s starts at %d", test.name, source.name, entry.offset)
+			}
+			if len(source.data) > 0 && entry.compression != test.want {
+				t.Errorf("%s: %s has compression %d", test.name, source.name, entry.compression)
+			}
+
+			data, err := pack.ReadFile(source.name)
+			if err != nil || !bytes.Equal(data, source.data) {
+				t.Errorf("%s: ReadFile(%s) gave %q, %v", test.name, source.name, data, err)
+			}
+
+			file, err := pack.Open(source.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err = io.ReadAll(file)
+			file.Close()
+			if err != nil || !bytes.Equal(data, source.data) {
+				t.Errorf("%s: reading %s after Open gave %q, %v", test.name, source.name, data, err)
+			}
+		}
+
+		// what every fs.FS has to do, directories included
+		if err := fstest.TestFS(pack, "assets/frag.glsl", "assets/models/burger.obj", "assets/empty.txt", "assets/noise.bin"); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+		pack.Close()
+	}
+}
+
+// index of the first entry, going by the header
+func packIndex(t *testing.T, data []byte) int {
+	t.Helper()
+	return int(binary.LittleEndian.Uint64(data[24:]))
+}
+
+func openModifiedPack(t *testing.T, sources []packSource, modify func(data []byte)) error {
+	t.Helper()
+	name := writeTestPack(t, sources, 16, packCompressionStore)
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	modify(data)
+	if err := os.WriteFile(name, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	pack, err := openPack(name)
+	if err == nil {
+		pack.Close()
+	}
+	return err
+}
+
+func TestPackCorruptIndex(t *testing.T) {
+	// the first record: name length, name, compression, reserved, crc, offset, stored size, size, mod time
+	const name = len("assets/frag.glsl")
+	record := func(data []byte) []byte {
+		return data[packIndex(t, data)+2+name:]
+	}
+
+	tests := []struct {
+		name   string
+		modify func(data []byte)
+		want   string
+	}{
+		{"bad magic", func(data []byte) { data[0] = 'X' }, "not a pack file"},
+		{"alignment of 0", func(data []byte) { binary.LittleEndian.PutUint32(data[12:], 0) }, "alignment"},
+		{"alignment of 24", func(data []byte) { binary.LittleEndian.PutUint32(data[12:], 24) }, "alignment"},
+		{"index past the end", func(data []byte) { binary.LittleEndian.PutUint64(data[24:], 1<<40) }, "index offset"},
+		{"too many entries", func(data []byte) { binary.LittleEndian.PutUint32(data[16:], 5) }, "corrupt index"},
+		{"unknown compression", func(data []byte) { record(data)[0] = 7 }, "compression"},
+		{"offset in the header", func(data []byte) { binary.LittleEndian.PutUint64(record(data)[6:], 16) }, "outside"},
+		{"offset past the data", func(data []byte) { binary.LittleEndian.PutUint64(record(data)[6:], 1<<62) }, "outside"},
+		{"stored size wrapping around", func(data []byte) { binary.LittleEndian.PutUint64(record(data)[14:], ^uint64(0)-8) }, "outside"},
+		{"unaligned offset", func(data []byte) { binary.LittleEndian.PutUint64(record(data)[6:], packHeaderSize+4) }, "aligned"},
+		{"huge size", func(data []byte) { binary.LittleEndian.PutUint64(record(data)[22:], 1<<50) }, "more than"},
+		{"stored with a different size", func(data []byte) { binary.LittleEndian.PutUint64(record(data)[22:], 3) }, "stored"},
+		{"name outside of the pack", func(data []byte) { copy(data[packIndex(t, data)+2:], "../ets") }, "invalid entry name"},
+	}
+
+	for _, test := range tests {
+		err := openModifiedPack(t, testPackSources(), test.modify)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got %v, want an error about %q", test.name, err, test.want)
+		}
+	}
+
+	// the second name gets renamed to the first one
+	sources := []packSource{{name: "assets/a.txt", data: []byte("a")}, {name: "assets/b.txt", data: []byte("b")}}
+	err := openModifiedPack(t, sources, func(data []byte) {
+		index := data[packIndex(t, data):]
+		copy(index[bytes.Index(index, []byte("assets/b.txt")):], "assets/a.txt")
+	})
+	if err == nil || !strings.Contains(err.Error(), "twice") {
+		t.Errorf("the same name twice gave %v", err)
+	}
+}
+
+func TestPackBadCRC(t *testing.T) {
+	for _, mode := range []packCompression{packCompressionStore, packCompressionFlate} {
+		name := writeTestPack(t, testPackSources(), 16, mode)
+		data, _ := os.ReadFile(name)
+
+		// flip a bit of the last entry, it's stored in one pack and deflated in the other
+		pack, err := openPack(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry := pack.entries["assets/noise.bin"]
+		pack.Close()
+		data[entry.offset+3] ^= 0x10
+		os.WriteFile(name, data, 0644)
+
+		pack, err = openPack(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := pack.ReadFile("assets/noise.bin"); err == nil || !strings.Contains(err.Error(), "crc") {
+			t.Errorf("ReadFile of a corrupt entry gave %v", err)
+		}
+		file, err := pack.Open("assets/noise.bin")
+		if err == nil {
+			_, err = io.ReadAll(file)
+			file.Close()
+		}
+		if err == nil || !strings.Contains(err.Error(), "crc") {
+			t.Errorf("reading a corrupt entry after Open gave %v", err)
+		}
+
+		// the others are still fine
+		if _, err := fs.ReadFile(pack, "assets/frag.glsl"); err != nil {
+			t.Error(err)
+		}
+		pack.Close()
+	}
+}
+
+func TestWritePackRejects(t *testing.T) {
+	sources := testPackSources()
+	var buffer writeSeekBuffer
+	if err := writePack(&buffer, append(sources, sources[0]), 16, packCompressionAuto); err == nil || !strings.Contains(err.Error(), "twice") {
+		t.Errorf("packing the same name twice gave %v", err)
+	}
+	if err := writePack(&buffer, sources, 12, packCompressionAuto); err == nil {
+		t.Error("packing with an alignment of 12 worked")
+	}
+	if err := writePack(&buffer, []packSource{{name: "/etc/passwd"}}, 16, packCompressionAuto); err == nil {
+		t.Error("packing an absolute path worked")
+	}
+}
+
+type writeSeekBuffer struct {
+	data     []byte
+	position int
+}
+
+func (buffer *writeSeekBuffer) Write(data []byte) (int, error) {
+	if end := buffer.position + len(data); end > len(buffer.data) {
+		buffer.data = append(buffer.data, make([]byte, end-len(buffer.data))...)
+	}
+	copy(buffer.data[buffer.position:], data)
+	buffer.position += len(data)
+	return len(data), nil
+}
+
+func (buffer *writeSeekBuffer) Seek(offset int64, whence int) (int64, error) {
+	buffer.position = int(offset)
+	return offset, nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"main/src/util"
+	"math"
+	"math/rand"
+	"path"
+	"strings"
+)
+
+// Textures made from code instead of files. They come out like decoded images do, bottom row first
+// without mipmaps, so v goes up the texture the same way texture coordinates do. Colors are sRGB
+
+var (
+	colorBlack   = [4]uint8{0, 0, 0, 0xff}
+	colorWhite   = [4]uint8{0xff, 0xff, 0xff, 0xff}
+	colorMagenta = [4]uint8{0xff, 0, 0xff, 0xff}
+)
+
+func newProceduralTexture(width int, height int, pixel func(x int, y int) [4]uint8) *textureData {
+	data := &textureData{width: int32(width), height: int32(height), format: textureRGBA, srgb: true}
+	data.pixels = make([]uint8, width*height*4)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			color := pixel(x, y)
+			copy(data.pixels[(y*width+x)*4:], color[:])
+		}
+	}
+	return data
+}
+
+func solidTexture(width int, height int, color [4]uint8) *textureData {
+	return newProceduralTexture(width, height, func(x int, y int) [4]uint8 {
+		return color
+	})
+}
+
+// Squares of cell pixels, the bottom left one is first
+func checkerTexture(width int, height int, cell int, first [4]uint8, second [4]uint8) *textureData {
+	cell = maxInt(cell, 1)
+	return newProceduralTexture(width, height, func(x int, y int) [4]uint8 {
+		if (x/cell+y/cell)%2 == 0 {
+			return first
+		}
+		return second
+	})
+}
+
+// For checking texture coordinates, u goes into red and v into green with grid lines every 1/cells.
+// Every other line is brighter so it's easy to tell where 0.5 is
+func uvGridTexture(size int, cells int) *textureData {
+	cells = maxInt(cells, 1)
+	return newProceduralTexture(size, size, func(x int, y int) [4]uint8 {
+		cellX, cellY := x*cells%size, y*cells%size
+		if cellX < cells || cellY < cells {
+			if (x*cells/size)%2 == 0 && cellX < cells || (y*cells/size)%2 == 0 && cellY < cells {
+				return colorWhite
+			}
+			return [4]uint8{0x80, 0x80, 0x80, 0xff}
+		}
+		u := float64(x) / float64(maxInt(size-1, 1))
+		v := float64(y) / float64(maxInt(size-1, 1))
+		return [4]uint8{uint8(u*0xbf + 0.5), uint8(v*0xbf + 0.5), 0x40, 0xff}
+	})
+}
+
+// Goes from one color at the left (or the bottom when it's vertical) to the other, mixed in linear
+// space so the middle doesn't come out too dark
+func gradientTexture(width int, height int, from [4]uint8, to [4]uint8, vertical bool) *textureData {
+	steps := width
+	if vertical {
+		steps = height
+	}
+	colors := make([][4]uint8, steps)
+	for i := range colors {
+		t := float64(i) / float64(maxInt(steps-1, 1))
+		for c := 0; c < 3; c++ {
+			linear := srgbToLinear(float64(from[c])/255)*(1-t) + srgbToLinear(float64(to[c])/255)*t
+			colors[i][c] = quantize(linearToSRGB(linear))
+		}
+		colors[i][3] = quantize((float64(from[3])*(1-t) + float64(to[3])*t) / 255)
+	}
+
+	return newProceduralTexture(width, height, func(x int, y int) [4]uint8 {
+		if vertical {
+			return colors[y]
+		}
+		return colors[x]
+	})
+}
+
+type noiseKind int
+
+const (
+	valueNoise  noiseKind = iota // random values at the lattice points, blocky
+	perlinNoise                  // random gradients at the lattice points, smoother and without the blocks
+)
+
+// Fractal noise in a gray texture, which isn't sRGB since noise is usually data (heights, masks,
+// roughness). frequency is how many lattice cells go across the first octave, every octave after
+// that has twice as many at half the strength. Whole cells fit into the texture so it tiles
+func noiseTexture(width int, height int, kind noiseKind, frequency int, octaves int, seed int64) *textureData {
+	frequency = maxInt(frequency, 1)
+	octaves = maxInt(octaves, 1)
+
+	random := rand.New(rand.NewSource(seed))
+	permutation := random.Perm(256)
+	hash := func(x int, y int) int {
+		return permutation[(permutation[x&255]+y)&255]
+	}
+	values := make([]float64, 256)
+	for i := range values {
+		values[i] = random.Float64()*2 - 1
+	}
+
+	noise := func(x float64, y float64, period int) float64 {
+		x0, y0 := int(math.Floor(x)), int(math.Floor(y))
+		fx, fy := x-float64(x0), y-float64(y0)
+
+		corner := func(cornerX int, cornerY int) float64 {
+			h := hash(((x0+cornerX)%period+period)%period, ((y0+cornerY)%period+period)%period)
+			if kind == valueNoise {
+				return values[h]
+			}
+			// gradients are unit vectors pointing in one of 8 directions
+			angle := float64(h%8) * math.Pi / 4
+			return math.Cos(angle)*(fx-float64(cornerX)) + math.Sin(angle)*(fy-float64(cornerY))
+		}
+
+		// quintic fade, so the second derivative is continuous across cells too
+		fade := func(t float64) float64 {
+			return t * t * t * (t*(t*6-15) + 10)
+		}
+		u, v := fade(fx), fade(fy)
+		bottom := corner(0, 0)*(1-u) + corner(1, 0)*u
+		top := corner(0, 1)*(1-u) + corner(1, 1)*u
+		return bottom*(1-v) + top*v
+	}
+
+	data := &textureData{width: int32(width), height: int32(height), format: textureGray}
+	data.pixels = make([]uint8, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var sum, amplitude, total float64 = 0, 1, 0
+			period := frequency
+			for octave := 0; octave < octaves; octave++ {
+				sum += amplitude * noise(float64(x)*float64(period)/float64(width), float64(y)*float64(period)/float64(height), period)
+				total += amplitude
+				amplitude /= 2
+				period *= 2
+			}
+
+			// perlin noise stays within about ±0.7, it gets stretched a bit so it uses most of the range
+			value := sum / total
+			if kind == perlinNoise {
+				value *= 1.4
+			}
+			data.pixels[y*width+x] = uint8(math.Max(0, math.Min(1, value*0.5+0.5))*0xff + 0.5)
+		}
+	}
+	return data
+}
+
+// What textures that can't be loaded get replaced with, magenta and black squares that nobody can miss.
+// It takes the shape the asset would have had, cubemaps and arrays stay cubemaps and arrays so whatever
+// uses the texture keeps working
+func missingTexture(name string, descriptor TextureDescriptor) *textureData {
+	const size, cell = 64, 8
+
+	faces := 0
+	switch {
+	case descriptor.Cubemap != "" || strings.EqualFold(path.Ext(name), ".cube"):
+		faces = 6
+	case strings.EqualFold(path.Ext(name), ".array"):
+		faces = 1
+	}
+
+	if faces == 0 {
+		data := checkerTexture(size, size, cell, colorMagenta, colorBlack)
+		if descriptor.hasMipmaps() {
+			options := descriptor.mipmapOptions()
+			options.srgb = data.srgb
+			data.mipmaps = buildMipmaps(data, options)
+		}
+		return data
+	}
+
+	checker := make([]*textureData, faces)
+	for i := range checker {
+		checker[i] = checkerTexture(size, size, cell, colorMagenta, colorBlack)
+	}
+	stacked := stackFaces(checker, descriptor)
+	for _, level := range append([]*textureData{stacked}, stacked.mipmaps...) {
+		if faces == 6 {
+			level.cubemap = true
+		} else {
+			level.layers = faces
+		}
+	}
+	return stacked
+}
+
+// Called when a texture fails to load, warns about it and returns the texture to use instead
+func missingTextureFallback(name string, descriptor TextureDescriptor, err error) (*textureData, TextureDescriptor) {
+	util.ThrowWarning(fmt.Sprintf("Using a placeholder for %s: %v", name, err))
+	descriptor.MagFilter = "nearest"
+	return missingTexture(name, descriptor), descriptor
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func texel(data *textureData, x int, y int) [4]uint8 {
+	var color [4]uint8
+	copy(color[:], data.pixels[(y*int(data.width)+x)*4:])
+	return color
+}
+
+func TestSolidAndCheckerTextures(t *testing.T) {
+	solid := solidTexture(3, 2, colorMagenta)
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 3; x++ {
+			if got := texel(solid, x, y); got != colorMagenta {
+				t.Errorf("solid texel (%d, %d) is %v", x, y, got)
+			}
+		}
+	}
+
+	checker := checkerTexture(8, 8, 2, colorBlack, colorWhite)
+	tests := []struct {
+		x, y int
+		want [4]uint8
+	}{{0, 0, colorBlack}, {1, 1, colorBlack}, {2, 0, colorWhite}, {0, 2, colorWhite}, {2, 2, colorBlack}, {7, 6, colorBlack}, {7, 5, colorWhite}}
+	for _, test := range tests {
+		if got := texel(checker, test.x, test.y); got != test.want {
+			t.Errorf("checker texel (%d, %d) is %v, want %v", test.x, test.y, got, test.want)
+		}
+	}
+}
+
+func TestUVGridTexture(t *testing.T) {
+	grid := uvGridTexture(64, 4)
+	gray := [4]uint8{0x80, 0x80, 0x80, 0xff}
+
+	// lines every 16 texels, the ones at 0 and 0.5 are the bright ones. Row 5 has v = 5/63, 15 in green
+	lines := map[int][4]uint8{0: colorWhite, 16: gray, 32: colorWhite, 48: gray}
+	for x := 0; x < 64; x++ {
+		want, isLine := lines[x]
+		if !isLine {
+			want = [4]uint8{uint8(float64(x)/63*0xbf + 0.5), 15, 0x40, 0xff}
+		}
+		if got := texel(grid, x, 5); got != want {
+			t.Errorf("texel (%d, 5) is %v, want %v", x, got, want)
+		}
+		// the same lines go across
+		if got, want := texel(grid, 5, x), lines[x]; isLine && got != want {
+			t.Errorf("texel (5, %d) is %v, want %v", x, got, want)
+		}
+	}
+
+	if got := texel(grid, 63, 63); got != [4]uint8{0xbf, 0xbf, 0x40, 0xff} {
+		t.Errorf("the top right texel is %v", got)
+	}
+}
+
+func TestGradientTexture(t *testing.T) {
+	// halfway between black and white is half the light, 188 in sRGB
+	gradient := gradientTexture(3, 2, colorBlack, colorWhite, false)
+	for y := 0; y < 2; y++ {
+		for x, want := range []uint8{0, 188, 255} {
+			if got := texel(gradient, x, y); got != [4]uint8{want, want, want, 0xff} {
+				t.Errorf("texel (%d, %d) is %v, want gray %d", x, y, got, want)
+			}
+		}
+	}
+
+	vertical := gradientTexture(1, 3, [4]uint8{0xff, 0, 0, 0}, [4]uint8{0, 0, 0xff, 0xff}, true)
+	if got := texel(vertical, 0, 1); got != [4]uint8{188, 0, 188, 128} {
+		t.Errorf("middle of the vertical gradient is %v", got)
+	}
+}
+
+func TestNoiseTexture(t *testing.T) {
+	for _, kind := range []noiseKind{valueNoise, perlinNoise} {
+		const size = 64
+		noise := noiseTexture(size, size, kind, 4, 3, 1)
+
+		if again := noiseTexture(size, size, kind, 4, 3, 1); !bytes.Equal(noise.pixels, again.pixels) {
+			t.Errorf("noise %d: the same seed gives different noise", kind)
+		}
+		if other := noiseTexture(size, size, kind, 4, 3, 2); bytes.Equal(noise.pixels, other.pixels) {
+			t.Errorf("noise %d: different seeds give the same noise", kind)
+		}
+
+		// tiling: going over the edge can't jump more than going from one texel to the next anywhere else
+		difference := func(a uint8, b uint8) int {
+			if a > b {
+				return int(a - b)
+			}
+			return int(b - a)
+		}
+		steepest := 0
+		for y := 0; y < size; y++ {
+			for x := 0; x+1 < size; x++ {
+				steepest = maxInt(steepest, difference(noise.pixels[y*size+x], noise.pixels[y*size+x+1]))
+				steepest = maxInt(steepest, difference(noise.pixels[x*size+y], noise.pixels[(x+1)*size+y]))
+			}
+		}
+		for i := 0; i < size; i++ {
+			if jump := difference(noise.pixels[i*size+size-1], noise.pixels[i*size]); jump > steepest {
+				t.Errorf("noise %d: row %d jumps by %d across the edge, %d at most inside", kind, i, jump, steepest)
+			}
+			if jump := difference(noise.pixels[(size-1)*size+i], noise.pixels[i]); jump > steepest {
+				t.Errorf("noise %d: column %d jumps by %d across the edge, %d at most inside", kind, i, jump, steepest)
+			}
+		}
+
+		low, high := uint8(255), uint8(0)
+		for _, value := range noise.pixels {
+			if value < low {
+				low = value
+			}
+			if value > high {
+				high = value
+			}
+		}
+		if high-low < 128 {
+			t.Errorf("noise %d only goes from %d to %d", kind, low, high)
+		}
+	}
+}
@@ -0,0 +1,446 @@
+package main
+
+import (
+	"fmt"
+	"main/src/util"
+	"math"
+	"strings"
+
+	gl "github.com/go-gl/gl/v4.6-core/gl"
+
+	glm "github.com/go-gl/mathgl/mgl32"
+)
+
+// A linked shader program. Uniforms get looked up by name, the locations and types come from the
+// program itself when it's linked so setters can check that the value fits. Values get uploaded with
+// ProgramUniform, the program doesn't have to be in use, and setting a uniform to what it already is
+// doesn't upload anything
+type Program struct {
+	Handle uint32
+
+	uniforms   map[string]*uniform
+	reflection ProgramReflection
+	compute    bool
+}
+
+type uniform struct {
+	location int32
+	glType   uint32 // 0 when the program doesn't have it
+
+	// what was uploaded last, in the bits it was uploaded as
+	value [16]uint32
+	set   bool
+
+	warned bool
+}
+
+// Every kind of shader a program can be made of, in pipeline order
+var shaderStages = []struct {
+	glType uint32
+	name   string
+	define string // defined in shaders that go through the preprocessor
+}{
+	{gl.VERTEX_SHADER, "vertex", "VERTEX_SHADER"},
+	{gl.TESS_CONTROL_SHADER, "tessellation control", "TESS_CONTROL_SHADER"},
+	{gl.TESS_EVALUATION_SHADER, "tessellation evaluation", "TESS_EVALUATION_SHADER"},
+	{gl.GEOMETRY_SHADER, "geometry", "GEOMETRY_SHADER"},
+	{gl.FRAGMENT_SHADER, "fragment", "FRAGMENT_SHADER"},
+	{gl.COMPUTE_SHADER, "compute", "COMPUTE_SHADER"},
+}
+
+func shaderStageName(shaderType uint32) string {
+	for _, stage := range shaderStages {
+		if stage.glType == shaderType {
+			return stage.name
+		}
+	}
+	return fmt.Sprintf("shader type 0x%x", shaderType)
+}
+
+// Collects the stages of a program, then compiles and links them all at once. Stages are either shader
+// assets that go through the preprocessor (with the stage's define, like VERTEX_SHADER) or sources
+// that get compiled as they are
+type ProgramBuilder struct {
+	preprocessor *shaderPreprocessor
+	stages       []programStage
+}
+
+type programStage struct {
+	glType uint32
+	name   string // asset name, empty when it's a source
+	source string
+}
+
+// preprocessor can be nil when every stage is a source
+func NewProgramBuilder(preprocessor *shaderPreprocessor) *ProgramBuilder {
+	return &ProgramBuilder{preprocessor: preprocessor}
+}
+
+// Adds a stage from a shader asset
+func (builder *ProgramBuilder) Stage(shaderType uint32, name string) *ProgramBuilder {
+	builder.stages = append(builder.stages, programStage{glType: shaderType, name: name})
+	return builder
+}
+
+// Adds a stage from source, which needs its own #version and its \x00 at the end
+func (builder *ProgramBuilder) StageSource(shaderType uint32, source string) *ProgramBuilder {
+	builder.stages = append(builder.stages, programStage{glType: shaderType, source: source})
+	return builder
+}
+
+// Checks that the stages make a program that can link. Compute shaders go alone, everything else
+// needs a vertex shader, and a tessellation control shader is useless without an evaluation one.
+// Leaving out the fragment shader is fine (transform feedback, depth only passes), and so is leaving
+// out the control shader, the patch size and tessellation levels then come from glPatchParameter
+func validateShaderStages(shaderTypes []uint32) error {
+	if len(shaderTypes) == 0 {
+		return fmt.Errorf("A program needs at least one shader")
+	}
+
+	has := map[uint32]bool{}
+	for _, shaderType := range shaderTypes {
+		known := false
+		for _, stage := range shaderStages {
+			known = known || stage.glType == shaderType
+		}
+		if !known {
+			return fmt.Errorf("Unknown shader type 0x%x", shaderType)
+		}
+		if has[shaderType] {
+			return fmt.Errorf("A program can only have one %s shader", shaderStageName(shaderType))
+		}
+		has[shaderType] = true
+	}
+
+	switch {
+	case has[gl.COMPUTE_SHADER] && len(shaderTypes) > 1:
+		return fmt.Errorf("Compute shaders can't be linked with other stages")
+	case has[gl.COMPUTE_SHADER]:
+		return nil
+	case !has[gl.VERTEX_SHADER]:
+		return fmt.Errorf("A program without a compute shader needs a vertex shader")
+	case has[gl.TESS_CONTROL_SHADER] && !has[gl.TESS_EVALUATION_SHADER]:
+		return fmt.Errorf("A tessellation control shader needs a tessellation evaluation shader")
+	}
+	return nil
+}
+
+func (builder *ProgramBuilder) Build() (*Program, error) {
+	shaderTypes := make([]uint32, len(builder.stages))
+	for i, stage := range builder.stages {
+		shaderTypes[i] = stage.glType
+	}
+	if err := validateShaderStages(shaderTypes); err != nil {
+		return nil, err
+	}
+
+	sources := make([]*shaderSource, len(builder.stages))
+	for i, stage := range builder.stages {
+		if stage.name == "" {
+			sources[i] = &shaderSource{text: strings.TrimSuffix(stage.source, "\x00")}
+			continue
+		}
+		source, err := builder.preprocess(stage)
+		if err != nil {
+			return nil, err
+		}
+		sources[i] = source
+	}
+	if err := builder.checkInterfaces(sources); err != nil {
+		util.ThrowWarning(err.Error())
+	}
+
+	var shaders []uint32
+	defer func() {
+		for _, shader := range shaders {
+			gl.DeleteShader(shader)
+		}
+	}()
+
+	for i, stage := range builder.stages {
+		shader, err := compileShader(sources[i].text+"\x00", stage.glType)
+		if err != nil {
+			return nil, fmt.Errorf("%s", sources[i].translateLog(err.Error()))
+		}
+		shaders = append(shaders, shader)
+	}
+
+	program, err := linkProgram(shaders...)
+	if err != nil {
+		return nil, err
+	}
+	program.compute = shaderTypes[0] == gl.COMPUTE_SHADER
+	return program, nil
+}
+
+func (builder *ProgramBuilder) preprocess(stage programStage) (*shaderSource, error) {
+	if builder.preprocessor == nil {
+		return nil, fmt.Errorf("Can't load %s without a shader preprocessor", stage.name)
+	}
+
+	define := ""
+	for _, known := range shaderStages {
+		if known.glType == stage.glType {
+			define = known.define
+		}
+	}
+	return builder.preprocessor.load(stage.name, map[string]string{define: "1"})
+}
+
+// Checks the fragment shader's inputs against the outputs of the stage right before it, before the
+// driver gets to complain about it at link time (or not at all). The other stages take their inputs as
+// arrays, one element per vertex, so those are left to the driver. The check doesn't know about #if and
+// #ifdef, declarations that get compiled out still count, so it can only warn and linking decides
+func (builder *ProgramBuilder) checkInterfaces(sources []*shaderSource) error {
+	fragment, previous := -1, -1
+	for _, known := range shaderStages {
+		for i, stage := range builder.stages {
+			switch {
+			case stage.glType != known.glType:
+			case stage.glType == gl.FRAGMENT_SHADER:
+				fragment = i
+			case fragment < 0 && stage.glType != gl.COMPUTE_SHADER:
+				previous = i
+			}
+		}
+	}
+	if fragment < 0 || previous < 0 {
+		return nil
+	}
+	return checkStageInterface(shaderStageName(builder.stages[previous].glType), sources[previous], "fragment", sources[fragment])
+}
+
+// Compiles and links a program from vertex and fragment shader sources, which need their \x00 at the end
+func NewProgram(vertexShaderSource string, fragmentShaderSource string) (*Program, error) {
+	return NewProgramBuilder(nil).
+		StageSource(gl.VERTEX_SHADER, vertexShaderSource).
+		StageSource(gl.FRAGMENT_SHADER, fragmentShaderSource).
+		Build()
+}
+
+// Like NewProgram, but the sources are shader assets that go through the preprocessor first
+func LoadProgram(preprocessor *shaderPreprocessor, vertexShaderName string, fragmentShaderName string) (*Program, error) {
+	return NewProgramBuilder(preprocessor).
+		Stage(gl.VERTEX_SHADER, vertexShaderName).
+		Stage(gl.FRAGMENT_SHADER, fragmentShaderName).
+		Build()
+}
+
+func linkProgram(shaders ...uint32) (*Program, error) {
+	handle := gl.CreateProgram()
+	for _, shader := range shaders {
+		gl.AttachShader(handle, shader)
+	}
+
+	gl.LinkProgram(handle)
+	gl.ValidateProgram(handle)
+
+	var status int32
+	gl.GetProgramiv(handle, gl.LINK_STATUS, &status)
+	if status == gl.FALSE {
+		var logLength int32
+		gl.GetProgramiv(handle, gl.INFO_LOG_LENGTH, &logLength)
+
+		log := strings.Repeat("\x00", int(logLength+1))
+		gl.GetProgramInfoLog(handle, logLength, nil, gl.Str(log))
+
+		gl.DeleteProgram(handle)
+		return nil, fmt.Errorf("Failed to link program: %v", log)
+	}
+
+	program := &Program{Handle: handle, uniforms: make(map[string]*uniform), reflection: reflectProgram(handle)}
+
+	// arrays can be set by either name, with or without the [0]
+	for _, variable := range program.reflection.Uniforms {
+		entry := &uniform{location: variable.Location, glType: variable.Type}
+		program.uniforms[variable.Name] = entry
+		if variable.Size > 1 {
+			program.uniforms[variable.Name+"[0]"] = entry
+		}
+	}
+	return program, nil
+}
+
+// Everything the program takes, from when it was linked
+func (program *Program) Reflection() ProgramReflection {
+	return program.reflection
+}
+
+func (program *Program) Use() {
+	gl.UseProgram(program.Handle)
+}
+
+func (program *Program) Delete() {
+	gl.DeleteProgram(program.Handle)
+}
+
+// Whether the linked program has the uniform, the compiler throws away the ones that don't get used
+func (program *Program) HasUniform(name string) bool {
+	entry, ok := program.uniforms[name]
+	return ok && entry.glType != 0
+}
+
+// Finds a uniform and checks that it's one of the given types. A uniform that isn't there isn't an
+// error, it only gets a warning the first time and setting it does nothing. nil means there's nothing
+// to set
+func (program *Program) lookup(name string, wanted string, glTypes ...uint32) (*uniform, error) {
+	entry, ok := program.uniforms[name]
+	if !ok {
+		entry = &uniform{location: -1}
+		program.uniforms[name] = entry
+	}
+
+	if entry.glType == 0 {
+		if !entry.warned {
+			util.ThrowWarning("Could not find location of uniform: " + name)
+			entry.warned = true
+		}
+		return nil, nil
+	}
+
+	for _, glType := range glTypes {
+		if entry.glType == glType {
+			return entry, nil
+		}
+	}
+	return nil, fmt.Errorf("Uniform %s is a %s, it can't be set to a %s", name, glslTypeName(entry.glType), wanted)
+}
+
+// Remembers the value and says whether it has to be uploaded
+func (entry *uniform) changed(value ...uint32) bool {
+	if entry.set && equalBits(entry.value[:len(value)], value) {
+		return false
+	}
+	copy(entry.value[:], value)
+	entry.set = true
+	return true
+}
+
+func equalBits(a []uint32, b []uint32) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func floatBits(values ...float32) []uint32 {
+	bits := make([]uint32, len(values))
+	for i, value := range values {
+		bits[i] = math.Float32bits(value)
+	}
+	return bits
+}
+
+func (program *Program) SetInt(name string, value int32) error {
+	entry, err := program.lookup(name, "int", gl.INT)
+	if entry != nil && entry.changed(uint32(value)) {
+		gl.ProgramUniform1i(program.Handle, entry.location, value)
+	}
+	return err
+}
+
+func (program *Program) SetBool(name string, value bool) error {
+	entry, err := program.lookup(name, "bool", gl.BOOL)
+	bit := int32(0)
+	if value {
+		bit = 1
+	}
+	if entry != nil && entry.changed(uint32(bit)) {
+		gl.ProgramUniform1i(program.Handle, entry.location, bit)
+	}
+	return err
+}
+
+func (program *Program) SetFloat(name string, value float32) error {
+	entry, err := program.lookup(name, "float", gl.FLOAT)
+	if entry != nil && entry.changed(floatBits(value)...) {
+		gl.ProgramUniform1f(program.Handle, entry.location, value)
+	}
+	return err
+}
+
+func (program *Program) SetVec2(name string, value glm.Vec2) error {
+	entry, err := program.lookup(name, "vec2", gl.FLOAT_VEC2)
+	if entry != nil && entry.changed(floatBits(value[:]...)...) {
+		gl.ProgramUniform2fv(program.Handle, entry.location, 1, &value[0])
+	}
+	return err
+}
+
+func (program *Program) SetVec3(name string, value glm.Vec3) error {
+	entry, err := program.lookup(name, "vec3", gl.FLOAT_VEC3)
+	if entry != nil && entry.changed(floatBits(value[:]...)...) {
+		gl.ProgramUniform3fv(program.Handle, entry.location, 1, &value[0])
+	}
+	return err
+}
+
+func (program *Program) SetVec4(name string, value glm.Vec4) error {
+	entry, err := program.lookup(name, "vec4", gl.FLOAT_VEC4)
+	if entry != nil && entry.changed(floatBits(value[:]...)...) {
+		gl.ProgramUniform4fv(program.Handle, entry.location, 1, &value[0])
+	}
+	return err
+}
+
+func (program *Program) SetMat3(name string, value glm.Mat3) error {
+	entry, err := program.lookup(name, "mat3", gl.FLOAT_MAT3)
+	if entry != nil && entry.changed(floatBits(value[:]...)...) {
+		gl.ProgramUniformMatrix3fv(program.Handle, entry.location, 1, false, &value[0])
+	}
+	return err
+}
+
+func (program *Program) SetMat4(name string, value glm.Mat4) error {
+	entry, err := program.lookup(name, "mat4", gl.FLOAT_MAT4)
+	if entry != nil && entry.changed(floatBits(value[:]...)...) {
+		gl.ProgramUniformMatrix4fv(program.Handle, entry.location, 1, false, &value[0])
+	}
+	return err
+}
+
+// Points a sampler uniform at a texture unit
+func (program *Program) SetSampler(name string, unit int32) error {
+	entry, err := program.lookup(name, "sampler", samplerTypes...)
+	if entry != nil && entry.changed(uint32(unit)) {
+		gl.ProgramUniform1i(program.Handle, entry.location, unit)
+	}
+	return err
+}
+
+// For uniforms that are set every frame, getting the type wrong is a bug in either the shader or the code
+func mustSetUniform(err error) {
+	if err != nil {
+		util.ThrowError(err)
+	}
+}
+
+var samplerTypes = []uint32{
+	gl.SAMPLER_1D, gl.SAMPLER_2D, gl.SAMPLER_3D, gl.SAMPLER_CUBE, gl.SAMPLER_2D_SHADOW, gl.SAMPLER_1D_ARRAY,
+	gl.SAMPLER_2D_ARRAY, gl.SAMPLER_CUBE_MAP_ARRAY, gl.SAMPLER_2D_ARRAY_SHADOW, gl.SAMPLER_CUBE_SHADOW,
+	gl.SAMPLER_2D_MULTISAMPLE, gl.SAMPLER_BUFFER, gl.INT_SAMPLER_2D, gl.INT_SAMPLER_2D_ARRAY,
+	gl.UNSIGNED_INT_SAMPLER_2D, gl.UNSIGNED_INT_SAMPLER_2D_ARRAY,
+}
+
+var glslTypeNames = map[uint32]string{
+	gl.FLOAT: "float", gl.FLOAT_VEC2: "vec2", gl.FLOAT_VEC3: "vec3", gl.FLOAT_VEC4: "vec4",
+	gl.INT: "int", gl.INT_VEC2: "ivec2", gl.INT_VEC3: "ivec3", gl.INT_VEC4: "ivec4",
+	gl.UNSIGNED_INT: "uint", gl.UNSIGNED_INT_VEC2: "uvec2", gl.UNSIGNED_INT_VEC3: "uvec3", gl.UNSIGNED_INT_VEC4: "uvec4",
+	gl.BOOL: "bool", gl.BOOL_VEC2: "bvec2", gl.BOOL_VEC3: "bvec3", gl.BOOL_VEC4: "bvec4",
+	gl.FLOAT_MAT2: "mat2", gl.FLOAT_MAT3: "mat3", gl.FLOAT_MAT4: "mat4",
+	gl.SAMPLER_1D: "sampler1D", gl.SAMPLER_2D: "sampler2D", gl.SAMPLER_3D: "sampler3D", gl.SAMPLER_CUBE: "samplerCube",
+	gl.SAMPLER_2D_SHADOW: "sampler2DShadow", gl.SAMPLER_1D_ARRAY: "sampler1DArray", gl.SAMPLER_2D_ARRAY: "sampler2DArray",
+	gl.SAMPLER_CUBE_MAP_ARRAY: "samplerCubeArray", gl.SAMPLER_2D_ARRAY_SHADOW: "sampler2DArrayShadow",
+	gl.SAMPLER_CUBE_SHADOW: "samplerCubeShadow", gl.SAMPLER_2D_MULTISAMPLE: "sampler2DMS", gl.SAMPLER_BUFFER: "samplerBuffer",
+	gl.INT_SAMPLER_2D: "isampler2D", gl.INT_SAMPLER_2D_ARRAY: "isampler2DArray",
+	gl.UNSIGNED_INT_SAMPLER_2D: "usampler2D", gl.UNSIGNED_INT_SAMPLER_2D_ARRAY: "usampler2DArray",
+}
+
+func glslTypeName(glType uint32) string {
+	if name, ok := glslTypeNames[glType]; ok {
+		return name
+	}
+	return fmt.Sprintf("type 0x%x", glType)
+}
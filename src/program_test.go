@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	gl "github.com/go-gl/gl/v4.6-core/gl"
+)
+
+func TestValidateShaderStages(t *testing.T) {
+	tests := []struct {
+		stages []uint32
+		valid  bool
+	}{
+		{[]uint32{gl.VERTEX_SHADER, gl.FRAGMENT_SHADER}, true},
+		{[]uint32{gl.FRAGMENT_SHADER, gl.VERTEX_SHADER}, true},
+		{[]uint32{gl.VERTEX_SHADER}, true},
+		{[]uint32{gl.VERTEX_SHADER, gl.GEOMETRY_SHADER, gl.FRAGMENT_SHADER}, true},
+		{[]uint32{gl.VERTEX_SHADER, gl.TESS_CONTROL_SHADER, gl.TESS_EVALUATION_SHADER, gl.FRAGMENT_SHADER}, true},
+		{[]uint32{gl.VERTEX_SHADER, gl.TESS_EVALUATION_SHADER, gl.FRAGMENT_SHADER}, true},
+		{[]uint32{gl.VERTEX_SHADER, gl.TESS_CONTROL_SHADER, gl.TESS_EVALUATION_SHADER, gl.GEOMETRY_SHADER, gl.FRAGMENT_SHADER}, true},
+		{[]uint32{gl.COMPUTE_SHADER}, true},
+
+		{nil, false},
+		{[]uint32{gl.FRAGMENT_SHADER}, false},
+		{[]uint32{gl.GEOMETRY_SHADER, gl.FRAGMENT_SHADER}, false},
+		{[]uint32{gl.VERTEX_SHADER, gl.VERTEX_SHADER, gl.FRAGMENT_SHADER}, false},
+		{[]uint32{gl.VERTEX_SHADER, gl.TESS_CONTROL_SHADER, gl.FRAGMENT_SHADER}, false},
+		{[]uint32{gl.COMPUTE_SHADER, gl.VERTEX_SHADER}, false},
+		{[]uint32{gl.COMPUTE_SHADER, gl.COMPUTE_SHADER}, false},
+		{[]uint32{gl.VERTEX_SHADER, gl.TEXTURE_2D}, false},
+	}
+
+	for _, test := range tests {
+		names := make([]string, len(test.stages))
+		for i, stage := range test.stages {
+			names[i] = shaderStageName(stage)
+		}
+
+		err := validateShaderStages(test.stages)
+		if test.valid && err != nil {
+			t.Errorf("%v: %v", names, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%v: should have been rejected", names)
+		}
+	}
+}
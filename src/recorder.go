@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image/png"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	glfw "github.com/go-gl/glfw/v3.3/glfw"
+)
+
+// Records every frame, either as numbered pngs in a directory or as one uncompressed .y4m video that
+// ffmpeg and most players understand. Recordings run on simulated time, every frame is exactly one
+// frame rate step after the previous one no matter how long it took to draw and encode, so two runs
+// of the same thing come out the same.
+// Frames get read back on the render thread and encoded by a pool of workers. The queue in between is
+// bounded, when the workers fall behind the render thread waits for them instead of piling up frames
+type recorder struct {
+	frameRate int
+	frames    int // frames captured so far
+
+	queue   chan recordedFrame
+	workers sync.WaitGroup
+
+	directory string // pngs only
+
+	// every frame of a video has to be the size of the first one
+	width, height int
+	// what gets recorded again while the window is minimized
+	last *textureData
+
+	// y4m only, the workers convert frames in whatever order they finish and the writer puts them back
+	// in order
+	video       *os.File
+	videoWriter *bufio.Writer
+	encoded     chan recordedFrame
+	written     chan struct{}
+
+	errLock sync.Mutex
+	err     error
+}
+
+type recordedFrame struct {
+	index  int
+	pixels *textureData // bottom row first rgba
+	data   []byte       // the encoded frame, y4m only
+}
+
+// Starts a recording into path, which is a .y4m file or a directory for pngs. The size is the size of
+// the framebuffer when the recording starts, videos stay that size
+func newRecorder(path string, frameRate int, width int, height int) (*recorder, error) {
+	if frameRate <= 0 {
+		return nil, fmt.Errorf("Frame rate has to be positive, got %d", frameRate)
+	}
+
+	workers := runtime.NumCPU()
+	recording := &recorder{frameRate: frameRate, queue: make(chan recordedFrame, workers*2), width: width, height: height}
+
+	if strings.EqualFold(filepath.Ext(path), ".y4m") {
+		if width <= 0 || height <= 0 {
+			return nil, fmt.Errorf("Can't record a %dx%d video, the window is probably minimized", width, height)
+		}
+		video, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		recording.video = video
+		recording.videoWriter = bufio.NewWriterSize(video, 1<<20)
+		recording.encoded = make(chan recordedFrame, workers*2)
+		recording.written = make(chan struct{})
+
+		// 4:2:0 with the chroma sited in the middle of every 2x2 block, limited range like most video
+		header := fmt.Sprintf("YUV4MPEG2 W%d H%d F%d:1 Ip A1:1 C420jpeg XCOLORRANGE=LIMITED\n", width, height, frameRate)
+		if _, err := recording.videoWriter.WriteString(header); err != nil {
+			video.Close()
+			return nil, err
+		}
+		go recording.writeVideo()
+	} else {
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return nil, err
+		}
+		recording.directory = path
+	}
+
+	recording.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go recording.work()
+	}
+
+	return recording, nil
+}
+
+// Simulated time of the frame that's about to be drawn, in seconds
+func (recording *recorder) time() float64 {
+	return float64(recording.frames) / float64(recording.frameRate)
+}
+
+// Has to be called after the frame is drawn and before the buffers get swapped, like screenshots
+func (recording *recorder) capture(window *glfw.Window) {
+	width, height := window.GetFramebufferSize()
+
+	// a minimized window has a 0x0 framebuffer, the previous frame gets recorded again so the recording
+	// keeps its timing (or a black one when nothing got recorded yet)
+	if width == 0 || height == 0 {
+		if recording.last == nil {
+			width, height := maxInt(recording.width, 1), maxInt(recording.height, 1)
+			recording.last = &textureData{width: int32(width), height: int32(height), format: textureRGBA, pixels: make([]uint8, width*height*4)}
+		}
+		recording.queue <- recordedFrame{index: recording.frames, pixels: recording.last}
+		recording.frames++
+		return
+	}
+
+	// the workers only ever read the pixels, so the same frame can be queued again
+	recording.last = readFramebuffer(width, height)
+	recording.queue <- recordedFrame{index: recording.frames, pixels: recording.last}
+	recording.frames++
+}
+
+func (recording *recorder) work() {
+	defer recording.workers.Done()
+
+	for frame := range recording.queue {
+		if recording.video != nil {
+			recording.encoded <- recording.encodeVideoFrame(frame)
+			continue
+		}
+
+		path := filepath.Join(recording.directory, fmt.Sprintf("frame-%06d.png", frame.index))
+		if err := writeFramePNG(path, frame.pixels); err != nil {
+			recording.fail(err)
+		}
+	}
+}
+
+func writeFramePNG(path string, pixels *textureData) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(file, unflipImage(pixels.pixels, int(pixels.width), int(pixels.height))); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Frames are the FRAME marker and then the whole Y plane, U plane and V plane, top row first. Frames
+// that don't have the size of the video (the window got resized) get scaled to it
+func (recording *recorder) encodeVideoFrame(frame recordedFrame) recordedFrame {
+	pixels := frame.pixels
+	if int(pixels.width) != recording.width || int(pixels.height) != recording.height {
+		// a copy, the same pixels can be queued more than once
+		source := *pixels
+		source.srgb = true
+		pixels = resampleTexture(&source, recording.width, recording.height, mipFilterBox, mipmapOptions{filter: mipFilterBox, srgb: true})
+	}
+
+	frame.data = append([]byte("FRAME\n"), rgbaToYUV420(pixels.pixels, recording.width, recording.height)...)
+	frame.pixels = nil
+	return frame
+}
+
+func (recording *recorder) writeVideo() {
+	defer close(recording.written)
+
+	// frames that finished before the ones in front of them wait here
+	waiting := map[int][]byte{}
+	next := 0
+	for frame := range recording.encoded {
+		waiting[frame.index] = frame.data
+		for data, ok := waiting[next]; ok; data, ok = waiting[next] {
+			if _, err := recording.videoWriter.Write(data); err != nil {
+				recording.fail(err)
+			}
+			delete(waiting, next)
+			next++
+		}
+	}
+}
+
+// Only the first error gets kept, the rest are usually the same thing again
+func (recording *recorder) fail(err error) {
+	recording.errLock.Lock()
+	defer recording.errLock.Unlock()
+	if recording.err == nil {
+		recording.err = err
+	}
+}
+
+// Waits for every frame to be written and finishes the recording
+func (recording *recorder) close() error {
+	close(recording.queue)
+	recording.workers.Wait()
+
+	if recording.video != nil {
+		close(recording.encoded)
+		<-recording.written
+
+		if err := recording.videoWriter.Flush(); err != nil {
+			recording.fail(err)
+		}
+		if err := recording.video.Close(); err != nil {
+			recording.fail(err)
+		}
+	}
+
+	recording.errLock.Lock()
+	defer recording.errLock.Unlock()
+	return recording.err
+}
+
+// Converts bottom row first rgba to top row first Y'CbCr 4:2:0 planes with the BT.601 matrix in limited
+// range. The pixels are already gamma encoded so they go in as they are, every chroma sample is the
+// average of its 2x2 block (the edge ones of odd sizes only have half a block)
+func rgbaToYUV420(pixels []uint8, width int, height int) []byte {
+	chromaWidth, chromaHeight := (width+1)/2, (height+1)/2
+	planes := make([]byte, width*height+2*chromaWidth*chromaHeight)
+	lumaPlane := planes[:width*height]
+	uPlane := planes[width*height : width*height+chromaWidth*chromaHeight]
+	vPlane := planes[width*height+chromaWidth*chromaHeight:]
+
+	pixel := func(x int, y int) (int, int, int) {
+		offset := ((height-1-y)*width + x) * 4
+		return int(pixels[offset]), int(pixels[offset+1]), int(pixels[offset+2])
+	}
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			r, g, b := pixel(x, y)
+			lumaPlane[y*width+x] = byte((66*r+129*g+25*b+128)>>8 + 16)
+		}
+	}
+
+	for cy := 0; cy < chromaHeight; cy++ {
+		for cx := 0; cx < chromaWidth; cx++ {
+			var r, g, b, count int
+			for y := cy * 2; y < minInt(cy*2+2, height); y++ {
+				for x := cx * 2; x < minInt(cx*2+2, width); x++ {
+					pr, pg, pb := pixel(x, y)
+					r, g, b, count = r+pr, g+pg, b+pb, count+1
+				}
+			}
+			r, g, b = (r+count/2)/count, (g+count/2)/count, (b+count/2)/count
+
+			uPlane[cy*chromaWidth+cx] = byte((-38*r-74*g+112*b+128)>>8 + 128)
+			vPlane[cy*chromaWidth+cx] = byte((112*r-94*g-18*b+128)>>8 + 128)
+		}
+	}
+
+	return planes
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// rgba pixels from rows given top row first, the way they'd be read back from opengl bottom row first
+func readbackPixels(rows [][][3]uint8) []uint8 {
+	var pixels []uint8
+	for y := len(rows) - 1; y >= 0; y-- {
+		for _, pixel := range rows[y] {
+			pixels = append(pixels, pixel[0], pixel[1], pixel[2], 0xff)
+		}
+	}
+	return pixels
+}
+
+func TestRGBAToYUV420(t *testing.T) {
+	white, black := [3]uint8{0xff, 0xff, 0xff}, [3]uint8{0, 0, 0}
+	red, blue := [3]uint8{0xff, 0, 0}, [3]uint8{0, 0, 0xff}
+
+	// limited range BT.601, 16-235 for luma and 16-240 around 128 for chroma
+	tests := []struct {
+		name         string
+		color        [3]uint8
+		luma, cb, cr byte
+	}{
+		{"white", white, 235, 128, 128},
+		{"black", black, 16, 128, 128},
+		{"red", red, 82, 90, 240},
+		{"blue", blue, 41, 240, 110},
+	}
+	for _, test := range tests {
+		rows := [][][3]uint8{{test.color, test.color}, {test.color, test.color}}
+		planes := rgbaToYUV420(readbackPixels(rows), 2, 2)
+		want := []byte{test.luma, test.luma, test.luma, test.luma, test.cb, test.cr}
+		if !bytes.Equal(planes, want) {
+			t.Errorf("%s: got %v, want %v", test.name, planes, want)
+		}
+	}
+
+	// 3x3, the right column and the bottom row of chroma only have half a block (and the corner a
+	// quarter), they mustn't pick up pixels from anywhere else
+	rows := [][][3]uint8{
+		{white, white, red},
+		{white, white, red},
+		{blue, blue, blue},
+	}
+	planes := rgbaToYUV420(readbackPixels(rows), 3, 3)
+	want := []byte{
+		235, 235, 82,
+		235, 235, 82,
+		41, 41, 41,
+		128, 90, 240, 240, // cb
+		128, 240, 110, 110, // cr
+	}
+	if !bytes.Equal(planes, want) {
+		t.Errorf("3x3: got %v, want %v", planes, want)
+	}
+
+	// the chroma of a full block is the average of its 4 pixels
+	rows = [][][3]uint8{{black, white}, {white, black}}
+	if planes := rgbaToYUV420(readbackPixels(rows), 2, 2); planes[4] != 128 || planes[5] != 128 || planes[0] != 16 || planes[1] != 235 {
+		t.Errorf("black and white: got %v", planes)
+	}
+}
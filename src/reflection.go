@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	gl "github.com/go-gl/gl/v4.6-core/gl"
+)
+
+// What a linked program takes from the outside, straight from the driver. Only the active things are
+// in here, the compiler throws away whatever the shaders don't end up using
+type ProgramReflection struct {
+	Attributes    []ShaderVariable
+	Uniforms      []ShaderVariable // the ones outside of uniform blocks
+	UniformBlocks []UniformBlock
+}
+
+type ShaderVariable struct {
+	Name     string // arrays without the [0] the driver puts at the end
+	Type     uint32 // gl.FLOAT_VEC3 and the like
+	Location int32  // -1 for members of uniform blocks
+	Size     int32  // number of elements, 1 unless it's an array
+	Offset   int32  // in bytes from the start of the block, uniform block members only
+}
+
+type UniformBlock struct {
+	Name     string
+	Binding  uint32 // the uniform buffer binding point the block reads from
+	DataSize int32  // how big the buffer has to be at least
+	Members  []ShaderVariable
+}
+
+func (variable ShaderVariable) String() string {
+	description := glslTypeName(variable.Type) + " " + variable.Name
+	if variable.Size > 1 {
+		description += fmt.Sprintf("[%d]", variable.Size)
+	}
+	if variable.Location >= 0 {
+		description = fmt.Sprintf("layout(location = %d) %s", variable.Location, description)
+	}
+	return description
+}
+
+func reflectProgram(handle uint32) ProgramReflection {
+	var reflection ProgramReflection
+
+	var count, maxLength int32
+	gl.GetProgramiv(handle, gl.ACTIVE_ATTRIBUTES, &count)
+	gl.GetProgramiv(handle, gl.ACTIVE_ATTRIBUTE_MAX_LENGTH, &maxLength)
+	name := make([]uint8, maxLength+1)
+	for i := uint32(0); i < uint32(count); i++ {
+		var length, size int32
+		var glType uint32
+		gl.GetActiveAttrib(handle, i, maxLength+1, &length, &size, &glType, &name[0])
+		attributeName := string(name[:length])
+		// built in inputs like gl_VertexID show up too, they don't have a location
+		if strings.HasPrefix(attributeName, "gl_") {
+			continue
+		}
+
+		location := gl.GetAttribLocation(handle, gl.Str(attributeName+"\x00"))
+		reflection.Attributes = append(reflection.Attributes, ShaderVariable{
+			Name: strings.TrimSuffix(attributeName, "[0]"), Type: glType, Location: location, Size: size,
+		})
+	}
+
+	var blockCount int32
+	gl.GetProgramiv(handle, gl.ACTIVE_UNIFORM_BLOCKS, &blockCount)
+	gl.GetProgramiv(handle, gl.ACTIVE_UNIFORM_BLOCK_MAX_NAME_LENGTH, &maxLength)
+	name = make([]uint8, maxLength+1)
+	for i := uint32(0); i < uint32(blockCount); i++ {
+		var length, binding, dataSize int32
+		gl.GetActiveUniformBlockName(handle, i, maxLength+1, &length, &name[0])
+		gl.GetActiveUniformBlockiv(handle, i, gl.UNIFORM_BLOCK_BINDING, &binding)
+		gl.GetActiveUniformBlockiv(handle, i, gl.UNIFORM_BLOCK_DATA_SIZE, &dataSize)
+		reflection.UniformBlocks = append(reflection.UniformBlocks, UniformBlock{
+			Name: string(name[:length]), Binding: uint32(binding), DataSize: dataSize,
+		})
+	}
+
+	gl.GetProgramiv(handle, gl.ACTIVE_UNIFORMS, &count)
+	gl.GetProgramiv(handle, gl.ACTIVE_UNIFORM_MAX_LENGTH, &maxLength)
+	name = make([]uint8, maxLength+1)
+	for i := uint32(0); i < uint32(count); i++ {
+		var length, size int32
+		var glType uint32
+		gl.GetActiveUniform(handle, i, maxLength+1, &length, &size, &glType, &name[0])
+		uniformName := string(name[:length])
+
+		var blockIndex, offset int32
+		gl.GetActiveUniformsiv(handle, 1, &i, gl.UNIFORM_BLOCK_INDEX, &blockIndex)
+		gl.GetActiveUniformsiv(handle, 1, &i, gl.UNIFORM_OFFSET, &offset)
+
+		variable := ShaderVariable{Name: strings.TrimSuffix(uniformName, "[0]"), Type: glType, Location: -1, Size: size}
+		if blockIndex >= 0 && int(blockIndex) < len(reflection.UniformBlocks) {
+			variable.Offset = offset
+			block := &reflection.UniformBlocks[blockIndex]
+			block.Members = append(block.Members, variable)
+			continue
+		}
+
+		variable.Location = gl.GetUniformLocation(handle, gl.Str(uniformName+"\x00"))
+		reflection.Uniforms = append(reflection.Uniforms, variable)
+	}
+
+	return reflection
+}
+
+// What the Go side expects a program to take. Attributes are matched by location (and by name too when
+// they have one), Type is what the vertex buffer feeds them. Uniforms are matched by name, a Size of 0
+// takes any array size
+type ExpectedInterface struct {
+	Attributes    []ShaderVariable
+	Uniforms      []ShaderVariable
+	UniformBlocks []string
+}
+
+// Compares the program with what the code expects, the error lists every mismatch
+func (program *Program) CheckInterface(expected ExpectedInterface) error {
+	var problems []string
+
+	for _, want := range expected.Attributes {
+		var found *ShaderVariable
+		for i, attribute := range program.reflection.Attributes {
+			if attribute.Location == want.Location {
+				found = &program.reflection.Attributes[i]
+			}
+		}
+
+		switch {
+		case found == nil:
+			problems = append(problems, fmt.Sprintf("there's no attribute at location %d for %s", want.Location, want))
+		case want.Name != "" && found.Name != want.Name:
+			problems = append(problems, fmt.Sprintf("location %d is %s, expected %s", want.Location, found, want))
+		case !attributeAccepts(found.Type, want.Type):
+			problems = append(problems, fmt.Sprintf("%s can't take %s", found, glslTypeName(want.Type)))
+		}
+	}
+
+	for _, want := range expected.Uniforms {
+		var found *ShaderVariable
+		for i, uniform := range program.reflection.Uniforms {
+			if uniform.Name == want.Name {
+				found = &program.reflection.Uniforms[i]
+			}
+		}
+
+		switch {
+		case found == nil:
+			problems = append(problems, fmt.Sprintf("uniform %s is missing (or unused)", want.Name))
+		case found.Type != want.Type:
+			problems = append(problems, fmt.Sprintf("uniform %s is a %s, expected a %s", want.Name, glslTypeName(found.Type), glslTypeName(want.Type)))
+		case want.Size != 0 && found.Size != want.Size:
+			problems = append(problems, fmt.Sprintf("uniform %s has %d elements, expected %d", want.Name, found.Size, want.Size))
+		}
+	}
+
+	for _, want := range expected.UniformBlocks {
+		found := false
+		for _, block := range program.reflection.UniformBlocks {
+			found = found || block.Name == want
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf("uniform block %s is missing (or unused)", want))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("Program %d doesn't match what the code expects:\n  %s", program.Handle, strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// Float attributes can take fewer components than they have, the rest get filled with 0, 0, 1.
+// Everything else has to match
+func attributeAccepts(declared uint32, fed uint32) bool {
+	if declared == fed {
+		return true
+	}
+	floatVectors := []uint32{gl.FLOAT, gl.FLOAT_VEC2, gl.FLOAT_VEC3, gl.FLOAT_VEC4}
+	declaredComponents, fedComponents := 0, 0
+	for i, glType := range floatVectors {
+		if glType == declared {
+			declaredComponents = i + 1
+		}
+		if glType == fed {
+			fedComponents = i + 1
+		}
+	}
+	return declaredComponents > 0 && fedComponents > 0 && fedComponents <= declaredComponents
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+
+	gl "github.com/go-gl/gl/v4.6-core/gl"
+)
+
+// How a texture should be sampled. Comes from a json file next to the texture (texture.png.json), an mtl
+// file puts the options of its map_ statement on top. Anything that isn't set keeps its default
+type TextureDescriptor struct {
+	MinFilter   string     `json:"minFilter"`   // nearest, linear
+	MagFilter   string     `json:"magFilter"`   // nearest, linear
+	MipFilter   string     `json:"mipFilter"`   // none, nearest, linear
+	WrapS       string     `json:"wrapS"`       // repeat, mirror, clamp, border
+	WrapT       string     `json:"wrapT"`       // same as wrapS
+	WrapR       string     `json:"wrapR"`       // same as wrapS
+	Anisotropy  float32    `json:"anisotropy"`  // 1 turns it off
+	BorderColor [4]float32 `json:"borderColor"` // only used with the border wrap mode
+	SRGB        bool       `json:"srgb"`        // the color channels are sRGB encoded, data textures (normal maps etc) aren't
+	HDRFormat   string     `json:"hdrFormat"`   // what .hdr images get stored as, rgb16f or rgb32f
+	Cubemap     string     `json:"cubemap"`     // the image is a cubemap laid out as a cross or equirectangular
+	CubemapSize int        `json:"cubemapSize"` // face size for equirectangular images, a quarter of the width by default
+	Premultiply bool       `json:"premultiply"` // multiply the color by alpha when loading, for the premultiplied blend mode
+}
+
+func defaultTextureDescriptor() TextureDescriptor {
+	return TextureDescriptor{
+		MinFilter:  "linear",
+		MagFilter:  "nearest",
+		MipFilter:  "linear",
+		WrapS:      "clamp",
+		WrapT:      "clamp",
+		WrapR:      "clamp",
+		Anisotropy: 1,
+		SRGB:       true,
+		HDRFormat:  "rgb16f",
+	}
+}
+
+var (
+	glFilters  = map[string]int32{"nearest": gl.NEAREST, "linear": gl.LINEAR}
+	glWraps    = map[string]int32{"repeat": gl.REPEAT, "mirror": gl.MIRRORED_REPEAT, "clamp": gl.CLAMP_TO_EDGE, "border": gl.CLAMP_TO_BORDER}
+	hdrFormats = map[string]textureFormat{"rgb16f": textureRGB16F, "rgb32f": textureRGB32F}
+)
+
+func (descriptor *TextureDescriptor) validate() error {
+	for _, filter := range []string{descriptor.MinFilter, descriptor.MagFilter} {
+		if _, ok := glFilters[filter]; !ok {
+			return fmt.Errorf("unknown filter %q", filter)
+		}
+	}
+	if _, ok := glFilters[descriptor.MipFilter]; !ok && descriptor.MipFilter != "none" {
+		return fmt.Errorf("unknown mip filter %q", descriptor.MipFilter)
+	}
+	for _, wrap := range []string{descriptor.WrapS, descriptor.WrapT, descriptor.WrapR} {
+		if _, ok := glWraps[wrap]; !ok {
+			return fmt.Errorf("unknown wrap mode %q", wrap)
+		}
+	}
+	if descriptor.Anisotropy < 1 {
+		return fmt.Errorf("anisotropy has to be at least 1, got %v", descriptor.Anisotropy)
+	}
+	if _, ok := hdrFormats[descriptor.HDRFormat]; !ok {
+		return fmt.Errorf("unknown hdr format %q", descriptor.HDRFormat)
+	}
+	if descriptor.Cubemap != "" && descriptor.Cubemap != "cross" && descriptor.Cubemap != "equirectangular" {
+		return fmt.Errorf("unknown cubemap layout %q", descriptor.Cubemap)
+	}
+	if descriptor.CubemapSize < 0 {
+		return fmt.Errorf("cubemap size can't be negative, got %d", descriptor.CubemapSize)
+	}
+	return nil
+}
+
+func (descriptor *TextureDescriptor) hdrFormat() textureFormat {
+	return hdrFormats[descriptor.HDRFormat]
+}
+
+func (descriptor *TextureDescriptor) hasMipmaps() bool {
+	return descriptor.MipFilter != "none"
+}
+
+func (descriptor *TextureDescriptor) mipmapOptions() mipmapOptions {
+	options := defaultMipmapOptions
+	options.srgb = descriptor.SRGB
+	options.premultiplied = descriptor.Premultiply
+	return options
+}
+
+// the min filter and the mip filter are one enum in opengl
+func (descriptor *TextureDescriptor) glMinFilter() int32 {
+	switch {
+	case descriptor.MipFilter == "none":
+		return glFilters[descriptor.MinFilter]
+	case descriptor.MinFilter == "nearest" && descriptor.MipFilter == "nearest":
+		return gl.NEAREST_MIPMAP_NEAREST
+	case descriptor.MinFilter == "nearest":
+		return gl.NEAREST_MIPMAP_LINEAR
+	case descriptor.MipFilter == "nearest":
+		return gl.LINEAR_MIPMAP_NEAREST
+	}
+	return gl.LINEAR_MIPMAP_LINEAR
+}
+
+// anything the json doesn't set comes from base
+func parseTextureDescriptor(data []byte, base TextureDescriptor) (TextureDescriptor, error) {
+	descriptor := base
+	if err := json.Unmarshal(data, &descriptor); err != nil {
+		return descriptor, err
+	}
+	return descriptor, descriptor.validate()
+}
+
+// Reads <name>.json if there is one, the defaults otherwise
+func loadTextureDescriptor(name string) (TextureDescriptor, error) {
+	return loadTextureDescriptorOver(name, defaultTextureDescriptor())
+}
+
+// Same as loadTextureDescriptor, but whatever the json file doesn't set comes from base
+func loadTextureDescriptorOver(name string, base TextureDescriptor) (TextureDescriptor, error) {
+	data, err := loadAsset(name + ".json")
+	if errors.Is(err, fs.ErrNotExist) {
+		return base, nil
+	}
+	if err != nil {
+		return base, err
+	}
+
+	descriptor, err := parseTextureDescriptor(data, base)
+	if err != nil {
+		return descriptor, fmt.Errorf("Invalid texture descriptor %s.json: %v", name, err)
+	}
+	return descriptor, nil
+}
+
+// how many arguments each mtl texture option takes, the ones we don't care about still need skipping
+var mtlOptionArguments = map[string]int{
+	"-blendu": 1, "-blendv": 1, "-boost": 1, "-cc": 1, "-clamp": 1, "-imfchan": 1, "-texres": 1, "-bm": 1, "-type": 1,
+	"-mm": 2, "-o": 3, "-s": 3, "-t": 3,
+}
+
+// the options of a map_ statement that affect sampling, which is only -clamp
+type mtlTextureOptions struct {
+	clamp string // on or off, empty when it isn't there
+}
+
+// The texture's own json file with the options on top. mtl textures repeat unless the json file or
+// -clamp says otherwise
+func (options mtlTextureOptions) descriptor(texture string) (TextureDescriptor, error) {
+	base := defaultTextureDescriptor()
+	base.WrapS, base.WrapT, base.WrapR = "repeat", "repeat", "repeat"
+
+	descriptor, err := loadTextureDescriptorOver(texture, base)
+	if err != nil {
+		return descriptor, err
+	}
+
+	switch options.clamp {
+	case "on":
+		descriptor.WrapS, descriptor.WrapT, descriptor.WrapR = "clamp", "clamp", "clamp"
+	case "off":
+		descriptor.WrapS, descriptor.WrapT, descriptor.WrapR = "repeat", "repeat", "repeat"
+	}
+	return descriptor, nil
+}
+
+// Parses the arguments of a map_ statement (map_Kd -clamp on burger.png) into the texture name
+// and the options that were given
+func parseMTLTextureOptions(arguments []string) (string, mtlTextureOptions, error) {
+	var options mtlTextureOptions
+
+	for i := 0; i < len(arguments); i++ {
+		option := arguments[i]
+		count, ok := mtlOptionArguments[option]
+		if !ok {
+			// everything after the options is the file name, which can have spaces in it
+			return strings.Join(arguments[i:], " "), options, nil
+		}
+
+		// -o, -s and -t take 1 to 3 numbers
+		values := []string{}
+		for j := 0; j < count && i+1 < len(arguments); j++ {
+			if count == 3 && j > 0 {
+				if _, err := strconv.ParseFloat(arguments[i+1], 64); err != nil {
+					break
+				}
+			}
+			i++
+			values = append(values, arguments[i])
+		}
+		if len(values) == 0 {
+			return "", options, fmt.Errorf("%s is missing its value", option)
+		}
+
+		if option == "-clamp" {
+			if values[0] != "on" && values[0] != "off" {
+				return "", options, fmt.Errorf("-clamp has to be on or off, got %q", values[0])
+			}
+			options.clamp = values[0]
+		}
+	}
+
+	return "", options, errors.New("texture statement without a file name")
+}
+
+type mtlMaterial struct {
+	name              string
+	diffuseMap        string // relative to the mtl file
+	diffuseDescriptor TextureDescriptor
+
+	blend       blendMode
+	alphaCutoff float32 // only used with blendAlphaTest
+	opacity     float32 // d, multiplies the texture's alpha
+}
+
+// Only reads what the renderer can actually use, which right now is the diffuse texture and how the
+// material blends. blend and alpha_cutoff aren't standard mtl, other programs just skip them. Without
+// a blend statement materials are opaque, unless d makes them see-through. directory is where the mtl
+// file is, the textures' json files get read from there
+func parseMTL(data []byte, directory string) (map[string]*mtlMaterial, error) {
+	materials := make(map[string]*mtlMaterial)
+	var current *mtlMaterial
+	explicitBlend := map[*mtlMaterial]bool{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		switch fields[0] {
+		case "newmtl":
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("line %d: newmtl without a name", lineNumber)
+			}
+			current = &mtlMaterial{name: strings.Join(fields[1:], " "), diffuseDescriptor: defaultTextureDescriptor(), alphaCutoff: 0.5, opacity: 1}
+			materials[current.name] = current
+		case "map_Kd":
+			if current == nil {
+				return nil, fmt.Errorf("line %d: map_Kd before newmtl", lineNumber)
+			}
+			name, options, err := parseMTLTextureOptions(fields[1:])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineNumber, err)
+			}
+			descriptor, err := options.descriptor(path.Join(directory, name))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineNumber, err)
+			}
+			current.diffuseMap = name
+			current.diffuseDescriptor = descriptor
+		case "d", "Tr", "alpha_cutoff", "blend":
+			if current == nil {
+				return nil, fmt.Errorf("line %d: %s before newmtl", lineNumber, fields[0])
+			}
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("line %d: %s is missing its value", lineNumber, fields[0])
+			}
+
+			if fields[0] == "blend" {
+				mode, err := parseBlendMode(fields[1])
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %v", lineNumber, err)
+				}
+				current.blend = mode
+				explicitBlend[current] = true
+				continue
+			}
+
+			value, err := strconv.ParseFloat(fields[1], 32)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineNumber, err)
+			}
+			switch fields[0] {
+			case "d":
+				current.opacity = float32(value)
+			case "Tr":
+				// transparency, the opposite of d
+				current.opacity = 1 - float32(value)
+			case "alpha_cutoff":
+				current.alphaCutoff = float32(value)
+			}
+		}
+	}
+
+	for _, material := range materials {
+		if !explicitBlend[material] && material.opacity < 1 {
+			material.blend = blendAlpha
+		}
+	}
+
+	return materials, scanner.Err()
+}
+
+// Sampler objects keep the sampling state separate from the textures, so the same texture can be
+// sampled differently in different places. There are only ever a handful so they just get cached
+type samplerCache struct {
+	samplers      map[TextureDescriptor]uint32
+	maxAnisotropy float32
+}
+
+func newSamplerCache() *samplerCache {
+	return &samplerCache{samplers: make(map[TextureDescriptor]uint32)}
+}
+
+func (cache *samplerCache) get(descriptor TextureDescriptor) uint32 {
+	// these only matter for the texture itself, not for sampling
+	descriptor.SRGB, descriptor.Premultiply = false, false
+	descriptor.HDRFormat, descriptor.Cubemap, descriptor.CubemapSize = "", "", 0
+
+	if sampler, ok := cache.samplers[descriptor]; ok {
+		return sampler
+	}
+
+	if cache.maxAnisotropy == 0 {
+		gl.GetFloatv(gl.MAX_TEXTURE_MAX_ANISOTROPY, &cache.maxAnisotropy)
+	}
+
+	var sampler uint32
+	gl.GenSamplers(1, &sampler)
+
+	gl.SamplerParameteri(sampler, gl.TEXTURE_MIN_FILTER, descriptor.glMinFilter())
+	gl.SamplerParameteri(sampler, gl.TEXTURE_MAG_FILTER, glFilters[descriptor.MagFilter])
+	gl.SamplerParameteri(sampler, gl.TEXTURE_WRAP_S, glWraps[descriptor.WrapS])
+	gl.SamplerParameteri(sampler, gl.TEXTURE_WRAP_T, glWraps[descriptor.WrapT])
+	gl.SamplerParameteri(sampler, gl.TEXTURE_WRAP_R, glWraps[descriptor.WrapR])
+	gl.SamplerParameterfv(sampler, gl.TEXTURE_BORDER_COLOR, &descriptor.BorderColor[0])
+
+	anisotropy := descriptor.Anisotropy
+	if anisotropy > cache.maxAnisotropy {
+		anisotropy = cache.maxAnisotropy
+	}
+	if anisotropy >= 1 {
+		gl.SamplerParameterf(sampler, gl.TEXTURE_MAX_ANISOTROPY, anisotropy)
+	}
+
+	cache.samplers[descriptor] = sampler
+	return sampler
+}
+
+func (cache *samplerCache) delete() {
+	for _, sampler := range cache.samplers {
+		gl.DeleteSamplers(1, &sampler)
+	}
+	cache.samplers = make(map[TextureDescriptor]uint32)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+// Reads assets from fsys instead of the real assets until the test is done
+func useTestAssets(t *testing.T, fsys fstest.MapFS) {
+	saved := assetFS
+	assetFS = NewVFS()
+	assetFS.AddFS("test", fsys)
+	t.Cleanup(func() { assetFS = saved })
+}
+
+func TestParseMTLUsesSidecar(t *testing.T) {
+	useTestAssets(t, fstest.MapFS{
+		"assets/sharp.png.json":   {Data: []byte(`{"magFilter": "linear", "anisotropy": 8, "wrapS": "mirror"}`)},
+		"assets/clamped.png.json": {Data: []byte(`{"wrapS": "clamp", "wrapT": "clamp", "wrapR": "clamp"}`)},
+	})
+
+	mtl := []byte(`
+newmtl sidecar
+map_Kd sharp.png
+
+newmtl explicit
+map_Kd -clamp on -s 2 2 sharp.png
+
+newmtl unclamped
+map_Kd -clamp off clamped.png
+
+newmtl plain
+map_Kd plain texture.png
+`)
+	materials, err := parseMTL(mtl, "assets")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		material   string
+		diffuseMap string
+		magFilter  string
+		anisotropy float32
+		wraps      [3]string
+	}{
+		// the json file wins over the mtl default of repeating
+		{"sidecar", "sharp.png", "linear", 8, [3]string{"mirror", "repeat", "repeat"}},
+		// options that were given win over the json file, the rest of it stays
+		{"explicit", "sharp.png", "linear", 8, [3]string{"clamp", "clamp", "clamp"}},
+		{"unclamped", "clamped.png", "nearest", 1, [3]string{"repeat", "repeat", "repeat"}},
+		// no json file
+		{"plain", "plain texture.png", "nearest", 1, [3]string{"repeat", "repeat", "repeat"}},
+	}
+	for _, test := range tests {
+		material := materials[test.material]
+		if material == nil {
+			t.Fatalf("%s is missing", test.material)
+		}
+		descriptor := material.diffuseDescriptor
+		wraps := [3]string{descriptor.WrapS, descriptor.WrapT, descriptor.WrapR}
+		if material.diffuseMap != test.diffuseMap || descriptor.MagFilter != test.magFilter || descriptor.Anisotropy != test.anisotropy || wraps != test.wraps {
+			t.Errorf("%s: %s with mag filter %s, anisotropy %v and wraps %v, want %s with %s, %v and %v", test.material,
+				material.diffuseMap, descriptor.MagFilter, descriptor.Anisotropy, wraps, test.diffuseMap, test.magFilter, test.anisotropy, test.wraps)
+		}
+	}
+}
+
+func TestParseMTLErrors(t *testing.T) {
+	useTestAssets(t, fstest.MapFS{"assets/broken.png.json": {Data: []byte(`{"magFilter": "cubic"}`)}})
+
+	for _, mtl := range []string{
+		"map_Kd texture.png",
+		"newmtl a\nmap_Kd -clamp maybe texture.png",
+		"newmtl a\nmap_Kd -clamp on",
+		"newmtl a\nmap_Kd broken.png",
+	} {
+		if _, err := parseMTL([]byte(mtl), "assets"); err == nil {
+			t.Errorf("%q parsed", mtl)
+		}
+	}
+}
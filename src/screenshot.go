@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"main/src/util"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	gl "github.com/go-gl/gl/v4.6-core/gl"
+	glfw "github.com/go-gl/glfw/v3.3/glfw"
+)
+
+// Screenshots. Reading the pixels back has to happen on the render thread, encoding the png is slow
+// enough to drop frames so that happens on its own goroutine. High resolution screenshots render the
+// frame again into an offscreen framebuffer that's a few times bigger than the window
+type screenshotter struct {
+	directory string
+	scale     int  // how many times bigger than the window high resolution screenshots are
+	downscale bool // scale high resolution screenshots back down to the window size, which makes them supersampled
+
+	pending int // the scale of the screenshot to take after the next frame, 0 is none
+	saving  sync.WaitGroup
+}
+
+func newScreenshotter(directory string, scale int, downscale bool) *screenshotter {
+	return &screenshotter{directory: directory, scale: maxInt(scale, 1), downscale: downscale}
+}
+
+func (screenshots *screenshotter) take() {
+	screenshots.pending = 1
+}
+
+func (screenshots *screenshotter) takeHighResolution() {
+	screenshots.pending = screenshots.scale
+}
+
+// Has to be called after the frame is drawn and before the buffers get swapped, the back buffer is what
+// gets read. draw has to draw the whole frame, it gets called again for high resolution screenshots
+func (screenshots *screenshotter) capture(window *glfw.Window, srgb bool, draw func()) {
+	if screenshots.pending == 0 {
+		return
+	}
+	scale := screenshots.pending
+	screenshots.pending = 0
+
+	width, height := window.GetFramebufferSize()
+	taken := time.Now()
+
+	var pixels *textureData
+	if scale == 1 {
+		pixels = readFramebuffer(width, height)
+	} else {
+		var err error
+		pixels, err = drawOffscreen(width*scale, height*scale, srgb, draw)
+		gl.Viewport(0, 0, int32(width), int32(height))
+		if err != nil {
+			util.ThrowWarning(fmt.Sprintf("Failed to take a %dx screenshot: %v", scale, err))
+			return
+		}
+	}
+
+	screenshots.saving.Add(1)
+	go func() {
+		defer screenshots.saving.Done()
+
+		if scale > 1 && screenshots.downscale {
+			// the pixels are sRGB encoded no matter which color pipeline drew them
+			pixels.srgb = true
+			pixels = resampleTexture(pixels, width, height, mipFilterBox, mipmapOptions{filter: mipFilterBox, srgb: true})
+		}
+
+		name := "screenshot-" + taken.Format("2006-01-02_15-04-05.000")
+		if scale > 1 && !screenshots.downscale {
+			name += fmt.Sprintf("-%dx", scale)
+		}
+		path, err := screenshots.save(unflipImage(pixels.pixels, int(pixels.width), int(pixels.height)), name+".png")
+		if err != nil {
+			util.ThrowWarning("Failed to save screenshot: " + err.Error())
+			return
+		}
+		util.ThrowNotification("Saved screenshot " + path)
+	}()
+}
+
+// Waits until every screenshot that's still being encoded is written
+func (screenshots *screenshotter) wait() {
+	screenshots.saving.Wait()
+}
+
+func (screenshots *screenshotter) save(screenshot image.Image, name string) (string, error) {
+	if err := os.MkdirAll(screenshots.directory, 0755); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(screenshots.directory, name)
+	file, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+
+	if err := png.Encode(file, screenshot); err != nil {
+		file.Close()
+		os.Remove(path)
+		return "", err
+	}
+	return path, file.Close()
+}
+
+// Reads the bound read framebuffer as bottom row first rgba. Only rgb gets read since the default
+// framebuffer's alpha is whatever the last draw left there, rows of 3 byte pixels aren't a multiple of
+// 4 bytes long though so the pack alignment (4 by default) has to go down to 1
+func readFramebuffer(width int, height int) *textureData {
+	rgb := make([]uint8, width*height*3)
+	gl.PixelStorei(gl.PACK_ALIGNMENT, 1)
+	gl.ReadPixels(0, 0, int32(width), int32(height), gl.RGB, gl.UNSIGNED_BYTE, gl.Ptr(rgb))
+	gl.PixelStorei(gl.PACK_ALIGNMENT, 4)
+
+	pixels := make([]uint8, width*height*4)
+	for i := 0; i < width*height; i++ {
+		copy(pixels[i*4:i*4+3], rgb[i*3:i*3+3])
+		pixels[i*4+3] = 0xff
+	}
+	return &textureData{width: int32(width), height: int32(height), format: textureRGBA, pixels: pixels}
+}
+
+// Draws a frame into a framebuffer of its own and reads it back. The color buffer is sRGB when the
+// default framebuffer is, so the frame gets encoded the same way it does on screen. Leaves the default
+// framebuffer bound, the viewport is up to the caller
+func drawOffscreen(width int, height int, srgb bool, draw func()) (*textureData, error) {
+	var maxSize int32
+	gl.GetIntegerv(gl.MAX_RENDERBUFFER_SIZE, &maxSize)
+	var maxViewport [2]int32
+	gl.GetIntegerv(gl.MAX_VIEWPORT_DIMS, &maxViewport[0])
+	if width > int(maxSize) || height > int(maxSize) || width > int(maxViewport[0]) || height > int(maxViewport[1]) {
+		return nil, fmt.Errorf("%dx%d is bigger than the driver can render", width, height)
+	}
+
+	colorFormat := uint32(gl.RGBA8)
+	if srgb {
+		colorFormat = gl.SRGB8_ALPHA8
+	}
+
+	var framebuffer uint32
+	gl.GenFramebuffers(1, &framebuffer)
+	defer gl.DeleteFramebuffers(1, &framebuffer)
+	var renderbuffers [2]uint32
+	gl.GenRenderbuffers(2, &renderbuffers[0])
+	defer gl.DeleteRenderbuffers(2, &renderbuffers[0])
+
+	gl.BindFramebuffer(gl.FRAMEBUFFER, framebuffer)
+	defer gl.BindFramebuffer(gl.FRAMEBUFFER, 0)
+
+	gl.BindRenderbuffer(gl.RENDERBUFFER, renderbuffers[0])
+	gl.RenderbufferStorage(gl.RENDERBUFFER, colorFormat, int32(width), int32(height))
+	gl.FramebufferRenderbuffer(gl.FRAMEBUFFER, gl.COLOR_ATTACHMENT0, gl.RENDERBUFFER, renderbuffers[0])
+	gl.BindRenderbuffer(gl.RENDERBUFFER, renderbuffers[1])
+	gl.RenderbufferStorage(gl.RENDERBUFFER, gl.DEPTH_COMPONENT24, int32(width), int32(height))
+	gl.FramebufferRenderbuffer(gl.FRAMEBUFFER, gl.DEPTH_ATTACHMENT, gl.RENDERBUFFER, renderbuffers[1])
+	gl.BindRenderbuffer(gl.RENDERBUFFER, 0)
+
+	if status := gl.CheckFramebufferStatus(gl.FRAMEBUFFER); status != gl.FRAMEBUFFER_COMPLETE {
+		return nil, fmt.Errorf("offscreen framebuffer isn't complete (status 0x%x)", status)
+	}
+
+	gl.Viewport(0, 0, int32(width), int32(height))
+	draw()
+	return readFramebuffer(width, height), nil
+}
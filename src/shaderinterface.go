@@ -0,0 +1,228 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Checks that what one stage writes is what the next one reads, from the sources alone so it works
+// without a gpu. Only plain global in/out declarations are looked at, interface blocks get skipped.
+// #if and #ifdef aren't evaluated, declarations that would get compiled out count too
+
+type stageVariable struct {
+	name          string
+	glslType      string
+	arraySize     string // the part in brackets, empty when it's not an array
+	location      int    // -1 when there's no layout(location = n)
+	interpolation string // flat, noperspective or smooth
+	line          int    // in the preprocessed source
+}
+
+var stageDeclaration = regexp.MustCompile(`^(?:layout\s*\(([^)]*)\)\s*)?((?:\w+\s+)*?)(in|out)\s+((?:\w+\s+)*?)(\w+)\s+(\w+)\s*(?:\[\s*([^\]]*?)\s*\])?$`)
+var layoutLocation = regexp.MustCompile(`\blocation\s*=\s*(\d+)`)
+
+// The global ins or outs of a source
+func stageVariables(source string, direction string) []stageVariable {
+	var variables []stageVariable
+
+	// comments and preprocessor lines go, newlines stay so lines can still be counted
+	source = stripComments(source)
+	lines := strings.Split(source, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			lines[i] = ""
+		}
+	}
+	source = strings.Join(lines, "\n")
+
+	depth, line, statementLine := 0, 1, 1
+	var statement strings.Builder
+	for _, character := range source {
+		if character == '\n' {
+			line++
+		}
+		switch {
+		case character == '{':
+			// function bodies and interface blocks
+			depth++
+			statement.Reset()
+		case character == '}':
+			depth--
+			statement.Reset()
+		case depth > 0:
+		case character == ';':
+			text := strings.Join(strings.Fields(statement.String()), " ")
+			if match := stageDeclaration.FindStringSubmatch(text); match != nil && match[3] == direction {
+				variables = append(variables, newStageVariable(match, statementLine))
+			}
+			statement.Reset()
+		default:
+			if strings.TrimSpace(statement.String()) == "" {
+				statementLine = line
+			}
+			statement.WriteRune(character)
+		}
+	}
+
+	return variables
+}
+
+func newStageVariable(match []string, line int) stageVariable {
+	variable := stageVariable{name: match[6], glslType: match[5], arraySize: match[7], location: -1, interpolation: "smooth", line: line}
+	if location := layoutLocation.FindStringSubmatch(match[1]); location != nil {
+		variable.location, _ = strconv.Atoi(location[1])
+	}
+	for _, qualifier := range strings.Fields(match[2] + " " + match[4]) {
+		if qualifier == "flat" || qualifier == "noperspective" {
+			variable.interpolation = qualifier
+		}
+	}
+	return variable
+}
+
+func stripComments(source string) string {
+	var result strings.Builder
+	for i := 0; i < len(source); i++ {
+		switch {
+		case strings.HasPrefix(source[i:], "//"):
+			for i < len(source) && source[i] != '\n' {
+				i++
+			}
+			if i < len(source) {
+				result.WriteByte('\n')
+			}
+		case strings.HasPrefix(source[i:], "/*"):
+			end := strings.Index(source[i+2:], "*/")
+			if end < 0 {
+				end = len(source) - i - 2
+			}
+			// a comment is whitespace, it can't glue the tokens around it together
+			newlines := strings.Count(source[i:i+2+end], "\n")
+			if newlines == 0 {
+				result.WriteByte(' ')
+			}
+			result.WriteString(strings.Repeat("\n", newlines))
+			i += 2 + end + 1
+		default:
+			result.WriteByte(source[i])
+		}
+	}
+	return result.String()
+}
+
+// Where a line of a preprocessed source came from, going by the #line directives in it
+func (source *shaderSource) location(line int) string {
+	file, fileLine := -1, line
+	for i, text := range strings.SplitN(source.text, "\n", line+1)[:minInt(line, strings.Count(source.text, "\n")+1)] {
+		fields := strings.Fields(text)
+		if len(fields) == 3 && fields[0] == "#line" {
+			next, _ := strconv.Atoi(fields[1])
+			file, _ = strconv.Atoi(fields[2])
+			fileLine = line - (i + 1) + next - 1
+		}
+	}
+	if file >= 0 && file < len(source.files) {
+		return fmt.Sprintf("%s:%d", source.files[file], fileLine)
+	}
+	return fmt.Sprintf("line %d", fileLine)
+}
+
+// Every input of the consumer has to be an output of the producer with the same type, array size and
+// interpolation. Inputs with a location match outputs with that location, the rest go by name
+func checkStageInterface(producerStage string, producer *shaderSource, consumerStage string, consumer *shaderSource) error {
+	outputs := stageVariables(producer.text, "out")
+	var problems []string
+
+	for _, input := range stageVariables(consumer.text, "in") {
+		var output *stageVariable
+		for i, candidate := range outputs {
+			if input.location >= 0 && candidate.location == input.location || input.location < 0 && candidate.name == input.name {
+				output = &outputs[i]
+			}
+		}
+
+		where := fmt.Sprintf("%s input %s (%s)", consumerStage, input.name, consumer.location(input.line))
+		if output == nil {
+			if input.location >= 0 {
+				problems = append(problems, fmt.Sprintf("%s: the %s shader doesn't write location %d", where, producerStage, input.location))
+			} else {
+				problems = append(problems, fmt.Sprintf("%s: the %s shader doesn't write it", where, producerStage))
+			}
+			continue
+		}
+
+		written := fmt.Sprintf("the %s shader writes %s at %s", producerStage, output.name, producer.location(output.line))
+		switch {
+		case output.glslType != input.glslType:
+			problems = append(problems, fmt.Sprintf("%s is a %s but %s as a %s", where, input.glslType, written, output.glslType))
+		case output.arraySize != input.arraySize:
+			problems = append(problems, fmt.Sprintf("%s is %s but %s as %s", where, arrayDescription(input.arraySize), written, arrayDescription(output.arraySize)))
+		case output.interpolation != input.interpolation:
+			problems = append(problems, fmt.Sprintf("%s is %s but %s as %s", where, input.interpolation, written, output.interpolation))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("The %s and %s shaders don't fit together:\n  %s", producerStage, consumerStage, strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+func arrayDescription(size string) string {
+	if size == "" {
+		return "not an array"
+	}
+	return "an array of " + size
+}
+
+// `build shaders` checks the stage interfaces of shader pairs, without opening a window
+func runShadersCommand(args []string) int {
+	usage := "usage: shaders [-version n] [-assets dir] [<vertex shader> <fragment shader>]..."
+
+	flags := flag.NewFlagSet("shaders", flag.ContinueOnError)
+	version := flags.Int("version", 460, "glsl version the shaders get preprocessed for")
+	flags.Func("assets", "directory to read the shaders from before the embedded assets", func(directory string) error {
+		return assetFS.AddDirectory(directory)
+	})
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg()%2 != 0 {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+	assetFS.AddEmbedded()
+	defer assetFS.Close()
+
+	pairs := flags.Args()
+	if len(pairs) == 0 {
+		pairs = []string{"assets/vertex.glsl", "assets/frag.glsl", "assets/skybox_vertex.glsl", "assets/skybox_frag.glsl"}
+	}
+
+	preprocessor := &shaderPreprocessor{version: *version, profile: "core", defines: map[string]string{}}
+	status := 0
+	for i := 0; i < len(pairs); i += 2 {
+		vertex, err := preprocessor.load(pairs[i], map[string]string{"VERTEX_SHADER": "1"})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fragment, err := preprocessor.load(pairs[i+1], map[string]string{"FRAGMENT_SHADER": "1"})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		if err := checkStageInterface("vertex", vertex, "fragment", fragment); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 1
+			continue
+		}
+		fmt.Printf("%s -> %s ok\n", pairs[i], pairs[i+1])
+	}
+	return status
+}
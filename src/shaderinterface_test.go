@@ -0,0 +1,105 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	gl "github.com/go-gl/gl/v4.6-core/gl"
+)
+
+func TestCheckStageInterface(t *testing.T) {
+	tests := []struct {
+		name     string
+		vertex   string
+		fragment string
+		problem  string // empty when they fit
+	}{
+		{"matching", "out vec2 v_UV;\nflat out int v_Layer;", "in vec2 v_UV;\nflat in int v_Layer;", ""},
+		{"unused output", "out vec2 v_UV;\nout vec3 v_Normal;", "in vec2 v_UV;", ""},
+		{"type", "out vec2 v_UV;", "in vec3 v_UV;", "v_UV (fragment.glsl:1) is a vec3 but the vertex shader writes v_UV at vertex.glsl:1 as a vec2"},
+		{"array size", "out vec3 v_Lights[2];", "in vec3 v_Lights[3];", "is an array of 3 but the vertex shader writes v_Lights at vertex.glsl:1 as an array of 2"},
+		{"not an array", "out vec3 v_Lights[2];", "in vec3 v_Lights;", "is not an array but"},
+		{"interpolation", "flat out int v_Layer;", "in int v_Layer;", "is smooth but the vertex shader writes v_Layer at vertex.glsl:1 as flat"},
+		{"noperspective", "noperspective out vec2 v_UV;", "noperspective in vec2 v_UV;", ""},
+		{"name", "out vec2 v_TexCoord;", "in vec2 v_UV;", "v_UV (fragment.glsl:1): the vertex shader doesn't write it"},
+		{"location", "layout(location = 0) out vec2 v_UV;", "layout(location = 1) in vec2 v_UV;", "the vertex shader doesn't write location 1"},
+		{"location with other names", "layout(location = 2) out vec2 a;", "layout(location=2) in vec2 b;", ""},
+		{"qualifiers in any order", "out flat int v_Layer;", "flat in int v_Layer;\nlayout(location = 0) out vec4 color;", ""},
+		{"location against a name", "out vec2 v_UV;", "layout(location = 0) in vec2 v_UV;", "doesn't write location 0"},
+		{"line comment", "// out vec2 v_UV;", "in vec2 v_UV;", "doesn't write it"},
+		{"block comment", "/* out vec2 v_UV;\n*/ out vec3 v_Normal;", "in vec2 v_UV;\nin vec3 v_Normal;", "v_UV (fragment.glsl:1): the vertex shader doesn't write it"},
+		{"declaration after a comment", "/* first\nsecond */ out vec2 v_UV;", "in vec3 v_UV;", "writes v_UV at vertex.glsl:2 as a vec2"},
+		{"function parameters", "void f(out vec2 v_UV) {}", "in vec2 v_UV;", "doesn't write it"},
+		{"interface blocks", "out Block { vec2 uv; } v_Block;", "in vec2 v_UV;", "doesn't write it"},
+		{"inside functions", "void main() { out vec2 v_UV; }", "in vec2 v_UV;", "doesn't write it"},
+	}
+
+	for _, test := range tests {
+		vertex := &shaderSource{text: "#version 460 core\n#line 1 0\n" + test.vertex + "\n", files: []string{"vertex.glsl"}}
+		fragment := &shaderSource{text: "#version 460 core\n#line 1 0\n" + test.fragment + "\n", files: []string{"fragment.glsl"}}
+
+		err := checkStageInterface("vertex", vertex, "fragment", fragment)
+		switch {
+		case test.problem == "" && err != nil:
+			t.Errorf("%s: %v", test.name, err)
+		case test.problem != "" && err == nil:
+			t.Errorf("%s: should have failed with %q", test.name, test.problem)
+		case test.problem != "" && !strings.Contains(err.Error(), test.problem):
+			t.Errorf("%s: %q doesn't say %q", test.name, err, test.problem)
+		}
+	}
+}
+
+func TestStripComments(t *testing.T) {
+	source := "a // b\n/* c\nd */ e\n/* f */ g/**/h /* i"
+	got := stripComments(source)
+	if want := "a \n\n e\n  g h  "; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if strings.Count(got, "\n") != strings.Count(source, "\n") {
+		t.Error("stripping comments changed the line count")
+	}
+}
+
+func TestShaderSourceLocation(t *testing.T) {
+	source := &shaderSource{
+		text:  "#version 460 core\n#define X 1\n#line 1 0\na\nb\n#line 1 1\nc\n#line 5 2\nd\n#line 3 0\ne\n",
+		files: []string{"main.glsl", "common.glsl", "other.glsl"},
+	}
+
+	tests := map[int]string{
+		1:  "line 1",
+		4:  "main.glsl:1",
+		5:  "main.glsl:2",
+		7:  "common.glsl:1",
+		9:  "other.glsl:5",
+		11: "main.glsl:3",
+	}
+	for line, want := range tests {
+		if got := source.location(line); got != want {
+			t.Errorf("line %d: got %s, want %s", line, got, want)
+		}
+	}
+}
+
+func TestAttributeAccepts(t *testing.T) {
+	tests := []struct {
+		declared, fed uint32
+		accepts       bool
+	}{
+		{gl.FLOAT_VEC4, gl.FLOAT_VEC3, true},
+		{gl.FLOAT_VEC4, gl.FLOAT, true},
+		{gl.FLOAT_VEC2, gl.FLOAT_VEC2, true},
+		{gl.FLOAT_VEC2, gl.FLOAT_VEC3, false},
+		{gl.INT, gl.INT, true},
+		{gl.INT, gl.FLOAT, false},
+		{gl.FLOAT, gl.INT, false},
+		{gl.FLOAT_MAT4, gl.FLOAT_VEC4, false},
+	}
+
+	for _, test := range tests {
+		if got := attributeAccepts(test.declared, test.fed); got != test.accepts {
+			t.Errorf("%s taking %s: got %v, want %v", glslTypeName(test.declared), glslTypeName(test.fed), got, test.accepts)
+		}
+	}
+}
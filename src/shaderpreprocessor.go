@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	gl "github.com/go-gl/gl/v4.6-core/gl"
+)
+
+// Puts shader sources together before they get compiled. The #version line comes from the context,
+// defines can come from Go, and #include "file" pulls in other shader assets (relative to the including
+// file) so code can be shared. Files that say #pragma once only get included the first time.
+// Every file gets its own source string number in #line directives, glsl doesn't know about file names,
+// so compile logs get the numbers turned back into names afterwards
+type shaderPreprocessor struct {
+	version int    // glsl version, 330 for opengl 3.3
+	profile string // core or compatibility
+
+	defines map[string]string
+}
+
+// A preprocessed shader, ready to compile
+type shaderSource struct {
+	text  string
+	files []string // file names by source string number
+}
+
+// Uses the version of the current context, has to be called after gl.Init
+func newShaderPreprocessor() *shaderPreprocessor {
+	var major, minor, profileMask int32
+	gl.GetIntegerv(gl.MAJOR_VERSION, &major)
+	gl.GetIntegerv(gl.MINOR_VERSION, &minor)
+	gl.GetIntegerv(gl.CONTEXT_PROFILE_MASK, &profileMask)
+
+	profile := "compatibility"
+	if profileMask&gl.CONTEXT_CORE_PROFILE_BIT != 0 {
+		profile = "core"
+	}
+
+	// since 3.3 the glsl version is the same as the gl one
+	return &shaderPreprocessor{version: int(major*100 + minor*10), profile: profile, defines: map[string]string{}}
+}
+
+// NAME or NAME=VALUE, the way -shader-define takes them
+func parseShaderDefine(define string) (string, string, error) {
+	name, value, _ := strings.Cut(define, "=")
+	if strings.TrimSpace(name) == "" {
+		return "", "", fmt.Errorf("%q doesn't have a name to define", define)
+	}
+	return name, value, nil
+}
+
+// Defines name for every shader that gets preprocessed after this, value can be empty
+func (preprocessor *shaderPreprocessor) define(name string, value string) {
+	preprocessor.defines[name] = value
+}
+
+var (
+	includeDirective = regexp.MustCompile(`^\s*#\s*include\s+"([^"]+)"\s*(//.*)?$`)
+	versionDirective = regexp.MustCompile(`^\s*#\s*version\s+(\d+)`)
+	pragmaOnce       = regexp.MustCompile(`^\s*#\s*pragma\s+once\s*(//.*)?$`)
+)
+
+// Loads a shader asset and everything it includes. The defines are added to the preprocessor's own
+// ones for this shader only, like the stage the shader is for
+func (preprocessor *shaderPreprocessor) load(name string, defines map[string]string) (*shaderSource, error) {
+	source := &shaderSource{}
+
+	var header strings.Builder
+	fmt.Fprintf(&header, "#version %d %s\n", preprocessor.version, preprocessor.profile)
+
+	all := map[string]string{}
+	for define, value := range preprocessor.defines {
+		all[define] = value
+	}
+	for define, value := range defines {
+		all[define] = value
+	}
+	names := make([]string, 0, len(all))
+	for define := range all {
+		names = append(names, define)
+	}
+	sort.Strings(names)
+	for _, define := range names {
+		fmt.Fprintf(&header, "#define %s %s\n", define, all[define])
+	}
+
+	state := &includeState{once: map[string]bool{}}
+	var body strings.Builder
+	if err := preprocessor.include(name, source, state, &body); err != nil {
+		return nil, err
+	}
+
+	source.text = header.String() + body.String()
+	return source, nil
+}
+
+type includeState struct {
+	stack []string        // the files that are being included right now, for finding cycles
+	once  map[string]bool // files with #pragma once that already got included
+}
+
+func (preprocessor *shaderPreprocessor) include(name string, source *shaderSource, state *includeState, output *strings.Builder) error {
+	for i, including := range state.stack {
+		if including == name {
+			return fmt.Errorf("Shader include cycle: %s", strings.Join(append(state.stack[i:], name), " -> "))
+		}
+	}
+	if state.once[name] {
+		return nil
+	}
+
+	data, err := loadAsset(name)
+	if err != nil {
+		if len(state.stack) > 0 {
+			return fmt.Errorf("%s includes %s: %v", state.stack[len(state.stack)-1], name, err)
+		}
+		return err
+	}
+
+	state.stack = append(state.stack, name)
+	defer func() { state.stack = state.stack[:len(state.stack)-1] }()
+
+	file := len(source.files)
+	source.files = append(source.files, name)
+	fmt.Fprintf(output, "#line 1 %d\n", file)
+
+	// commented out directives don't count, stripping keeps the line numbers the same
+	lines := strings.Split(stripComments(strings.ReplaceAll(string(data), "\r\n", "\n")), "\n")
+	for i, line := range lines {
+		lineNumber := i + 1
+
+		if match := versionDirective.FindStringSubmatch(line); match != nil {
+			// the file's version is the oldest one it works with, the real one is always the context's
+			version, _ := strconv.Atoi(match[1])
+			if version > preprocessor.version {
+				return fmt.Errorf("%s:%d needs glsl %d but the context only does %d", name, lineNumber, version, preprocessor.version)
+			}
+			output.WriteString("\n")
+			continue
+		}
+
+		if pragmaOnce.MatchString(line) {
+			state.once[name] = true
+			output.WriteString("\n")
+			continue
+		}
+
+		if match := includeDirective.FindStringSubmatch(line); match != nil {
+			if err := preprocessor.include(path.Join(path.Dir(name), match[1]), source, state, output); err != nil {
+				return err
+			}
+			// back to where the include was
+			fmt.Fprintf(output, "#line %d %d\n", lineNumber+1, file)
+			continue
+		}
+
+		output.WriteString(line)
+		if i < len(lines)-1 {
+			output.WriteString("\n")
+		}
+	}
+	output.WriteString("\n")
+
+	return nil
+}
+
+// Drivers all write the source string and line a bit differently: 0(12) on nvidia, 0:12(5) on mesa,
+// ERROR: 0:12 on amd and intel. They start a line, or come right after the "Failed to compile" part
+var logLocation = regexp.MustCompile(`(?m)(^|: )((?:ERROR|WARNING): )?(\d+)([:(])(\d+)`)
+
+// Replaces source string numbers in a compile log with file names
+func (source *shaderSource) translateLog(log string) string {
+	return logLocation.ReplaceAllStringFunc(log, func(location string) string {
+		match := logLocation.FindStringSubmatch(location)
+		file, _ := strconv.Atoi(match[3])
+		if file >= len(source.files) {
+			return location
+		}
+		return match[1] + match[2] + source.files[file] + match[4] + match[5]
+	})
+}
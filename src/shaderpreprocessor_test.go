@@ -0,0 +1,167 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func testShaderPreprocessor(t *testing.T, files map[string]string) *shaderPreprocessor {
+	fsys := fstest.MapFS{}
+	for name, text := range files {
+		fsys[name] = &fstest.MapFile{Data: []byte(text)}
+	}
+	useTestAssets(t, fsys)
+	return &shaderPreprocessor{version: 460, profile: "core", defines: map[string]string{}}
+}
+
+// The file and line every line that has text in it came from, going by the #line directives
+func sourceLocations(source *shaderSource) map[string]string {
+	locations := map[string]string{}
+	for i, line := range strings.Split(source.text, "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			locations[line] = source.location(i + 1)
+		}
+	}
+	return locations
+}
+
+func TestShaderIncludes(t *testing.T) {
+	preprocessor := testShaderPreprocessor(t, map[string]string{
+		"shaders/main.glsl": "#version 330\n#include \"lib/common.glsl\"\n\n#include \"lib/common.glsl\"\nvoid main() {}\r\n#include \"lib/twice.glsl\"\n#include \"lib/twice.glsl\"\nfloat end;\n",
+		// relative to the file that includes it, the trailing comment is fine
+		"shaders/lib/common.glsl": "#pragma once\n#include \"util.glsl\" // helpers\nfloat common;",
+		"shaders/lib/util.glsl":   "\n\nfloat util;\n",
+		// no #pragma once, it gets included every time
+		"shaders/lib/twice.glsl": "float twice;\n",
+	})
+	preprocessor.define("B", "2")
+	preprocessor.define("A", "")
+
+	source, err := preprocessor.load("shaders/main.glsl", map[string]string{"B": "3", "STAGE": "vertex"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the context's version, then the defines sorted with the shader's own ones winning
+	if header := "#version 460 core\n#define A \n#define B 3\n#define STAGE vertex\n#line 1 0\n"; !strings.HasPrefix(source.text, header) {
+		t.Errorf("the source starts with %q, want %q", source.text[:minInt(len(header), len(source.text))], header)
+	}
+	if want := []string{"shaders/main.glsl", "shaders/lib/common.glsl", "shaders/lib/util.glsl", "shaders/lib/twice.glsl", "shaders/lib/twice.glsl"}; !equalStrings(source.files, want) {
+		t.Errorf("got files %v, want %v", source.files, want)
+	}
+	for text, count := range map[string]int{"float common;": 1, "float util;": 1, "float twice;": 2, "#version 330": 0, "#pragma once": 0, "#include": 0} {
+		if got := strings.Count(source.text, text); got != count {
+			t.Errorf("%q is in the source %d times, want %d", text, got, count)
+		}
+	}
+
+	// every line has to be where the file had it, so compile errors point at the right place
+	locations := sourceLocations(source)
+	for text, want := range map[string]string{
+		"float common;":  "shaders/lib/common.glsl:3",
+		"float util;":    "shaders/lib/util.glsl:3",
+		"void main() {}": "shaders/main.glsl:5",
+		"float twice;":   "shaders/lib/twice.glsl:1",
+		"float end;":     "shaders/main.glsl:8",
+	} {
+		if got := locations[text]; got != want {
+			t.Errorf("%q is at %s, want %s", text, got, want)
+		}
+	}
+}
+
+func TestShaderIncludeComments(t *testing.T) {
+	preprocessor := testShaderPreprocessor(t, map[string]string{
+		"main.glsl": "/* #include \"missing.glsl\"\n#include \"missing.glsl\"\n*/\n// #include \"missing.glsl\"\nfloat /* inline */ value;\n#include \"real.glsl\" /* the real one */\nfloat after;\n",
+		"real.glsl": "float real;\n",
+	})
+
+	source, err := preprocessor.load("main.glsl", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(source.text, "missing") || !equalStrings(source.files, []string{"main.glsl", "real.glsl"}) {
+		t.Errorf("commented out includes got expanded:\n%s", source.text)
+	}
+
+	locations := sourceLocations(source)
+	for text, want := range map[string]string{"float   value;": "main.glsl:5", "float real;": "real.glsl:1", "float after;": "main.glsl:7"} {
+		if got := locations[text]; got != want {
+			t.Errorf("%q is at %s, want %s", text, got, want)
+		}
+	}
+}
+
+func TestShaderIncludeErrors(t *testing.T) {
+	preprocessor := testShaderPreprocessor(t, map[string]string{
+		"a.glsl":       "#include \"b.glsl\"\n",
+		"b.glsl":       "#include \"dir/c.glsl\"\n",
+		"dir/c.glsl":   "#pragma once\n#include \"../a.glsl\"\n",
+		"self.glsl":    "#pragma once\n#include \"self.glsl\"\n",
+		"missing.glsl": "\n#include \"nowhere.glsl\"\n",
+		"new.glsl":     "#version 500\n",
+	})
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		// #pragma once doesn't hide a cycle, the file isn't done yet
+		{"a.glsl", "Shader include cycle: a.glsl -> b.glsl -> dir/c.glsl -> a.glsl"},
+		{"self.glsl", "Shader include cycle: self.glsl -> self.glsl"},
+		{"missing.glsl", "missing.glsl includes nowhere.glsl"},
+		{"new.glsl", "new.glsl:1 needs glsl 500"},
+	}
+	for _, test := range tests {
+		_, err := preprocessor.load(test.name, nil)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got %v, want an error about %q", test.name, err, test.want)
+		}
+	}
+}
+
+func TestTranslateLog(t *testing.T) {
+	source := &shaderSource{files: []string{"main.glsl", "lib/common.glsl"}}
+	tests := map[string]string{
+		// nvidia
+		"0(12) : error C1008: undefined variable \"x\"": "main.glsl(12) : error C1008: undefined variable \"x\"",
+		// mesa
+		"1:4(10): error: syntax error": "lib/common.glsl:4(10): error: syntax error",
+		// amd and intel, and after the part that says which shader failed
+		"ERROR: 1:7: 'x' : undeclared identifier":                  "ERROR: lib/common.glsl:7: 'x' : undeclared identifier",
+		"Failed to compile: ERROR: 0:3: '' : syntax error":         "Failed to compile: ERROR: main.glsl:3: '' : syntax error",
+		"WARNING: 0:2: extension isn't supported\n1(3) : error C0": "WARNING: main.glsl:2: extension isn't supported\nlib/common.glsl(3) : error C0",
+		// numbers that aren't files, or aren't locations at all, stay
+		"5:1(2): error: nothing": "5:1(2): error: nothing",
+		"error: 0 errors, 1:2":   "error: 0 errors, 1:2",
+	}
+	for log, want := range tests {
+		if got := source.translateLog(log); got != want {
+			t.Errorf("%q: got %q, want %q", log, got, want)
+		}
+	}
+}
+
+func TestParseShaderDefine(t *testing.T) {
+	tests := []struct {
+		define, name, value string
+	}{
+		{"DEBUG", "DEBUG", ""},
+		{"LIGHTS=4", "LIGHTS", "4"},
+		{"EXPR=a=b", "EXPR", "a=b"},
+		{"EMPTY=", "EMPTY", ""},
+	}
+	for _, test := range tests {
+		name, value, err := parseShaderDefine(test.define)
+		if err != nil || name != test.name || value != test.value {
+			t.Errorf("%q: got %q, %q, %v", test.define, name, value, err)
+		}
+	}
+
+	for _, define := range []string{"", "=1", " =1"} {
+		if _, _, err := parseShaderDefine(define); err == nil {
+			t.Errorf("%q has no name but worked", define)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	gl "github.com/go-gl/gl/v4.6-core/gl"
+
+	glm "github.com/go-gl/mathgl/mgl32"
+)
+
+// Draws a cubemap behind everything else. It's one fullscreen triangle at max depth, every pixel
+// looks up the cubemap in the direction the camera sees it in. Only the camera's rotation matters,
+// moving around doesn't get you any closer to the sky
+type skybox struct {
+	program *Program
+	vao     uint32 // empty, the vertices come from gl_VertexID but core profile still wants one bound
+}
+
+func newSkybox(preprocessor *shaderPreprocessor) (*skybox, error) {
+	program, err := LoadProgram(preprocessor, "assets/skybox_vertex.glsl", "assets/skybox_frag.glsl")
+	if err != nil {
+		return nil, err
+	}
+	err = program.CheckInterface(ExpectedInterface{
+		Uniforms: []ShaderVariable{
+			{Name: "u_InverseViewProjection", Type: gl.FLOAT_MAT4},
+			{Name: "u_Skybox", Type: gl.SAMPLER_CUBE},
+			{Name: "u_EncodeSRGB", Type: gl.BOOL},
+		},
+	})
+	if err != nil {
+		program.Delete()
+		return nil, err
+	}
+	if err := program.SetSampler("u_Skybox", 0); err != nil {
+		program.Delete()
+		return nil, err
+	}
+
+	sky := &skybox{program: program}
+	gl.GenVertexArrays(1, &sky.vao)
+
+	return sky, nil
+}
+
+// Has to be drawn after the opaque geometry, the depth test skips every pixel that's already covered.
+// The texture has to be a cubemap, it gets bound to unit 0
+func (sky *skybox) draw(texture *Texture, projection glm.Mat4, view glm.Mat4) {
+	rotation := view.Mat3().Mat4()
+	inverseViewProjection := projection.Mul4(rotation).Inv()
+
+	sky.program.Use()
+	mustSetUniform(sky.program.SetMat4("u_InverseViewProjection", inverseViewProjection))
+
+	gl.ActiveTexture(gl.TEXTURE0)
+	gl.BindTexture(texture.Target, texture.Handle)
+	gl.BindSampler(0, texture.Sampler)
+
+	// the cleared depth is exactly 1, which is where the sky is, so it has to pass on equal too.
+	// Nothing should end up behind the sky either, so it doesn't write any depth
+	gl.DepthFunc(gl.LEQUAL)
+	gl.DepthMask(false)
+
+	gl.BindVertexArray(sky.vao)
+	gl.DrawArrays(gl.TRIANGLES, 0, 3)
+
+	gl.DepthMask(true)
+	gl.DepthFunc(gl.LESS)
+}
+
+func (sky *skybox) delete() {
+	gl.DeleteVertexArrays(1, &sky.vao)
+	sky.program.Delete()
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+
+	gl "github.com/go-gl/gl/v4.6-core/gl"
+)
+
+// Color textures are sRGB encoded, but lighting and blending only make sense on linear values.
+// srgb textures get decoded to linear when they're sampled, everything in the shaders happens in linear,
+// and the framebuffer encodes back to sRGB when it gets written to (blending happens before that, so
+// in linear too). When the default framebuffer can't do that the fragment shader encodes instead,
+// blending stays in gamma space then but at least everything else is right
+type colorPipeline struct {
+	linear          bool // false is the old way of doing things, gamma encoded from start to end
+	framebufferSRGB bool // the default framebuffer can encode to sRGB by itself
+
+	programs []*Program
+}
+
+// Expects every fragment shader to have a u_EncodeSRGB uniform
+func newColorPipeline(programs ...*Program) *colorPipeline {
+	pipeline := &colorPipeline{programs: programs}
+
+	var encoding int32
+	gl.BindFramebuffer(gl.FRAMEBUFFER, 0)
+	gl.GetFramebufferAttachmentParameteriv(gl.FRAMEBUFFER, gl.BACK_LEFT, gl.FRAMEBUFFER_ATTACHMENT_COLOR_ENCODING, &encoding)
+	pipeline.framebufferSRGB = encoding == gl.SRGB
+
+	return pipeline
+}
+
+func (pipeline *colorPipeline) set(linear bool, assets *AssetManager) {
+	pipeline.linear = linear
+	assets.SetSRGB(linear)
+
+	if linear && pipeline.framebufferSRGB {
+		gl.Enable(gl.FRAMEBUFFER_SRGB)
+	} else {
+		gl.Disable(gl.FRAMEBUFFER_SRGB)
+	}
+
+	for _, program := range pipeline.programs {
+		mustSetUniform(program.SetBool("u_EncodeSRGB", linear && !pipeline.framebufferSRGB))
+	}
+}
+
+func (pipeline *colorPipeline) String() string {
+	switch {
+	case !pipeline.linear:
+		return "gamma space (old)"
+	case pipeline.framebufferSRGB:
+		return "linear, sRGB framebuffer"
+	}
+	return "linear, encoded in the shader"
+}
+
+func (pipeline *colorPipeline) toggle(assets *AssetManager) {
+	pipeline.set(!pipeline.linear, assets)
+	fmt.Println("Color pipeline: " + pipeline.String())
+}
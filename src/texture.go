@@ -0,0 +1,723 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"main/src/util"
+
+	gl "github.com/go-gl/gl/v4.6-core/gl"
+)
+
+type textureFormat int
+
+const (
+	textureGray textureFormat = iota
+	textureRGB
+	textureRGBA
+	textureRGB16F // half floats, hdr images
+	textureRGB32F
+
+	// block compressed, these only come from texture containers (ktx2, dds)
+	textureBC1 // rgb + 1 bit alpha
+	textureBC2 // rgb + 4 bit alpha
+	textureBC3 // rgb + interpolated alpha
+	textureBC4 // one channel
+	textureBC4Signed
+	textureBC5 // two channels, usually normal maps
+	textureBC5Signed
+	textureBC6H // hdr rgb
+	textureBC6HSigned
+	textureBC7 // high quality rgba
+)
+
+// The biggest textures the decoders accept, about as big as gl implementations go. A header that says
+// more is broken, believing it would only mean allocating gigabytes for nothing
+const (
+	maxTextureSize   = 1 << 15
+	maxTextureLayers = 2048
+)
+
+// the only srgb versions of s3tc are in an extension that the core profile bindings don't have
+const (
+	glCompressedSRGBAlphaS3TCDXT1 = 0x8C4D
+	glCompressedSRGBAlphaS3TCDXT3 = 0x8C4E
+	glCompressedSRGBAlphaS3TCDXT5 = 0x8C4F
+)
+
+func (format textureFormat) String() string {
+	names := []string{"gray", "rgb", "rgba", "rgb16f", "rgb32f", "bc1", "bc2", "bc3", "bc4", "bc4 signed", "bc5", "bc5 signed", "bc6h", "bc6h signed", "bc7"}
+	if int(format) < len(names) {
+		return names[format]
+	}
+	return fmt.Sprintf("format %d", int(format))
+}
+
+func (format textureFormat) compressed() bool {
+	return format >= textureBC1
+}
+
+// the pixels are floats (half floats for rgb16f) instead of bytes, they aren't limited to 0-1 and never srgb
+func (format textureFormat) float() bool {
+	return format == textureRGB16F || format == textureRGB32F
+}
+
+// bytes per 4x4 block
+func (format textureFormat) blockBytes() int {
+	switch format {
+	case textureBC1, textureBC4, textureBC4Signed:
+		return 8
+	}
+	return 16
+}
+
+// what the blocks turn into when they get decoded on the cpu
+func (format textureFormat) decodedFormat() textureFormat {
+	switch format {
+	case textureBC4, textureBC4Signed:
+		return textureGray
+	case textureBC5, textureBC5Signed:
+		return textureRGB
+	case textureBC6H, textureBC6HSigned:
+		return textureRGB16F
+	case textureBC1, textureBC2, textureBC3, textureBC7:
+		return textureRGBA
+	}
+	return format
+}
+
+// size of one face of a width x height image
+func (format textureFormat) imageSize(width int, height int) int {
+	if format.compressed() {
+		return ((width + 3) / 4) * ((height + 3) / 4) * format.blockBytes()
+	}
+	return width * height * format.pixelBytes()
+}
+
+func (format textureFormat) channels() int {
+	switch format.decodedFormat() {
+	case textureGray:
+		return 1
+	case textureRGB, textureRGB16F, textureRGB32F:
+		return 3
+	}
+	return 4
+}
+
+// bytes per pixel of the decoded format
+func (format textureFormat) pixelBytes() int {
+	switch format.decodedFormat() {
+	case textureRGB16F:
+		return 3 * 2
+	case textureRGB32F:
+		return 3 * 4
+	}
+	return format.channels()
+}
+
+// the type the pixels get uploaded as, only matters for uncompressed formats
+func (format textureFormat) pixelType() uint32 {
+	switch format {
+	case textureRGB16F:
+		return gl.HALF_FLOAT
+	case textureRGB32F:
+		return gl.FLOAT
+	}
+	return gl.UNSIGNED_BYTE
+}
+
+// internal format, pixel format. srgb textures get decoded to linear when they're sampled. Compressed
+// formats don't have a pixel format
+func (format textureFormat) glFormats(srgb bool) (int32, uint32) {
+	switch format {
+	case textureBC1:
+		if srgb {
+			return glCompressedSRGBAlphaS3TCDXT1, 0
+		}
+		return gl.COMPRESSED_RGBA_S3TC_DXT1_EXT, 0
+	case textureBC2:
+		if srgb {
+			return glCompressedSRGBAlphaS3TCDXT3, 0
+		}
+		return gl.COMPRESSED_RGBA_S3TC_DXT3_EXT, 0
+	case textureBC3:
+		if srgb {
+			return glCompressedSRGBAlphaS3TCDXT5, 0
+		}
+		return gl.COMPRESSED_RGBA_S3TC_DXT5_EXT, 0
+	case textureBC4:
+		return gl.COMPRESSED_RED_RGTC1, 0
+	case textureBC4Signed:
+		return gl.COMPRESSED_SIGNED_RED_RGTC1, 0
+	case textureBC5:
+		return gl.COMPRESSED_RG_RGTC2, 0
+	case textureBC5Signed:
+		return gl.COMPRESSED_SIGNED_RG_RGTC2, 0
+	case textureBC6H:
+		return gl.COMPRESSED_RGB_BPTC_UNSIGNED_FLOAT, 0
+	case textureBC6HSigned:
+		return gl.COMPRESSED_RGB_BPTC_SIGNED_FLOAT, 0
+	case textureBC7:
+		if srgb {
+			return gl.COMPRESSED_SRGB_ALPHA_BPTC_UNORM, 0
+		}
+		return gl.COMPRESSED_RGBA_BPTC_UNORM, 0
+	case textureRGB16F:
+		return gl.RGB16F, gl.RGB
+	case textureRGB32F:
+		return gl.RGB32F, gl.RGB
+	}
+
+	switch {
+	case format == textureGray && srgb:
+		// there's no single channel srgb format in core gl, the swizzle only looks at red anyway
+		return gl.SRGB8, gl.RED
+	case format == textureGray:
+		return gl.R8, gl.RED
+	case format == textureRGB && srgb:
+		return gl.SRGB8, gl.RGB
+	case format == textureRGB:
+		return gl.RGB8, gl.RGB
+	case srgb:
+		return gl.SRGB8_ALPHA8, gl.RGBA
+	}
+	return gl.RGBA8, gl.RGBA
+}
+
+type textureData struct {
+	width  int32
+	height int32
+	format textureFormat
+	pixels []uint8 // tightly packed rows, bottom row first like opengl wants. Straight alpha unless the descriptor asks for premultiplied. Float formats keep their floats in here as little endian bytes
+	srgb   bool    // the color channels are sRGB encoded
+
+	mipmaps []*textureData // every level after this one, empty if the texture doesn't have any
+
+	// containers can also hold cubemaps and arrays, pixels then has every face of the level back to back,
+	// layer by layer and +x -x +y -y +z -z inside a layer for cubemaps. cubemap faces aren't flipped
+	layers  int // 0 when it's not an array
+	cubemap bool
+}
+
+func (data *textureData) faceCount() int {
+	count := 1
+	if data.layers > 0 {
+		count = data.layers
+	}
+	if data.cubemap {
+		count *= 6
+	}
+	return count
+}
+
+func (data *textureData) target() uint32 {
+	switch {
+	case data.cubemap && data.layers > 0:
+		return gl.TEXTURE_CUBE_MAP_ARRAY
+	case data.cubemap:
+		return gl.TEXTURE_CUBE_MAP
+	case data.layers > 0:
+		return gl.TEXTURE_2D_ARRAY
+	}
+	return gl.TEXTURE_2D
+}
+
+func (data *textureData) rowBytes() int {
+	return int(data.width) * data.format.pixelBytes()
+}
+
+// size of the pixels including the mipmaps
+func (data *textureData) byteSize() int {
+	size := len(data.pixels)
+	for _, level := range data.mipmaps {
+		size += len(level.pixels)
+	}
+	return size
+}
+
+func decodeTexture(imageBytes []byte, descriptor TextureDescriptor) (*textureData, error) {
+	if isTextureContainer(imageBytes) {
+		return decodeTextureContainer(imageBytes, descriptor)
+	}
+
+	var data *textureData
+	if isHDR(imageBytes) {
+		var err error
+		if data, err = decodeHDR(imageBytes, descriptor.hdrFormat()); err != nil {
+			return nil, err
+		}
+	} else {
+		decodedImage, _, err := image.Decode(bytes.NewReader(imageBytes))
+		if err != nil {
+			return nil, err
+		}
+		data = imageToTexture(decodedImage)
+		data.srgb = descriptor.SRGB
+	}
+	if descriptor.Premultiply {
+		premultiplyAlpha(data)
+	}
+
+	if descriptor.Cubemap != "" {
+		return imageToCubemap(data, descriptor)
+	}
+	if descriptor.hasMipmaps() {
+		data.mipmaps = buildMipmaps(data, descriptor.mipmapOptions())
+	}
+
+	return data, nil
+}
+
+func isTextureContainer(data []byte) bool {
+	return bytes.HasPrefix(data, ktx2Identifier) || bytes.HasPrefix(data, ddsMagic)
+}
+
+// ktx2 and dds files already have the pixels in the format the gpu wants, mip levels included
+func decodeTextureContainer(containerBytes []byte, descriptor TextureDescriptor) (*textureData, error) {
+	var data *textureData
+	var topRowFirst bool
+	var err error
+
+	if bytes.HasPrefix(containerBytes, ktx2Identifier) {
+		data, topRowFirst, err = decodeKTX2(containerBytes)
+	} else {
+		data, err = decodeDDS(containerBytes, descriptor.SRGB)
+		topRowFirst = true
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if topRowFirst && !data.cubemap {
+		if err := flipTexture(data); err != nil {
+			util.ThrowWarning(err.Error())
+		}
+	}
+	if descriptor.Premultiply {
+		if data.format.compressed() {
+			util.ThrowWarning(fmt.Sprintf("Can't premultiply %v blocks, store the texture premultiplied instead", data.format))
+		}
+		for _, level := range append([]*textureData{data}, data.mipmaps...) {
+			premultiplyAlpha(level)
+		}
+	}
+
+	// only plain 2D textures without compression can get their mipmaps built here
+	if len(data.mipmaps) == 0 && descriptor.hasMipmaps() && !data.format.compressed() && data.faceCount() == 1 {
+		options := descriptor.mipmapOptions()
+		options.srgb = data.srgb
+		data.mipmaps = buildMipmaps(data, options)
+	}
+
+	return data, nil
+}
+
+// Puts same sized faces (cubemap faces or array layers) back to back into one texture, with mipmaps if
+// the descriptor wants them. Whoever calls it sets layers or cubemap on the result
+func stackFaces(faces []*textureData, descriptor TextureDescriptor) *textureData {
+	first := faces[0]
+
+	levels := [][]*textureData{faces}
+	if descriptor.hasMipmaps() {
+		options := descriptor.mipmapOptions()
+		options.srgb = first.srgb
+		faceMipmaps := make([][]*textureData, len(faces))
+		for i, face := range faces {
+			faceMipmaps[i] = buildMipmaps(face, options)
+		}
+		for level := range faceMipmaps[0] {
+			levelFaces := make([]*textureData, len(faces))
+			for i := range faces {
+				levelFaces[i] = faceMipmaps[i][level]
+			}
+			levels = append(levels, levelFaces)
+		}
+	}
+
+	var stacked []*textureData
+	for _, levelFaces := range levels {
+		level := &textureData{
+			width:  levelFaces[0].width,
+			height: levelFaces[0].height,
+			format: first.format,
+			srgb:   first.srgb,
+		}
+		for _, face := range levelFaces {
+			level.pixels = append(level.pixels, face.pixels...)
+		}
+		stacked = append(stacked, level)
+	}
+
+	stacked[0].mipmaps = stacked[1:]
+	return stacked[0]
+}
+
+// Flips every face of every level upside down. Block compressed data can only be flipped when the
+// format allows it, otherwise it has to be stored bottom row first already
+func flipTexture(data *textureData) error {
+	levels := append([]*textureData{data}, data.mipmaps...)
+
+	if data.format.compressed() {
+		for _, level := range levels {
+			if level.height > 4 && level.height%4 != 0 || data.format >= textureBC6H {
+				return fmt.Errorf("Can't flip %dx%d %v blocks, store the texture bottom row first (ktx2 orientation \"ru\") instead", level.width, level.height, data.format)
+			}
+		}
+	}
+
+	for _, level := range levels {
+		faceSize := data.format.imageSize(int(level.width), int(level.height))
+		for face := 0; face < level.faceCount(); face++ {
+			pixels := level.pixels[face*faceSize : (face+1)*faceSize]
+			if data.format.compressed() {
+				flipBlocks(data.format, pixels, int(level.width), int(level.height))
+			} else {
+				flipRows(pixels, level.rowBytes())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Decodes block compressed data (every level and face) into plain pixels
+func decompressTexture(data *textureData) (*textureData, error) {
+	if !data.format.compressed() {
+		return data, nil
+	}
+
+	levels := append([]*textureData{data}, data.mipmaps...)
+	var decoded []*textureData
+
+	for _, level := range levels {
+		width, height := int(level.width), int(level.height)
+		faceSize := data.format.imageSize(width, height)
+		decodedLevel := &textureData{
+			width:   level.width,
+			height:  level.height,
+			format:  data.format.decodedFormat(),
+			srgb:    level.srgb,
+			layers:  level.layers,
+			cubemap: level.cubemap,
+		}
+
+		for face := 0; face < level.faceCount(); face++ {
+			pixels, err := decodeBlocks(data.format, level.pixels[face*faceSize:(face+1)*faceSize], width, height)
+			if err != nil {
+				return nil, err
+			}
+			decodedLevel.pixels = append(decodedLevel.pixels, pixels...)
+		}
+
+		decoded = append(decoded, decodedLevel)
+	}
+
+	decoded[0].mipmaps = decoded[1:]
+	return decoded[0], nil
+}
+
+// Converts a decoded image into flipped, tightly packed pixels. The formats the stdlib decoders
+// actually produce get copied directly, everything else goes through draw.Draw first
+func imageToTexture(source image.Image) *textureData {
+	bounds := source.Bounds()
+	data := &textureData{
+		width:  int32(bounds.Dx()),
+		height: int32(bounds.Dy()),
+	}
+
+	switch source := source.(type) {
+	case *image.Gray:
+		data.format = textureGray
+		data.pixels = copyRowsFlipped(source.Pix, source.Stride, bounds.Dx(), bounds.Dy(), source.PixOffset(bounds.Min.X, bounds.Min.Y))
+	case *image.NRGBA:
+		data.format = textureRGBA
+		data.pixels = copyRowsFlipped(source.Pix, source.Stride, bounds.Dx()*4, bounds.Dy(), source.PixOffset(bounds.Min.X, bounds.Min.Y))
+	case *image.RGBA:
+		data.format = textureRGBA
+		data.pixels = copyRowsFlipped(source.Pix, source.Stride, bounds.Dx()*4, bounds.Dy(), source.PixOffset(bounds.Min.X, bounds.Min.Y))
+		unpremultiply(data.pixels)
+	case *image.YCbCr:
+		data.format = textureRGB
+		data.pixels = yCbCrToRGB(source)
+	case *image.Paletted:
+		data.format, data.pixels = palettedToPixels(source)
+	default:
+		converted := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+		draw.Draw(converted, converted.Bounds(), source, bounds.Min, draw.Src)
+		data.format = textureRGBA
+		data.pixels = copyRowsFlipped(converted.Pix, converted.Stride, bounds.Dx()*4, bounds.Dy(), 0)
+	}
+
+	return data
+}
+
+// Copies height rows of rowBytes each, last row first
+func copyRowsFlipped(source []uint8, stride int, rowBytes int, height int, offset int) []uint8 {
+	pixels := make([]uint8, rowBytes*height)
+	for y := 0; y < height; y++ {
+		start := offset + (height-1-y)*stride
+		copy(pixels[y*rowBytes:(y+1)*rowBytes], source[start:start+rowBytes])
+	}
+	return pixels
+}
+
+// Flips tightly packed rows in place
+func flipRows(pixels []uint8, rowBytes int) {
+	height := len(pixels) / rowBytes
+	row := make([]uint8, rowBytes)
+
+	for top, bottom := 0, height-1; top < bottom; top, bottom = top+1, bottom-1 {
+		topRow := pixels[top*rowBytes : (top+1)*rowBytes]
+		bottomRow := pixels[bottom*rowBytes : (bottom+1)*rowBytes]
+		copy(row, topRow)
+		copy(topRow, bottomRow)
+		copy(bottomRow, row)
+	}
+}
+
+// image.RGBA is premultiplied, textures aren't
+func unpremultiply(pixels []uint8) {
+	for i := 0; i < len(pixels); i += 4 {
+		alpha := uint32(pixels[i+3])
+		if alpha == 0xff || alpha == 0 {
+			continue
+		}
+		pixels[i+0] = uint8(uint32(pixels[i+0]) * 0xff / alpha)
+		pixels[i+1] = uint8(uint32(pixels[i+1]) * 0xff / alpha)
+		pixels[i+2] = uint8(uint32(pixels[i+2]) * 0xff / alpha)
+	}
+}
+
+// Multiplies the color by alpha, for textures that get drawn with premultiplied blending. srgb colors get
+// multiplied in linear space so they come out right once the gpu decodes them again. Only rgba textures
+// have anything to multiply
+func premultiplyAlpha(data *textureData) {
+	if data.format != textureRGBA {
+		return
+	}
+
+	pixels := data.pixels
+	for i := 0; i < len(pixels); i += 4 {
+		alpha := pixels[i+3]
+		if alpha == 0xff {
+			continue
+		}
+		for c := i; c < i+3; c++ {
+			if data.srgb {
+				pixels[c] = quantize(linearToSRGB(float64(srgbToLinearTable[pixels[c]]) * float64(alpha) / 255))
+			} else {
+				pixels[c] = uint8((uint32(pixels[c])*uint32(alpha) + 127) / 255)
+			}
+		}
+	}
+}
+
+// jpegs decode to YCbCr, converting them straight to RGB skips the alpha channel they don't have anyway
+func yCbCrToRGB(source *image.YCbCr) []uint8 {
+	bounds := source.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	pixels := make([]uint8, width*height*3)
+
+	// how much the chroma planes are subsampled, as shifts
+	var shiftX, shiftY uint
+	switch source.SubsampleRatio {
+	case image.YCbCrSubsampleRatio444:
+	case image.YCbCrSubsampleRatio422:
+		shiftX = 1
+	case image.YCbCrSubsampleRatio420:
+		shiftX, shiftY = 1, 1
+	case image.YCbCrSubsampleRatio440:
+		shiftY = 1
+	default:
+		// 4:1:1 and 4:1:0 are rare enough to not bother
+		for y := 0; y < height; y++ {
+			row := pixels[(height-1-y)*width*3:]
+			for x := 0; x < width; x++ {
+				yi := source.YOffset(bounds.Min.X+x, bounds.Min.Y+y)
+				ci := source.COffset(bounds.Min.X+x, bounds.Min.Y+y)
+				row[x*3+0], row[x*3+1], row[x*3+2] = color.YCbCrToRGB(source.Y[yi], source.Cb[ci], source.Cr[ci])
+			}
+		}
+		return pixels
+	}
+
+	for y := 0; y < height; y++ {
+		lumaRow := source.Y[source.YOffset(bounds.Min.X, bounds.Min.Y+y):]
+		// same as COffset, minus the x part which changes per pixel
+		chromaRow := (((bounds.Min.Y+y)>>shiftY)-(source.Rect.Min.Y>>shiftY))*source.CStride - (source.Rect.Min.X >> shiftX)
+		row := pixels[(height-1-y)*width*3 : (height-y)*width*3]
+
+		for x := 0; x < width; x++ {
+			ci := chromaRow + (bounds.Min.X+x)>>shiftX
+
+			// same math as color.YCbCrToRGB, inlined because the function call per pixel is most of the cost
+			luma := int32(lumaRow[x]) * 0x10101
+			cb := int32(source.Cb[ci]) - 128
+			cr := int32(source.Cr[ci]) - 128
+
+			r := luma + 91881*cr
+			g := luma - 22554*cb - 46802*cr
+			b := luma + 116130*cb
+
+			row[x*3+0] = clampYCbCr(r)
+			row[x*3+1] = clampYCbCr(g)
+			row[x*3+2] = clampYCbCr(b)
+		}
+	}
+
+	return pixels
+}
+
+func clampYCbCr(value int32) uint8 {
+	if uint32(value)&0xff000000 == 0 {
+		return uint8(value >> 16)
+	}
+	return uint8(^(value >> 31))
+}
+
+// gifs and 8 bit pngs, the palette only gets converted once
+func palettedToPixels(source *image.Paletted) (textureFormat, []uint8) {
+	var palette [256][4]uint8
+	opaque := true
+	for i, entry := range source.Palette {
+		nrgba := color.NRGBAModel.Convert(entry).(color.NRGBA)
+		palette[i] = [4]uint8{nrgba.R, nrgba.G, nrgba.B, nrgba.A}
+		if nrgba.A != 0xff {
+			opaque = false
+		}
+	}
+
+	format := textureRGBA
+	if opaque {
+		format = textureRGB
+	}
+	channels := format.channels()
+
+	bounds := source.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	pixels := make([]uint8, width*height*channels)
+
+	for y := 0; y < height; y++ {
+		sourceRow := source.Pix[source.PixOffset(bounds.Min.X, bounds.Min.Y+y):]
+		row := pixels[(height-1-y)*width*channels:]
+		for x := 0; x < width; x++ {
+			copy(row[x*channels:x*channels+channels], palette[sourceRow[x]][:channels])
+		}
+	}
+
+	return format, pixels
+}
+
+// Whether the driver can sample a compressed format, s3tc isn't core so in theory it can be missing
+var compressedFormatSupport = map[int32]bool{}
+
+func compressedFormatSupported(internalFormat int32) bool {
+	supported, ok := compressedFormatSupport[internalFormat]
+	if !ok {
+		var value int32
+		gl.GetInternalformativ(gl.TEXTURE_2D, uint32(internalFormat), gl.INTERNALFORMAT_SUPPORTED, 1, &value)
+		supported = value == gl.TRUE
+		compressedFormatSupport[internalFormat] = supported
+	}
+	return supported
+}
+
+// (Re)uploads decoded pixels into an existing texture object, which has to be bound to data.target()
+// already or not at all. Color textures get uploaded as srgb unless srgb is false. Only fails when a
+// compressed texture the driver can't sample can't be decoded on the cpu either, the texture object is
+// left alone then. Returns how many bytes of video memory the levels take up
+func uploadTexture(texture uint32, data *textureData, srgb bool) (int, error) {
+	internalFormat, pixelFormat := data.format.glFormats(srgb && data.srgb)
+
+	if data.format.compressed() && !compressedFormatSupported(internalFormat) {
+		decompressed, err := decompressTexture(data)
+		if err != nil {
+			return 0, fmt.Errorf("%v textures aren't supported by the driver and decoding them on the cpu failed: %v", data.format, err)
+		}
+		util.ThrowWarning(fmt.Sprintf("%v textures aren't supported by the driver, decoding them on the cpu instead", data.format))
+		data = decompressed
+		internalFormat, pixelFormat = data.format.glFormats(srgb && data.srgb)
+	}
+
+	// filtering and wrapping come from the sampler object that gets bound with the texture
+	target := data.target()
+	gl.BindTexture(target, texture)
+
+	// grayscale textures only have a red channel, make them sample as gray instead of red
+	if data.format.decodedFormat() == textureGray {
+		swizzle := []int32{gl.RED, gl.RED, gl.RED, gl.ONE}
+		gl.TexParameteriv(target, gl.TEXTURE_SWIZZLE_RGBA, &swizzle[0])
+	} else {
+		swizzle := []int32{gl.RED, gl.GREEN, gl.BLUE, gl.ALPHA}
+		gl.TexParameteriv(target, gl.TEXTURE_SWIZZLE_RGBA, &swizzle[0])
+	}
+
+	// rows of RGB and gray textures aren't necessarily a multiple of 4 bytes long
+	gl.PixelStorei(gl.UNPACK_ALIGNMENT, 1)
+
+	levels := append([]*textureData{data}, data.mipmaps...)
+	gl.TexParameteri(target, gl.TEXTURE_BASE_LEVEL, 0)
+	gl.TexParameteri(target, gl.TEXTURE_MAX_LEVEL, int32(len(levels)-1))
+
+	gpuBytes := 0
+	for level, levelData := range levels {
+		uploadTextureLevel(target, int32(level), internalFormat, pixelFormat, levelData)
+		gpuBytes += levelData.gpuSize(internalFormat)
+	}
+	return gpuBytes, nil
+}
+
+// what a level takes up once it's uploaded with the given internal format. Compressed blocks stay the
+// way they are, drivers store three channel formats with a fourth channel
+func (data *textureData) gpuSize(internalFormat int32) int {
+	if data.format.compressed() {
+		return len(data.pixels)
+	}
+
+	texelBytes := 4
+	switch internalFormat {
+	case gl.R8:
+		texelBytes = 1
+	case gl.RGB16F:
+		texelBytes = 4 * 2
+	case gl.RGB32F:
+		texelBytes = 4 * 4
+	}
+	return int(data.width) * int(data.height) * data.faceCount() * texelBytes
+}
+
+func uploadTextureLevel(target uint32, level int32, internalFormat int32, pixelFormat uint32, data *textureData) {
+	compressed := data.format.compressed()
+
+	switch target {
+	case gl.TEXTURE_2D_ARRAY, gl.TEXTURE_CUBE_MAP_ARRAY:
+		// every layer (and face) in one go
+		depth := int32(data.faceCount())
+		if compressed {
+			gl.CompressedTexImage3D(target, level, uint32(internalFormat), data.width, data.height, depth, 0, int32(len(data.pixels)), gl.Ptr(data.pixels))
+		} else {
+			gl.TexImage3D(target, level, internalFormat, data.width, data.height, depth, 0, pixelFormat, data.format.pixelType(), gl.Ptr(data.pixels))
+		}
+
+	case gl.TEXTURE_CUBE_MAP:
+		faceSize := len(data.pixels) / 6
+		for face := 0; face < 6; face++ {
+			faceTarget := uint32(gl.TEXTURE_CUBE_MAP_POSITIVE_X + face)
+			pixels := data.pixels[face*faceSize : (face+1)*faceSize]
+			if compressed {
+				gl.CompressedTexImage2D(faceTarget, level, uint32(internalFormat), data.width, data.height, 0, int32(faceSize), gl.Ptr(pixels))
+			} else {
+				gl.TexImage2D(faceTarget, level, internalFormat, data.width, data.height, 0, pixelFormat, data.format.pixelType(), gl.Ptr(pixels))
+			}
+		}
+
+	default:
+		if compressed {
+			gl.CompressedTexImage2D(target, level, uint32(internalFormat), data.width, data.height, 0, int32(len(data.pixels)), gl.Ptr(data.pixels))
+		} else {
+			gl.TexImage2D(target, level, internalFormat, data.width, data.height, 0, pixelFormat, data.format.pixelType(), gl.Ptr(data.pixels))
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+var embeddedAssets sync.Once
+
+func loadTestAsset(b testing.TB, name string) []byte {
+	embeddedAssets.Do(assetFS.AddEmbedded)
+	data, err := loadAsset(name)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return data
+}
+
+// The slow way every fast path in imageToTexture has to agree with, one At call per pixel
+func genericImageToTexture(source image.Image) *textureData {
+	bounds := source.Bounds()
+	data := &textureData{width: int32(bounds.Dx()), height: int32(bounds.Dy()), format: textureRGBA}
+	for y := bounds.Max.Y - 1; y >= bounds.Min.Y; y-- {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			pixel := color.NRGBAModel.Convert(source.At(x, y)).(color.NRGBA)
+			data.pixels = append(data.pixels, pixel.R, pixel.G, pixel.B, pixel.A)
+		}
+	}
+	return data
+}
+
+// gray and rgb as rgba, to compare them with the generic path
+func expandToRGBA(data *textureData) []uint8 {
+	var pixels []uint8
+	channels := data.format.channels()
+	for i := 0; i < len(data.pixels); i += channels {
+		switch channels {
+		case 1:
+			pixels = append(pixels, data.pixels[i], data.pixels[i], data.pixels[i], 0xff)
+		case 3:
+			pixels = append(pixels, data.pixels[i], data.pixels[i+1], data.pixels[i+2], 0xff)
+		default:
+			pixels = append(pixels, data.pixels[i:i+4]...)
+		}
+	}
+	return pixels
+}
+
+func TestImageToTextureFastPaths(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	bounds := image.Rect(0, 0, 7, 5)
+	// the fast paths have to handle images that don't start at 0, 0
+	inside := image.Rect(1, 2, 6, 5)
+
+	gray := image.NewGray(bounds)
+	random.Read(gray.Pix)
+
+	nrgba := image.NewNRGBA(bounds)
+	random.Read(nrgba.Pix)
+
+	rgba := image.NewRGBA(bounds)
+	random.Read(rgba.Pix)
+	for i := 0; i < len(rgba.Pix); i += 4 {
+		// premultiplied colors can't be brighter than alpha
+		for c := 0; c < 3; c++ {
+			rgba.Pix[i+c] = uint8(int(rgba.Pix[i+c]) * int(rgba.Pix[i+3]) / 255)
+		}
+	}
+	// fully transparent stays black
+	copy(rgba.Pix, []uint8{0, 0, 0, 0})
+
+	opaquePalette := color.Palette{color.Black, color.White, color.RGBA{0xff, 0, 0, 0xff}, color.Gray{0x40}}
+	paletted := image.NewPaletted(bounds, opaquePalette)
+	translucent := image.NewPaletted(bounds, append(color.Palette{color.NRGBA{0x10, 0x20, 0x30, 0x80}, color.Transparent}, opaquePalette...))
+	for i := range paletted.Pix {
+		paletted.Pix[i] = uint8(random.Intn(len(opaquePalette)))
+		translucent.Pix[i] = uint8(random.Intn(len(translucent.Palette)))
+	}
+
+	type subImager interface {
+		image.Image
+		SubImage(image.Rectangle) image.Image
+	}
+	tests := []struct {
+		name      string
+		image     subImager
+		format    textureFormat
+		tolerance int // unpremultiplying rounds a bit differently than the color package
+	}{
+		{"gray", gray, textureGray, 0},
+		{"nrgba", nrgba, textureRGBA, 0},
+		{"rgba", rgba, textureRGBA, 1},
+		{"paletted", paletted, textureRGB, 0},
+		{"paletted with alpha", translucent, textureRGBA, 0},
+	}
+	for _, ratio := range []image.YCbCrSubsampleRatio{
+		image.YCbCrSubsampleRatio444, image.YCbCrSubsampleRatio422, image.YCbCrSubsampleRatio420,
+		image.YCbCrSubsampleRatio440, image.YCbCrSubsampleRatio411, image.YCbCrSubsampleRatio410,
+	} {
+		yCbCr := image.NewYCbCr(bounds, ratio)
+		random.Read(yCbCr.Y)
+		random.Read(yCbCr.Cb)
+		random.Read(yCbCr.Cr)
+		tests = append(tests, struct {
+			name      string
+			image     subImager
+			format    textureFormat
+			tolerance int
+		}{"ycbcr " + ratio.String(), yCbCr, textureRGB, 0})
+	}
+
+	for _, test := range tests {
+		for _, source := range []image.Image{test.image, test.image.SubImage(inside)} {
+			got := imageToTexture(source)
+			want := genericImageToTexture(source)
+
+			size := source.Bounds().Size()
+			if got.format != test.format || got.width != int32(size.X) || got.height != int32(size.Y) {
+				t.Errorf("%s %v: got a %dx%d %v texture, want %dx%d %v", test.name, source.Bounds(), got.width, got.height, got.format, size.X, size.Y, test.format)
+				continue
+			}
+
+			pixels := expandToRGBA(got)
+			for i := range want.pixels {
+				difference := int(pixels[i]) - int(want.pixels[i])
+				if difference < -test.tolerance || difference > test.tolerance {
+					t.Errorf("%s %v: texel %d is %v, want %v", test.name, source.Bounds(), i/4, pixels[i/4*4:i/4*4+4], want.pixels[i/4*4:i/4*4+4])
+					break
+				}
+			}
+		}
+	}
+}
+
+func benchmarkTextureDecode(b *testing.B, name string) {
+	imageBytes := loadTestAsset(b, name)
+	decodedImage, _, err := image.Decode(bytes.NewReader(imageBytes))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("convert", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			imageToTexture(decodedImage)
+		}
+	})
+	// what the fast paths are up against
+	b.Run("convert generic", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			genericImageToTexture(decodedImage)
+		}
+	})
+	b.Run("decode+convert", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			decoded, _, _ := image.Decode(bytes.NewReader(imageBytes))
+			imageToTexture(decoded)
+		}
+	})
+	b.Run("decode+convert+mipmaps", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := decodeTexture(imageBytes, defaultTextureDescriptor()); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkDecodeCat(b *testing.B) {
+	benchmarkTextureDecode(b, "assets/cat.png")
+}
+
+func BenchmarkDecodeMorgana(b *testing.B) {
+	benchmarkTextureDecode(b, "assets/morgana.jpg")
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+
+	gl "github.com/go-gl/gl/v4.6-core/gl"
+)
+
+// Texture arrays from regular images, listed in a .array file. Every layer gets resized to the same size
+// (the biggest one unless the file says otherwise) since all layers of an array have to match
+
+// a .array file is json, the layer images are relative to it
+type arrayFile struct {
+	Layers []string `json:"layers"`
+	Width  int      `json:"width"`
+	Height int      `json:"height"`
+}
+
+// Decodes a .array file and the layers it points to
+func decodeArrayFile(name string, data []byte, descriptor TextureDescriptor) (*textureData, error) {
+	var array arrayFile
+	if err := json.Unmarshal(data, &array); err != nil {
+		return nil, err
+	}
+	if len(array.Layers) == 0 {
+		return nil, fmt.Errorf("%s doesn't have any layers", name)
+	}
+	if array.Width < 0 || array.Height < 0 {
+		return nil, fmt.Errorf("%s is %dx%d", name, array.Width, array.Height)
+	}
+
+	// the layers get their mipmaps once they're put together
+	layerDescriptor := descriptor
+	layerDescriptor.MipFilter = "none"
+	layerDescriptor.Cubemap = ""
+
+	layers := make([]*textureData, len(array.Layers))
+	for i, layerName := range array.Layers {
+		imageBytes, err := loadAsset(path.Join(path.Dir(name), layerName))
+		if err != nil {
+			return nil, err
+		}
+		layer, err := decodeTexture(imageBytes, layerDescriptor)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to decode layer %s: %v", layerName, err)
+		}
+		if layer.format.compressed() || layer.faceCount() > 1 || len(layer.pixels) == 0 {
+			return nil, fmt.Errorf("Layer %s has to be a plain image", layerName)
+		}
+		layers[i] = layer
+	}
+
+	width, height := int32(array.Width), int32(array.Height)
+	for _, layer := range layers {
+		if array.Width == 0 && layer.width > width {
+			width = layer.width
+		}
+		if array.Height == 0 && layer.height > height {
+			height = layer.height
+		}
+	}
+
+	// jpegs are rgb and pngs usually rgba, mixed layers all become rgba
+	format := layers[0].format
+	for _, layer := range layers {
+		if layer.format == format {
+			continue
+		}
+		if layer.format.float() || format.float() {
+			return nil, fmt.Errorf("Layers of %s mix float and 8 bit images", name)
+		}
+		format = textureRGBA
+	}
+
+	options := descriptor.mipmapOptions()
+	for i, layer := range layers {
+		if layer.format != format {
+			layer = toRGBA(layer)
+			layer.srgb = layers[i].srgb
+		}
+		if layer.width != width || layer.height != height {
+			options.srgb = layer.srgb
+			resized := resampleTexture(layer, int(width), int(height), options.filter, options)
+			resized.srgb = layer.srgb
+			layer = resized
+		}
+		layers[i] = layer
+	}
+
+	stacked := stackFaces(layers, descriptor)
+	for _, level := range append([]*textureData{stacked}, stacked.mipmaps...) {
+		level.layers = len(layers)
+	}
+	return stacked, nil
+}
+
+// Gives every instance drawn with the vao its own layer of an array, instance i gets layer i % layers.
+// The layer is attribute 2, it only advances once per instance. Draws that start somewhere other than
+// the first instance need a base instance, gl_InstanceID doesn't know about it but the attribute does
+func uploadInstanceLayers(vao uint32, buffer uint32, instances int32, layers int32) {
+	indices := make([]int32, instances)
+	for i := range indices {
+		indices[i] = int32(i) % layers
+	}
+
+	gl.BindVertexArray(vao)
+	gl.BindBuffer(gl.ARRAY_BUFFER, buffer)
+	gl.BufferData(gl.ARRAY_BUFFER, len(indices)*4, gl.Ptr(indices), gl.STATIC_DRAW)
+
+	gl.EnableVertexAttribArray(2)
+	gl.VertexAttribIPointerWithOffset(2, 1, gl.INT, 0, 0)
+	gl.VertexAttribDivisor(2, 1)
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+// Truevision TGA, still what a lot of game assets come as. Colormapped, truecolor and grayscale images,
+// uncompressed or run length encoded, with the origin in any corner
+
+const tgaHeaderSize = 18
+
+const (
+	tgaColormapped    = 1
+	tgaTruecolor      = 2
+	tgaGrayscale      = 3
+	tgaRLEColormapped = 9
+	tgaRLETruecolor   = 10
+	tgaRLEGrayscale   = 11
+)
+
+// tga files don't start with a signature, the closest thing is the colormap type followed by the image
+// type. That's specific enough since every other format has a real signature that gets checked first
+func init() {
+	for _, imageType := range []byte{tgaColormapped, tgaTruecolor, tgaGrayscale, tgaRLEColormapped, tgaRLETruecolor, tgaRLEGrayscale} {
+		colormapTypes := []byte{0, 1}
+		if imageType == tgaColormapped || imageType == tgaRLEColormapped {
+			colormapTypes = []byte{1}
+		}
+		for _, colormapType := range colormapTypes {
+			image.RegisterFormat("tga", string([]byte{'?', colormapType, imageType}), decodeTGA, decodeTGAConfig)
+		}
+	}
+}
+
+type tgaHeader struct {
+	idLength       int
+	colormapType   int
+	imageType      int
+	colormapFirst  int
+	colormapLength int
+	colormapDepth  int
+	width          int
+	height         int
+	depth          int
+	descriptor     byte
+}
+
+func readTGAHeader(reader io.Reader) (tgaHeader, error) {
+	var data [tgaHeaderSize]byte
+	if _, err := io.ReadFull(reader, data[:]); err != nil {
+		return tgaHeader{}, err
+	}
+
+	header := tgaHeader{
+		idLength:       int(data[0]),
+		colormapType:   int(data[1]),
+		imageType:      int(data[2]),
+		colormapFirst:  int(binary.LittleEndian.Uint16(data[3:])),
+		colormapLength: int(binary.LittleEndian.Uint16(data[5:])),
+		colormapDepth:  int(data[7]),
+		width:          int(binary.LittleEndian.Uint16(data[12:])),
+		height:         int(binary.LittleEndian.Uint16(data[14:])),
+		depth:          int(data[16]),
+		descriptor:     data[17],
+	}
+
+	if header.width == 0 || header.height == 0 || header.width > maxTextureSize || header.height > maxTextureSize {
+		return header, fmt.Errorf("TGA image is %dx%d", header.width, header.height)
+	}
+
+	switch header.imageType &^ 8 {
+	case tgaColormapped:
+		if header.colormapType != 1 || header.depth != 8 && header.depth != 16 {
+			return header, fmt.Errorf("TGA colormapped images need a colormap and 8 or 16 bit indices")
+		}
+	case tgaTruecolor:
+		if header.depth != 15 && header.depth != 16 && header.depth != 24 && header.depth != 32 {
+			return header, fmt.Errorf("%d bit truecolor TGA images aren't supported", header.depth)
+		}
+	case tgaGrayscale:
+		if header.depth != 8 && header.depth != 16 {
+			return header, fmt.Errorf("%d bit grayscale TGA images aren't supported", header.depth)
+		}
+	default:
+		return header, fmt.Errorf("TGA image type %d isn't supported", header.imageType)
+	}
+
+	if header.colormapType == 1 {
+		switch header.colormapDepth {
+		case 15, 16, 24, 32:
+		default:
+			return header, fmt.Errorf("%d bit TGA colormaps aren't supported", header.colormapDepth)
+		}
+	}
+
+	return header, nil
+}
+
+func (header tgaHeader) gray() bool {
+	return header.imageType&^8 == tgaGrayscale && header.depth == 8
+}
+
+func decodeTGAConfig(reader io.Reader) (image.Config, error) {
+	header, err := readTGAHeader(reader)
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	model := color.NRGBAModel
+	if header.gray() {
+		model = color.GrayModel
+	}
+	return image.Config{ColorModel: model, Width: header.width, Height: header.height}, nil
+}
+
+// 15 and 16 bit colors are 5 bits per channel, the top bit is alpha but only counts when the header
+// says there's one bit of alpha
+func tgaColor(data []byte, depth int, alphaBits int) color.NRGBA {
+	switch depth {
+	case 15, 16:
+		value := binary.LittleEndian.Uint16(data)
+		expand := func(bits uint16) uint8 {
+			return uint8(bits<<3 | bits>>2)
+		}
+		alpha := uint8(0xff)
+		if depth == 16 && alphaBits == 1 && value&0x8000 == 0 {
+			alpha = 0
+		}
+		return color.NRGBA{expand(value >> 10 & 31), expand(value >> 5 & 31), expand(value & 31), alpha}
+	case 24:
+		return color.NRGBA{data[2], data[1], data[0], 0xff}
+	}
+	return color.NRGBA{data[2], data[1], data[0], data[3]}
+}
+
+func decodeTGA(reader io.Reader) (image.Image, error) {
+	buffered := bufio.NewReader(reader)
+	header, err := readTGAHeader(buffered)
+	if err != nil {
+		return nil, err
+	}
+	alphaBits := int(header.descriptor & 15)
+
+	if _, err := buffered.Discard(header.idLength); err != nil {
+		return nil, err
+	}
+
+	// truecolor images can have a colormap too, which just gets skipped
+	var colormap []color.NRGBA
+	if header.colormapType == 1 {
+		entryBytes := (header.colormapDepth + 7) / 8
+		entries := make([]byte, header.colormapLength*entryBytes)
+		if _, err := io.ReadFull(buffered, entries); err != nil {
+			return nil, fmt.Errorf("TGA colormap is truncated")
+		}
+		for i := 0; i < header.colormapLength; i++ {
+			colormap = append(colormap, tgaColor(entries[i*entryBytes:], header.colormapDepth, alphaBits))
+		}
+	}
+
+	// any file can look like a tga, so the pixels only get allocated as they're read instead of
+	// believing the header
+	pixelBytes := (header.depth + 7) / 8
+	size := header.width * header.height * pixelBytes
+	var pixels []byte
+	if header.imageType&8 == 0 {
+		if pixels, err = io.ReadAll(io.LimitReader(buffered, int64(size))); err != nil || len(pixels) < size {
+			return nil, fmt.Errorf("TGA pixels are truncated")
+		}
+	} else if pixels, err = readTGARLE(buffered, size, pixelBytes); err != nil {
+		return nil, err
+	}
+
+	// bottom left is the default origin, bit 4 flips x and bit 5 flips y
+	rightToLeft := header.descriptor&0x10 != 0
+	topToBottom := header.descriptor&0x20 != 0
+	position := func(i int) (int, int) {
+		x, y := i%header.width, i/header.width
+		if rightToLeft {
+			x = header.width - 1 - x
+		}
+		if !topToBottom {
+			y = header.height - 1 - y
+		}
+		return x, y
+	}
+
+	bounds := image.Rect(0, 0, header.width, header.height)
+	if header.gray() {
+		result := image.NewGray(bounds)
+		for i, value := range pixels {
+			x, y := position(i)
+			result.Pix[result.PixOffset(x, y)] = value
+		}
+		return result, nil
+	}
+
+	result := image.NewNRGBA(bounds)
+	for i := 0; i < header.width*header.height; i++ {
+		pixel := pixels[i*pixelBytes : (i+1)*pixelBytes]
+
+		var value color.NRGBA
+		switch header.imageType &^ 8 {
+		case tgaColormapped:
+			index := int(pixel[0])
+			if pixelBytes == 2 {
+				index = int(binary.LittleEndian.Uint16(pixel))
+			}
+			index -= header.colormapFirst
+			if index < 0 || index >= len(colormap) {
+				return nil, fmt.Errorf("TGA colormap index %d is out of range", index+header.colormapFirst)
+			}
+			value = colormap[index]
+		case tgaGrayscale:
+			// 16 bit grayscale is gray + alpha
+			value = color.NRGBA{pixel[0], pixel[0], pixel[0], pixel[1]}
+		default:
+			value = tgaColor(pixel, header.depth, alphaBits)
+		}
+
+		x, y := position(i)
+		result.SetNRGBA(x, y, value)
+	}
+
+	return result, nil
+}
+
+// Every packet is a header byte, the top bit says whether it's a run of one pixel or that many raw
+// pixels, the rest is the count minus one. Packets can go across rows. The pixels grow packet by packet,
+// a run is at most 128 pixels so they can't get much bigger than the data that's actually there
+func readTGARLE(reader *bufio.Reader, size int, pixelBytes int) ([]byte, error) {
+	pixels := make([]byte, 0, minInt(size, 1<<16))
+	pixel := make([]byte, pixelBytes)
+	for len(pixels) < size {
+		packet, err := reader.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("TGA pixels are truncated")
+		}
+
+		count := int(packet&0x7f) + 1
+		if len(pixels)+count*pixelBytes > size {
+			return nil, fmt.Errorf("TGA packet goes past the end of the image")
+		}
+
+		for i := 0; i < count; i++ {
+			// raw packets read every pixel, runs only the first one
+			if i == 0 || packet&0x80 == 0 {
+				if _, err := io.ReadFull(reader, pixel); err != nil {
+					return nil, fmt.Errorf("TGA pixels are truncated")
+				}
+			}
+			pixels = append(pixels, pixel...)
+		}
+	}
+	return pixels, nil
+}
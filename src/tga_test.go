@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+type tgaFile struct {
+	imageType, depth int
+	width, height    int
+	descriptor       byte
+	colormapFirst    int
+	colormapDepth    int
+	colormap         []byte
+}
+
+func (file tgaFile) bytes(pixels ...byte) []byte {
+	data := make([]byte, tgaHeaderSize)
+	data[0] = 3 // an id, which gets skipped
+	if file.colormap != nil {
+		data[1] = 1
+		entryBytes := (file.colormapDepth + 7) / 8
+		binary.LittleEndian.PutUint16(data[3:], uint16(file.colormapFirst))
+		binary.LittleEndian.PutUint16(data[5:], uint16(len(file.colormap)/entryBytes))
+		data[7] = byte(file.colormapDepth)
+	}
+	data[2] = byte(file.imageType)
+	binary.LittleEndian.PutUint16(data[12:], uint16(file.width))
+	binary.LittleEndian.PutUint16(data[14:], uint16(file.height))
+	data[16] = byte(file.depth)
+	data[17] = file.descriptor
+
+	data = append(data, "id!"...)
+	data = append(data, file.colormap...)
+	return append(data, pixels...)
+}
+
+// decodes through image.Decode, which also checks that the magic finds the decoder
+func decodeTestTGA(t *testing.T, name string, data []byte) image.Image {
+	t.Helper()
+	decoded, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	if format != "tga" {
+		t.Fatalf("%s: decoded as %s", name, format)
+	}
+	return decoded
+}
+
+func checkTGAPixels(t *testing.T, name string, decoded image.Image, want [][]color.NRGBA) {
+	t.Helper()
+	if size := decoded.Bounds().Size(); size.X != len(want[0]) || size.Y != len(want) {
+		t.Errorf("%s: got %v, want %dx%d", name, decoded.Bounds(), len(want[0]), len(want))
+		return
+	}
+	for y, row := range want {
+		for x, pixel := range row {
+			if got := color.NRGBAModel.Convert(decoded.At(x, y)); got != pixel {
+				t.Errorf("%s: pixel %d, %d is %v, want %v", name, x, y, got, pixel)
+			}
+		}
+	}
+}
+
+var (
+	tgaRed   = color.NRGBA{0xff, 0, 0, 0xff}
+	tgaGreen = color.NRGBA{0, 0xff, 0, 0xff}
+	tgaBlue  = color.NRGBA{0, 0, 0xff, 0xff}
+	tgaWhite = color.NRGBA{0xff, 0xff, 0xff, 0xff}
+)
+
+func TestDecodeTGAOrigins(t *testing.T) {
+	// red, green, blue, white in the order they're stored, bgr
+	pixels := []byte{0, 0, 0xff, 0, 0xff, 0, 0xff, 0, 0, 0xff, 0xff, 0xff}
+	tests := []struct {
+		name       string
+		descriptor byte
+		want       [][]color.NRGBA
+	}{
+		{"bottom left", 0, [][]color.NRGBA{{tgaBlue, tgaWhite}, {tgaRed, tgaGreen}}},
+		{"bottom right", 0x10, [][]color.NRGBA{{tgaWhite, tgaBlue}, {tgaGreen, tgaRed}}},
+		{"top left", 0x20, [][]color.NRGBA{{tgaRed, tgaGreen}, {tgaBlue, tgaWhite}}},
+		{"top right", 0x30, [][]color.NRGBA{{tgaGreen, tgaRed}, {tgaWhite, tgaBlue}}},
+	}
+	for _, test := range tests {
+		file := tgaFile{imageType: tgaTruecolor, depth: 24, width: 2, height: 2, descriptor: test.descriptor}
+		checkTGAPixels(t, test.name, decodeTestTGA(t, test.name, file.bytes(pixels...)), test.want)
+	}
+}
+
+func TestDecodeTGA(t *testing.T) {
+	translucent := color.NRGBA{1, 2, 3, 0x80}
+	transparentRed := color.NRGBA{0xff, 0, 0, 0}
+
+	tests := []struct {
+		name string
+		data []byte
+		want [][]color.NRGBA
+	}{
+		{
+			"32 bit",
+			tgaFile{imageType: tgaTruecolor, depth: 32, width: 2, height: 1, descriptor: 8}.bytes(0, 0, 0xff, 0xff, 3, 2, 1, 0x80),
+			[][]color.NRGBA{{tgaRed, translucent}},
+		},
+		{
+			// a run of 3 that goes across the rows, then 2 raw pixels and a run of 1
+			"RLE",
+			tgaFile{imageType: tgaRLETruecolor, depth: 32, width: 3, height: 2, descriptor: 0x28}.bytes(
+				0x82, 0, 0, 0xff, 0xff,
+				0x01, 0, 0xff, 0, 0xff, 3, 2, 1, 0x80,
+				0x80, 0xff, 0, 0, 0xff,
+			),
+			[][]color.NRGBA{{tgaRed, tgaRed, tgaRed}, {tgaGreen, translucent, tgaBlue}},
+		},
+		{
+			// 1 bit of alpha says the top bit counts, without it 16 bit is opaque
+			"16 bit with alpha",
+			tgaFile{imageType: tgaTruecolor, depth: 16, width: 2, height: 1, descriptor: 0x21}.bytes(0x00, 0x7c, 0x1f, 0x80),
+			[][]color.NRGBA{{transparentRed, tgaBlue}},
+		},
+		{
+			"16 bit without alpha",
+			tgaFile{imageType: tgaTruecolor, depth: 16, width: 2, height: 1, descriptor: 0x20}.bytes(0x00, 0x7c, 0x1f, 0x80),
+			[][]color.NRGBA{{tgaRed, tgaBlue}},
+		},
+		{
+			// indices start at 5, the colormap is bgr
+			"colormapped",
+			tgaFile{imageType: tgaColormapped, depth: 8, width: 3, height: 1, descriptor: 0x20, colormapFirst: 5, colormapDepth: 24,
+				colormap: []byte{0, 0, 0xff, 0xff, 0, 0}}.bytes(5, 6, 5),
+			[][]color.NRGBA{{tgaRed, tgaBlue, tgaRed}},
+		},
+		{
+			"RLE colormapped",
+			tgaFile{imageType: tgaRLEColormapped, depth: 8, width: 3, height: 1, descriptor: 0x20, colormapDepth: 24,
+				colormap: []byte{0, 0, 0xff, 0xff, 0, 0}}.bytes(0x81, 1, 0x00, 0),
+			[][]color.NRGBA{{tgaBlue, tgaBlue, tgaRed}},
+		},
+		{
+			// a truecolor image with a colormap it doesn't use
+			"unused colormap",
+			tgaFile{imageType: tgaTruecolor, depth: 24, width: 1, height: 1, colormapDepth: 16, colormap: []byte{1, 2}}.bytes(0, 0xff, 0),
+			[][]color.NRGBA{{tgaGreen}},
+		},
+		{
+			"gray and alpha",
+			tgaFile{imageType: tgaGrayscale, depth: 16, width: 1, height: 1}.bytes(0x40, 0x80),
+			[][]color.NRGBA{{{0x40, 0x40, 0x40, 0x80}}},
+		},
+	}
+
+	for _, test := range tests {
+		checkTGAPixels(t, test.name, decodeTestTGA(t, test.name, test.data), test.want)
+	}
+
+	gray := decodeTestTGA(t, "gray", tgaFile{imageType: tgaRLEGrayscale, depth: 8, width: 2, height: 1}.bytes(0x81, 0x40))
+	if gray, ok := gray.(*image.Gray); !ok || !bytes.Equal(gray.Pix, []byte{0x40, 0x40}) {
+		t.Errorf("gray: got %T %v", gray, gray)
+	}
+}
+
+func TestDecodeTGAErrors(t *testing.T) {
+	truecolor := tgaFile{imageType: tgaTruecolor, depth: 24, width: 2, height: 2}
+	rle := tgaFile{imageType: tgaRLETruecolor, depth: 24, width: 2, height: 2}
+	colormapped := tgaFile{imageType: tgaColormapped, depth: 8, width: 1, height: 1, colormapDepth: 24, colormap: []byte{0, 0, 0}}
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"empty", tgaFile{imageType: tgaTruecolor, depth: 24}.bytes(), "0x0"},
+		{"too big", tgaFile{imageType: tgaTruecolor, depth: 24, width: 65535, height: 65535}.bytes(), "65535x65535"},
+		{"unsupported depth", tgaFile{imageType: tgaTruecolor, depth: 8, width: 1, height: 1}.bytes(0), "8 bit truecolor"},
+		// the magic doesn't even match
+		{"colormapped without a colormap", tgaFile{imageType: tgaColormapped, depth: 8, width: 1, height: 1}.bytes(0), "unknown format"},
+		{"truncated", truecolor.bytes(make([]byte, 11)...), "truncated"},
+		// says it's huge but the file has nothing, that must not allocate gigabytes first
+		{"huge and truncated", tgaFile{imageType: tgaTruecolor, depth: 32, width: maxTextureSize, height: maxTextureSize}.bytes(1, 2, 3), "truncated"},
+		{"huge RLE", tgaFile{imageType: tgaRLETruecolor, depth: 32, width: maxTextureSize, height: maxTextureSize}.bytes(0xff, 1, 2, 3, 4), "truncated"},
+		{"truncated RLE", rle.bytes(0x81, 1, 2, 3), "truncated"},
+		{"truncated RLE run", rle.bytes(0x83, 1, 2), "truncated"},
+		{"RLE past the end", rle.bytes(0x84, 1, 2, 3), "past the end"},
+		{"truncated colormap", colormapped.bytes()[:tgaHeaderSize+4], "colormap is truncated"},
+		{"colormap index out of range", colormapped.bytes(1), "out of range"},
+	}
+
+	for _, test := range tests {
+		_, _, err := image.Decode(bytes.NewReader(test.data))
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got %v, want an error about %q", test.name, err, test.want)
+		}
+	}
+
+	// the decoder checks it too when it's called directly
+	noColormap := tgaFile{imageType: tgaColormapped, depth: 8, width: 1, height: 1}.bytes(0)
+	if _, err := decodeTGA(bytes.NewReader(noColormap)); err == nil || !strings.Contains(err.Error(), "need a colormap") {
+		t.Errorf("colormapped without a colormap gave %v", err)
+	}
+}
@@ -0,0 +1,444 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"main/src/util"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type vfsLayer struct {
+	name   string
+	fsys   fs.FS
+	closer io.Closer
+
+	// files in directories get edited while running (that's what hot reload is for), so a mismatch is
+	// only a warning and their manifest gets read again when the watcher sees it change
+	directory bool
+
+	manifestLock   sync.Mutex
+	manifestLoaded bool
+	manifest       *Manifest
+}
+
+func (layer *vfsLayer) isEmbedded() bool {
+	_, embedded := layer.fsys.(bindataFS)
+	return embedded
+}
+
+// nil when the layer doesn't come with a manifest
+func (layer *vfsLayer) loadManifest() *Manifest {
+	layer.manifestLock.Lock()
+	defer layer.manifestLock.Unlock()
+
+	if !layer.manifestLoaded {
+		manifest, err := loadManifest(layer.fsys)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			util.ThrowWarning(fmt.Sprintf("Ignoring manifest of %s: %v", layer.name, err))
+		}
+		layer.manifest, layer.manifestLoaded = manifest, true
+	}
+	return layer.manifest
+}
+
+// Looks assets up in a stack of filesystems, the first layer that has a file wins.
+// main sets it up as override directories first, then zip archives, then whatever is baked into bin.go,
+// so mods and patched textures don't need a rebuild
+type VFS struct {
+	layers []*vfsLayer
+
+	// check everything ReadFile gets from a directory or archive against the manifest. Only packs and
+	// archives that come with their own manifest fail on a mismatch, everything else gets a warning
+	Verify bool
+}
+
+func NewVFS() *VFS {
+	return &VFS{}
+}
+
+// the layers are searched in the order they were added in
+func (vfs *VFS) AddFS(name string, fsys fs.FS) {
+	vfs.layers = append(vfs.layers, &vfsLayer{name: name, fsys: fsys})
+}
+
+func (vfs *VFS) AddDirectory(directory string) error {
+	info, err := os.Stat(directory)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", directory)
+	}
+
+	vfs.layers = append(vfs.layers, &vfsLayer{name: directory, fsys: os.DirFS(directory), directory: true})
+	return nil
+}
+
+func (vfs *VFS) AddZip(archive string) error {
+	reader, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+
+	vfs.layers = append(vfs.layers, &vfsLayer{name: archive, fsys: reader, closer: reader})
+	return nil
+}
+
+func (vfs *VFS) AddPack(name string) error {
+	pack, err := openPack(name)
+	if err != nil {
+		return err
+	}
+
+	vfs.layers = append(vfs.layers, &vfsLayer{name: name, fsys: pack, closer: pack})
+	return nil
+}
+
+// .pack files are our own format, anything else is assumed to be a zip
+func (vfs *VFS) AddArchive(name string) error {
+	if isPackFile(name) {
+		return vfs.AddPack(name)
+	}
+	return vfs.AddZip(name)
+}
+
+func (vfs *VFS) AddEmbedded() {
+	vfs.AddFS("embedded", bindataFS{})
+}
+
+// true when there's anything besides the embedded assets, which can't change while running
+func (vfs *VFS) HasExternalLayers() bool {
+	for _, layer := range vfs.layers {
+		if !layer.isEmbedded() {
+			return true
+		}
+	}
+	return false
+}
+
+// The manifests of directories get read again the next time they're needed, for when one of them changed
+func (vfs *VFS) ReloadManifests() {
+	for _, layer := range vfs.layers {
+		if layer.directory {
+			layer.manifestLock.Lock()
+			layer.manifest, layer.manifestLoaded = nil, false
+			layer.manifestLock.Unlock()
+		}
+	}
+}
+
+func (vfs *VFS) Close() error {
+	var firstErr error
+	for _, layer := range vfs.layers {
+		if layer.closer == nil {
+			continue
+		}
+		if err := layer.closer.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	vfs.layers = nil
+	return firstErr
+}
+
+func cleanAssetPath(op string, name string) (string, error) {
+	name = path.Clean(strings.Replace(name, "\\", "/", -1))
+	name = strings.TrimPrefix(name, "/")
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return name, nil
+}
+
+// Finds the layer that provides a file, returns its name along with the file info
+func (vfs *VFS) Lookup(name string) (string, fs.FileInfo, error) {
+	name, err := cleanAssetPath("stat", name)
+	if err != nil {
+		return "", nil, err
+	}
+
+	for _, layer := range vfs.layers {
+		info, err := fs.Stat(layer.fsys, name)
+		if err == nil {
+			return layer.name, info, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", nil, err
+		}
+	}
+
+	return "", nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+func (vfs *VFS) Open(name string) (fs.File, error) {
+	name, err := cleanAssetPath("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, layer := range vfs.layers {
+		file, err := layer.fsys.Open(name)
+		if err == nil {
+			return file, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+func (vfs *VFS) Stat(name string) (fs.FileInfo, error) {
+	_, info, err := vfs.Lookup(name)
+	return info, err
+}
+
+// Unlike Open this also verifies the file against the manifest when Verify is set
+func (vfs *VFS) ReadFile(name string) ([]byte, error) {
+	name, err := cleanAssetPath("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, layer := range vfs.layers {
+		data, err := fs.ReadFile(layer.fsys, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if vfs.Verify && !layer.isEmbedded() && name != manifestName {
+			if err := vfs.verify(layer, name, data); err != nil {
+				return nil, err
+			}
+		}
+
+		return data, nil
+	}
+
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// Checks against the manifest that came with the layer, or the embedded one if it doesn't have one.
+// Override directories and layers without a manifest are patches, they're supposed to differ from the
+// embedded assets, so for those a mismatch is only worth a warning
+func (vfs *VFS) verify(layer *vfsLayer, name string, data []byte) error {
+	manifest := layer.loadManifest()
+	strict := manifest != nil && !layer.directory
+	if manifest == nil {
+		for _, other := range vfs.layers {
+			if other.isEmbedded() {
+				manifest = other.loadManifest()
+			}
+		}
+	}
+
+	if manifest == nil {
+		return nil
+	}
+
+	// new files from mods can't be in the manifest, not worth failing over
+	if !manifest.Has(name) {
+		util.ThrowWarning(fmt.Sprintf("Asset %s from %s isn't in the manifest, it can't be verified", name, layer.name))
+		return nil
+	}
+
+	err := manifest.Verify(name, layer.name, data)
+	if err != nil && !strict {
+		util.ThrowWarning(err.Error())
+		return nil
+	}
+	return err
+}
+
+// Merges the directory listings of every layer, entries from higher priority layers win
+func (vfs *VFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	name, err := cleanAssetPath("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make(map[string]fs.DirEntry)
+	found := false
+
+	for _, layer := range vfs.layers {
+		layerEntries, err := fs.ReadDir(layer.fsys, name)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+
+		found = true
+		for _, entry := range layerEntries {
+			if _, ok := entries[entry.Name()]; !ok {
+				entries[entry.Name()] = entry
+			}
+		}
+	}
+
+	if !found {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	merged := make([]fs.DirEntry, 0, len(entries))
+	for _, entry := range entries {
+		merged = append(merged, entry)
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		return merged[i].Name() < merged[j].Name()
+	})
+
+	return merged, nil
+}
+
+// Exposes the go-bindata table as an fs.FS so it can be a layer like everything else
+type bindataFS struct{}
+
+// AssetInfo decompresses the whole asset just to get to its info, and the embedded files can't change anyway
+var bindataInfoCache sync.Map
+
+type vfsFileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (fi vfsFileInfo) Name() string       { return fi.name }
+func (fi vfsFileInfo) Size() int64        { return fi.size }
+func (fi vfsFileInfo) Mode() fs.FileMode  { return fi.mode }
+func (fi vfsFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi vfsFileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi vfsFileInfo) Sys() interface{}   { return nil }
+
+func (bindataFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+
+	if name == "." {
+		return vfsFileInfo{name: ".", mode: fs.ModeDir | 0555}, nil
+	}
+
+	if info, ok := bindataInfoCache.Load(name); ok {
+		return info.(fs.FileInfo), nil
+	}
+
+	if info, err := AssetInfo(name); err == nil {
+		// bindata reports the full path as the name, fs wants just the base name
+		fileInfo := vfsFileInfo{name: path.Base(name), size: info.Size(), mode: info.Mode(), modTime: info.ModTime()}
+		bindataInfoCache.Store(name, fileInfo)
+		return fileInfo, nil
+	}
+
+	if _, err := AssetDir(name); err == nil {
+		return vfsFileInfo{name: path.Base(name), mode: fs.ModeDir | 0555}, nil
+	}
+
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+func (bindataFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	dirName := name
+	if name == "." {
+		dirName = ""
+	}
+
+	children, err := AssetDir(dirName)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	entries := make([]fs.DirEntry, 0, len(children))
+	for _, child := range children {
+		info, err := bindataFS{}.Stat(path.Join(name, child))
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(info))
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	return entries, nil
+}
+
+func (bindata bindataFS) Open(name string) (fs.File, error) {
+	info, err := bindata.Stat(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	if info.IsDir() {
+		entries, err := bindata.ReadDir(name)
+		if err != nil {
+			return nil, err
+		}
+		return &memoryDir{info: info, entries: entries}, nil
+	}
+
+	data, err := Asset(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &memoryFile{Reader: bytes.NewReader(data), info: info}, nil
+}
+
+type memoryFile struct {
+	*bytes.Reader
+	info fs.FileInfo
+}
+
+func (file *memoryFile) Stat() (fs.FileInfo, error) { return file.info, nil }
+func (file *memoryFile) Close() error               { return nil }
+
+type memoryDir struct {
+	info    fs.FileInfo
+	entries []fs.DirEntry
+}
+
+func (dir *memoryDir) Stat() (fs.FileInfo, error) { return dir.info, nil }
+func (dir *memoryDir) Close() error               { return nil }
+func (dir *memoryDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: dir.info.Name(), Err: fs.ErrInvalid}
+}
+
+func (dir *memoryDir) ReadDir(count int) ([]fs.DirEntry, error) {
+	if count <= 0 {
+		entries := dir.entries
+		dir.entries = nil
+		return entries, nil
+	}
+
+	if len(dir.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	if count > len(dir.entries) {
+		count = len(dir.entries)
+	}
+	entries := dir.entries[:count]
+	dir.entries = dir.entries[count:]
+	return entries, nil
+}
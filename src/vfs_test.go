@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+// Adds a manifest of everything that's in fsys right now
+func addManifest(t *testing.T, fsys fstest.MapFS) {
+	t.Helper()
+	manifest, err := generateManifest(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := manifest.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys[manifestName] = &fstest.MapFile{Data: data}
+}
+
+func readString(t *testing.T, vfs *VFS, name string) string {
+	t.Helper()
+	data, err := vfs.ReadFile(name)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	return string(data)
+}
+
+func TestVFSLayerOrder(t *testing.T) {
+	vfs := NewVFS()
+	vfs.AddFS("override", fstest.MapFS{
+		"assets/cat.png":        {Data: []byte("override cat")},
+		"assets/mods/extra.txt": {Data: []byte("extra")},
+	})
+	vfs.AddFS("archive", fstest.MapFS{
+		"assets/cat.png":    {Data: []byte("archive cat")},
+		"assets/burger.obj": {Data: []byte("archive burger")},
+	})
+	vfs.AddFS("base", fstest.MapFS{
+		"assets/cat.png":    {Data: []byte("base cat")},
+		"assets/burger.obj": {Data: []byte("base burger")},
+		"assets/frag.glsl":  {Data: []byte("base shader")},
+	})
+
+	tests := []struct {
+		name, layer, data string
+	}{
+		{"assets/cat.png", "override", "override cat"},
+		{"assets/burger.obj", "archive", "archive burger"},
+		{"assets/frag.glsl", "base", "base shader"},
+		{"assets/mods/extra.txt", "override", "extra"},
+		// windows separators and leading slashes end up at the same file
+		{"assets\\cat.png", "override", "override cat"},
+		{"/assets/burger.obj", "archive", "archive burger"},
+	}
+	for _, test := range tests {
+		if got := readString(t, vfs, test.name); got != test.data {
+			t.Errorf("%s is %q, want %q", test.name, got, test.data)
+		}
+		if layer, _, err := vfs.Lookup(test.name); err != nil || layer != test.layer {
+			t.Errorf("%s comes from %q (%v), want %q", test.name, layer, err, test.layer)
+		}
+	}
+
+	if _, err := vfs.ReadFile("assets/missing.png"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("reading a file no layer has gave %v", err)
+	}
+	if _, err := vfs.ReadFile("../assets/cat.png"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("reading outside of the layers gave %v", err)
+	}
+}
+
+func TestVFSReadDir(t *testing.T) {
+	vfs := NewVFS()
+	vfs.AddFS("override", fstest.MapFS{
+		"assets/cat.png":    {Data: []byte("override cat")},
+		"assets/shaders/a":  {Data: []byte("a")},
+		"other/unrelated.x": {Data: []byte("x")},
+	})
+	vfs.AddFS("base", fstest.MapFS{
+		"assets/cat.png":    {Data: []byte("base cat")},
+		"assets/burger.obj": {Data: []byte("base burger")},
+	})
+
+	entries, err := vfs.ReadDir("assets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if want := []string{"burger.obj", "cat.png", "shaders"}; !equalStrings(names, want) {
+		t.Errorf("assets has %v, want %v", names, want)
+	}
+	if !entries[2].IsDir() {
+		t.Error("shaders isn't a directory")
+	}
+
+	if _, err := vfs.ReadDir("nothing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("reading a directory no layer has gave %v", err)
+	}
+}
+
+func TestVFSOpenUsesOverride(t *testing.T) {
+	vfs := NewVFS()
+	vfs.AddFS("override", fstest.MapFS{"assets/cat.png": {Data: []byte("override cat")}})
+	vfs.AddFS("base", fstest.MapFS{"assets/cat.png": {Data: []byte("base cat")}})
+
+	data, err := fs.ReadFile(vfs, "assets/cat.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "override cat" {
+		t.Errorf("opened %q", data)
+	}
+	if info, err := vfs.Stat("assets/cat.png"); err != nil || info.Size() != int64(len("override cat")) {
+		t.Errorf("stat gave %v, %v", info, err)
+	}
+}
+
+func TestVFSDirectoryManifestCached(t *testing.T) {
+	directory := fstest.MapFS{"assets/cat.png": {Data: []byte("cat")}}
+	addManifest(t, directory)
+
+	vfs := NewVFS()
+	vfs.Verify = true
+	vfs.layers = append(vfs.layers, &vfsLayer{name: "override", fsys: directory, directory: true})
+
+	readString(t, vfs, "assets/cat.png")
+	loaded := vfs.layers[0].manifest
+	if loaded == nil {
+		t.Fatal("the directory's manifest wasn't loaded")
+	}
+
+	// a new manifest only gets picked up once the watcher says so
+	directory["assets/cat.png"] = &fstest.MapFile{Data: []byte("new cat")}
+	addManifest(t, directory)
+	readString(t, vfs, "assets/cat.png")
+	if vfs.layers[0].manifest != loaded {
+		t.Error("the manifest got read again without changing")
+	}
+
+	vfs.ReloadManifests()
+	readString(t, vfs, "assets/cat.png")
+	if manifest := vfs.layers[0].manifest; manifest == loaded || manifest.Assets["assets/cat.png"].Size != int64(len("new cat")) {
+		t.Error("the changed manifest wasn't read again")
+	}
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}